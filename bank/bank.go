@@ -0,0 +1,112 @@
+// Package bank defines how the payment gateway talks to an acquiring bank, with an Acquirer interface and
+// an HTTP implementation of it.
+package bank
+
+import (
+	"context"
+	"errors"
+
+	"github.com/celestebrant/processout-payment-gateway/money"
+)
+
+// Acquirer is an acquiring bank that the payment gateway sends payments to.
+type Acquirer interface {
+	// MakePayment asks the bank to authorize and capture the payment described by r in one step.
+	MakePayment(ctx context.Context, r MakePaymentRequest) (*MakePaymentResponse, error)
+	// Authorize asks the bank to hold the funds for the payment described by r, without capturing them.
+	// A successful authorization has status "AUTHORIZED".
+	Authorize(ctx context.Context, r MakePaymentRequest) (*MakePaymentResponse, error)
+	// Capture asks the bank to capture some or all of the funds of an authorized payment. A successful
+	// capture has status "SUCCESS".
+	Capture(ctx context.Context, r CaptureRequest) (*CaptureResponse, error)
+	// Refund asks the bank to return some or all of the captured funds of a payment. A successful refund
+	// has status "SUCCESS".
+	Refund(ctx context.Context, r RefundRequest) (*RefundResponse, error)
+	// Void asks the bank to cancel an authorized payment and release the held funds. A successful void has
+	// status "VOIDED".
+	Void(ctx context.Context, r VoidRequest) (*VoidResponse, error)
+	// SettlementCurrencies returns the ISO 4217 codes of the currencies the bank settles payments in.
+	SettlementCurrencies() []string
+}
+
+// ErrNotSent is wrapped by errors of an Acquirer that are known to have happened before the request reached
+// the bank, like a refused connection, so the bank cannot have acted on it. Any other error may have
+// happened after the bank acted on the request.
+var ErrNotSent = errors.New("the request was not sent")
+
+// RequestIDHeader is the header that an Acquirer sends the request ID in, so that the bank's records of a
+// call can be tied to the gateway request that it was made for.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx with the ID of the request it belongs to, which Acquirers pass
+// on to the bank.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request that ctx belongs to, or "" if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// DefaultSettlementCurrencies are the currencies a bank settles in unless configured otherwise.
+var DefaultSettlementCurrencies = []string{"CHF", "EUR", "GBP", "JPY", "SEK", "USD"}
+
+// MakePaymentRequest represents the assumed request data the bank API requires, including card details
+// and the money to be transacted, in minor units.
+type MakePaymentRequest struct {
+	CardNumber  string `json:"card_number"`
+	ExpiryYear  uint   `json:"expiry_year"`
+	ExpiryMonth uint   `json:"expiry_month"`
+	CVV         string `json:"cvv"`
+	money.Money
+}
+
+// MakePaymentResponse represents the assumed response the bank API returns, containing payment ID and status.
+// Reason may explain why a payment has status "FAILED".
+type MakePaymentResponse struct {
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// CaptureRequest represents the request to capture an authorized payment. The bank releases any authorized
+// funds that are not captured.
+type CaptureRequest struct {
+	PaymentID string `json:"-"`
+	// Amount in minor units of the payment's currency.
+	Amount int64 `json:"amount"`
+}
+
+// CaptureResponse represents the response to a capture request.
+type CaptureResponse struct {
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+}
+
+// RefundRequest represents the request to refund a captured payment.
+type RefundRequest struct {
+	PaymentID string `json:"-"`
+	// Amount in minor units of the payment's currency.
+	Amount int64 `json:"amount"`
+}
+
+// RefundResponse represents the response to a refund request, containing refund ID and status.
+type RefundResponse struct {
+	RefundID string `json:"refund_id"`
+	Status   string `json:"status"`
+}
+
+// VoidRequest represents the request to void an authorized payment.
+type VoidRequest struct {
+	PaymentID string `json:"-"`
+}
+
+// VoidResponse represents the response to a void request.
+type VoidResponse struct {
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+}
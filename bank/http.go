@@ -0,0 +1,166 @@
+package bank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+)
+
+// DefaultTimeout is the timeout of the http.Client used by NewHTTPClient when none is provided.
+const DefaultTimeout = 10 * time.Second
+
+// maxErrorBodyLength limits how much of an unexpected response body is included in errors.
+const maxErrorBodyLength = 512
+
+// HTTPClient is an Acquirer that calls a bank's JSON API over HTTP.
+type HTTPClient struct {
+	baseURL              string
+	settlementCurrencies []string
+	httpClient           *http.Client
+}
+
+// NewHTTPClient instantiates an HTTPClient that sends requests to the bank API at baseURL, for a bank that
+// settles in settlementCurrencies. If settlementCurrencies is empty, DefaultSettlementCurrencies is used,
+// and if httpClient is nil, a client with DefaultTimeout is used.
+func NewHTTPClient(baseURL string, settlementCurrencies []string, httpClient *http.Client) *HTTPClient {
+	if len(settlementCurrencies) == 0 {
+		settlementCurrencies = DefaultSettlementCurrencies
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &HTTPClient{
+		baseURL:              strings.TrimRight(baseURL, "/"),
+		settlementCurrencies: settlementCurrencies,
+		httpClient:           httpClient,
+	}
+}
+
+// MakePayment sends r as JSON to POST {baseURL}/payments and decodes the MakePaymentResponse.
+func (c *HTTPClient) MakePayment(ctx context.Context, r MakePaymentRequest) (*MakePaymentResponse, error) {
+	response := MakePaymentResponse{}
+	if err := c.post(ctx, "/payments", r, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Authorize sends r as JSON to POST {baseURL}/authorizations and decodes the MakePaymentResponse.
+func (c *HTTPClient) Authorize(ctx context.Context, r MakePaymentRequest) (*MakePaymentResponse, error) {
+	response := MakePaymentResponse{}
+	if err := c.post(ctx, "/authorizations", r, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Capture sends r as JSON to POST {baseURL}/payments/{id}/capture and decodes the CaptureResponse.
+func (c *HTTPClient) Capture(ctx context.Context, r CaptureRequest) (*CaptureResponse, error) {
+	response := CaptureResponse{}
+	if err := c.post(ctx, "/payments/"+url.PathEscape(r.PaymentID)+"/capture", r, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Refund sends r as JSON to POST {baseURL}/payments/{id}/refunds and decodes the RefundResponse.
+func (c *HTTPClient) Refund(ctx context.Context, r RefundRequest) (*RefundResponse, error) {
+	response := RefundResponse{}
+	if err := c.post(ctx, "/payments/"+url.PathEscape(r.PaymentID)+"/refunds", r, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Void sends POST {baseURL}/payments/{id}/void and decodes the VoidResponse.
+func (c *HTTPClient) Void(ctx context.Context, r VoidRequest) (*VoidResponse, error) {
+	response := VoidResponse{}
+	if err := c.post(ctx, "/payments/"+url.PathEscape(r.PaymentID)+"/void", r, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// SettlementCurrencies returns the currencies the HTTPClient was configured with.
+func (c *HTTPClient) SettlementCurrencies() []string {
+	return c.settlementCurrencies
+}
+
+// Ping checks that the bank API is reachable, by requesting GET /health and expecting a http 200 response.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	request, err := c.newRequest(ctx, http.MethodGet, "/health", nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call the bank: %w", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("bank responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// post sends body as JSON to path and decodes a successful JSON response into out. Errors from before the
+// request was sent wrap ErrNotSent.
+func (c *HTTPClient) post(ctx context.Context, path string, body, out any) error {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bank request, %w: %w", ErrNotSent, err)
+	}
+
+	request, err := c.newRequest(ctx, http.MethodPost, path, bytes.NewReader(requestBody))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// Nothing was sent if the connection could not be made, e.g. it was refused or the host was not found
+		return fmt.Errorf("failed to call the bank, %w: %w", ErrNotSent, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to call the bank: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyLength))
+		return fmt.Errorf("bank responded with status %d: %s", response.StatusCode, bytes.TrimSpace(responseBody))
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response from bank server: %w", err)
+	}
+	return nil
+}
+
+// newRequest creates a request to path with the request ID of ctx, if it has one, in RequestIDHeader, and
+// the trace context of ctx, if it has one, in the traceparent header.
+func (c *HTTPClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bank request: %w", err)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		request.Header.Set(RequestIDHeader, id)
+	}
+	tracing.Inject(ctx, request.Header)
+	return request, nil
+}
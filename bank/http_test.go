@@ -0,0 +1,213 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClientMakePayment(t *testing.T) {
+	t.Parallel()
+
+	request := MakePaymentRequest{
+		CardNumber:  "1234123412341234",
+		ExpiryYear:  2099,
+		ExpiryMonth: 12,
+		CVV:         "987",
+		Money:       money.New(1005, "GBP"),
+	}
+
+	type testCase struct {
+		name                 string
+		handler              http.HandlerFunc
+		expectedResponse     *MakePaymentResponse
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{
+			"successful response is decoded",
+			func(w http.ResponseWriter, r *http.Request) {
+				a := assert.New(t)
+				a.Equal(http.MethodPost, r.Method)
+				a.Equal("/payments", r.URL.Path)
+				a.Equal("application/json", r.Header.Get("Content-Type"))
+
+				received := MakePaymentRequest{}
+				a.NoError(json.NewDecoder(r.Body).Decode(&received))
+				a.Equal(request, received, "request should be sent to the bank unchanged")
+
+				w.Write([]byte(`{"payment_id":"some-id","status":"SUCCESS"}`))
+			},
+			&MakePaymentResponse{PaymentID: "some-id", Status: "SUCCESS"},
+			"",
+		}, {
+			"non-200 status returns error",
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "bank is down", http.StatusServiceUnavailable)
+			},
+			nil,
+			"bank responded with status 503: bank is down",
+		}, {
+			"malformed response returns error",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"payment_id":`))
+			},
+			nil,
+			"failed to decode response from bank server",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			bankServer := httptest.NewServer(tc.handler)
+			defer bankServer.Close()
+
+			client := NewHTTPClient(bankServer.URL+"/", nil, nil)
+			response, err := client.MakePayment(context.Background(), request)
+
+			if tc.expectedErrorMessage == "" {
+				r.NoError(err)
+				r.Equal(tc.expectedResponse, response)
+			} else {
+				r.ErrorContains(err, tc.expectedErrorMessage)
+				r.Nil(response)
+			}
+		})
+	}
+}
+
+func TestHTTPClientUnreachableBank(t *testing.T) {
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(http.NotFoundHandler())
+	bankServer.Close()
+
+	_, err := NewHTTPClient(bankServer.URL, nil, nil).MakePayment(context.Background(), MakePaymentRequest{})
+	r.ErrorContains(err, "failed to call the bank")
+	r.ErrorContains(NewHTTPClient(bankServer.URL, nil, nil).Ping(context.Background()), "failed to call the bank")
+}
+
+func TestHTTPClientPing(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name                 string
+		status               int
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{"healthy bank", http.StatusOK, ""},
+		{"unhealthy bank", http.StatusServiceUnavailable, "bank responded with status 503"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			bankServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				a := assert.New(t)
+				a.Equal(http.MethodGet, r.Method)
+				a.Equal("/health", r.URL.Path)
+				w.WriteHeader(tc.status)
+			}))
+			defer bankServer.Close()
+
+			err := NewHTTPClient(bankServer.URL, nil, nil).Ping(context.Background())
+			if tc.expectedErrorMessage == "" {
+				r.NoError(err)
+			} else {
+				r.EqualError(err, tc.expectedErrorMessage)
+			}
+		})
+	}
+}
+
+func TestHTTPClientSendsRequestID(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name              string
+		ctx               context.Context
+		expectedRequestID string
+	}
+
+	testCases := []testCase{
+		{"request ID in context", ContextWithRequestID(context.Background(), "req-123"), "req-123"},
+		{"no request ID", context.Background(), ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			var received []string
+			bankServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = append(received, r.Header.Get(RequestIDHeader))
+				w.Write([]byte(`{"payment_id":"some-id","status":"SUCCESS"}`))
+			}))
+			defer bankServer.Close()
+
+			client := NewHTTPClient(bankServer.URL, nil, nil)
+			_, err := client.MakePayment(tc.ctx, MakePaymentRequest{})
+			r.NoError(err)
+			r.NoError(client.Ping(tc.ctx))
+			r.Equal([]string{tc.expectedRequestID, tc.expectedRequestID}, received)
+		})
+	}
+}
+
+func TestHTTPClientSendsTraceparent(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	var received []string
+	bankServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(tracing.TraceparentHeader))
+		w.Write([]byte(`{"payment_id":"some-id","status":"SUCCESS"}`))
+	}))
+	defer bankServer.Close()
+
+	client := NewHTTPClient(bankServer.URL, nil, nil)
+	_, err := client.MakePayment(context.Background(), MakePaymentRequest{})
+	r.NoError(err)
+
+	ctx, span := tracing.NewTracer(nil).Start(context.Background(), "test")
+	defer span.End()
+	_, err = client.MakePayment(ctx, MakePaymentRequest{})
+	r.NoError(err)
+
+	r.Equal([]string{"", tracing.FormatTraceparent(span.SpanContext())}, received)
+}
+
+func TestHTTPClientErrNotSent(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	// A closed server refuses connections, so nothing reaches it
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, err := NewHTTPClient(closed.URL, nil, nil).MakePayment(context.Background(), MakePaymentRequest{})
+	r.ErrorIs(err, ErrNotSent)
+
+	// A bank that drops the connection after receiving the request may have acted on it
+	dropping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			connection.Close()
+		}
+	}))
+	defer dropping.Close()
+	_, err = NewHTTPClient(dropping.URL, nil, nil).MakePayment(context.Background(), MakePaymentRequest{})
+	r.Error(err)
+	r.NotErrorIs(err, ErrNotSent)
+}
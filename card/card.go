@@ -0,0 +1,176 @@
+// Package card validates payment card numbers and CVVs, and detects the brand of a card from the issuer
+// identification number (IIN) at the start of its number.
+package card
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+)
+
+var (
+	// ErrInvalidNumber is returned for a card number that is not 12 to 19 digits.
+	ErrInvalidNumber = errors.New("card number must have 12 to 19 digits")
+	// ErrUnknownBrand is returned for a card number that does not start with the IIN of a supported brand.
+	ErrUnknownBrand = errors.New("card brand is not supported")
+	// ErrInvalidLength is returned for a card number with a length that its brand does not issue.
+	ErrInvalidLength = errors.New("card number has the wrong number of digits for its brand")
+	// ErrChecksum is returned for a card number that fails the Luhn check.
+	ErrChecksum = errors.New("card number fails the Luhn check")
+	// ErrInvalidCVV is returned for a CVV with the wrong number of digits for the card brand.
+	ErrInvalidCVV = errors.New("cvv has the wrong number of digits for the card brand")
+)
+
+const (
+	minNumberLength = 12
+	maxNumberLength = 19
+)
+
+// Brand is a card scheme, returned to clients as card_brand.
+type Brand string
+
+const (
+	Visa       Brand = "visa"
+	Mastercard Brand = "mastercard"
+	Amex       Brand = "amex"
+	Discover   Brand = "discover"
+	JCB        Brand = "jcb"
+	UnionPay   Brand = "unionpay"
+	Maestro    Brand = "maestro"
+)
+
+// rules are the card number lengths a brand issues, and the number of digits of its CVV.
+type rules struct {
+	lengths   []int
+	cvvLength int
+}
+
+var brandRules = map[Brand]rules{
+	Visa:       {[]int{13, 16, 19}, 3},
+	Mastercard: {[]int{16}, 3},
+	Amex:       {[]int{15}, 4},
+	Discover:   {[]int{16, 17, 18, 19}, 3},
+	JCB:        {[]int{16, 17, 18, 19}, 3},
+	UnionPay:   {[]int{16, 17, 18, 19}, 3},
+	Maestro:    {[]int{12, 13, 14, 15, 16, 17, 18, 19}, 3},
+}
+
+// iinRange is a range of card number prefixes, from low to high inclusive, with as many digits as low.
+type iinRange struct {
+	low, high int
+	brand     Brand
+}
+
+// iinRanges are the IIN ranges of each brand. Where ranges overlap, the longer prefix is more specific and
+// wins, e.g. 622126 to 622925 is Discover within UnionPay's 62.
+var iinRanges = []iinRange{
+	{4, 4, Visa},
+	{51, 55, Mastercard},
+	{2221, 2720, Mastercard},
+	{34, 34, Amex},
+	{37, 37, Amex},
+	{6011, 6011, Discover},
+	{644, 649, Discover},
+	{65, 65, Discover},
+	{622126, 622925, Discover},
+	{3528, 3589, JCB},
+	{62, 62, UnionPay},
+	{8100, 8171, UnionPay},
+	{5018, 5018, Maestro},
+	{5020, 5020, Maestro},
+	{5038, 5038, Maestro},
+	{5893, 5893, Maestro},
+	{6304, 6304, Maestro},
+	{6759, 6759, Maestro},
+	{6761, 6763, Maestro},
+}
+
+// Lengths returns the card number lengths the brand issues, shortest first.
+func (b Brand) Lengths() []int {
+	return brandRules[b].lengths
+}
+
+// CVVLength returns the number of digits of the brand's CVV, which is 4 for the Amex CID and 3 otherwise.
+func (b Brand) CVVLength() int {
+	return brandRules[b].cvvLength
+}
+
+// DetectBrand returns the brand of a card number from its IIN, or ErrInvalidNumber or ErrUnknownBrand. It
+// does not check the length or checksum of the number.
+func DetectBrand(number string) (Brand, error) {
+	if len(number) < minNumberLength || len(number) > maxNumberLength || !isDigits(number) {
+		return "", ErrInvalidNumber
+	}
+
+	var brand Brand
+	var prefixLength int
+	for _, r := range iinRanges {
+		length := len(strconv.Itoa(r.low))
+		prefix, _ := strconv.Atoi(number[:length])
+		if prefix >= r.low && prefix <= r.high && length > prefixLength {
+			brand, prefixLength = r.brand, length
+		}
+	}
+	if brand == "" {
+		return "", ErrUnknownBrand
+	}
+	return brand, nil
+}
+
+// ValidateNumber checks that a card number belongs to a supported brand, has a length the brand issues,
+// and passes the Luhn check. It returns the brand of the card.
+func ValidateNumber(number string) (Brand, error) {
+	brand, err := DetectBrand(number)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(brand.Lengths(), len(number)) {
+		return brand, ErrInvalidLength
+	}
+	if !Luhn(number) {
+		return brand, ErrChecksum
+	}
+	return brand, nil
+}
+
+// ValidateCVV checks that cvv has as many digits as the CVV of brand. If brand is "", because the card's
+// brand is not known, cvv may have 3 or 4 digits.
+func ValidateCVV(brand Brand, cvv string) error {
+	validLength := len(cvv) == brand.CVVLength()
+	if brand == "" {
+		validLength = len(cvv) == 3 || len(cvv) == 4
+	}
+	if !validLength || !isDigits(cvv) {
+		return ErrInvalidCVV
+	}
+	return nil
+}
+
+// Luhn reports whether number, a string of digits, passes the Luhn checksum.
+func Luhn(number string) bool {
+	sum := 0
+	for i := 0; i < len(number); i++ {
+		digit := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package card
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNumber(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name          string
+		number        string
+		expectedBrand Brand
+		expectedError error
+	}
+
+	testCases := []testCase{
+		{"visa 16 digits", "4242424242424242", Visa, nil},
+		{"visa 13 digits", "4222222222222", Visa, nil},
+		{"visa 19 digits", "4000000000000000006", Visa, nil},
+		{"mastercard 5 series", "5555555555554444", Mastercard, nil},
+		{"mastercard 2 series", "2223003122003222", Mastercard, nil},
+		{"amex 15 digits", "378282246310005", Amex, nil},
+		{"discover", "6011111111111117", Discover, nil},
+		{"discover within unionpay range", "6221260000000000", Discover, nil},
+		{"jcb", "3530111333300000", JCB, nil},
+		{"unionpay 16 digits", "6200000000000005", UnionPay, nil},
+		{"unionpay 19 digits", "6200000000000000000", UnionPay, nil},
+		{"maestro", "6759649826438453", Maestro, nil},
+		{"maestro 5018", "5018000000000009", Maestro, nil},
+		{"fails luhn check", "4242424242424241", Visa, ErrChecksum},
+		{"amex with 16 digits", "3782822463100005", Amex, ErrInvalidLength},
+		{"mastercard with 19 digits", "5555555555555555557", Mastercard, ErrInvalidLength},
+		{"unknown brand", "9999999999999995", "", ErrUnknownBrand},
+		{"too short", "42424242424", "", ErrInvalidNumber},
+		{"too long", "42424242424242424242", "", ErrInvalidNumber},
+		{"not digits", "4242 4242 4242 4242", "", ErrInvalidNumber},
+		{"empty", "", "", ErrInvalidNumber},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			brand, err := ValidateNumber(tc.number)
+			r.ErrorIs(err, tc.expectedError)
+			r.Equal(tc.expectedBrand, brand)
+		})
+	}
+}
+
+func TestValidateCVV(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name          string
+		brand         Brand
+		cvv           string
+		expectedError error
+	}
+
+	testCases := []testCase{
+		{"visa 3 digits", Visa, "123", nil},
+		{"visa 4 digits", Visa, "1234", ErrInvalidCVV},
+		{"amex 4 digit CID", Amex, "1234", nil},
+		{"amex 3 digits", Amex, "123", ErrInvalidCVV},
+		{"not digits", Mastercard, "12a", ErrInvalidCVV},
+		{"empty", JCB, "", ErrInvalidCVV},
+		{"unknown brand 3 digits", "", "123", nil},
+		{"unknown brand 4 digits", "", "1234", nil},
+		{"unknown brand 5 digits", "", "12345", ErrInvalidCVV},
+		{"unknown brand not digits", "", "abc", ErrInvalidCVV},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.ErrorIs(t, ValidateCVV(tc.brand, tc.cvv), tc.expectedError)
+		})
+	}
+}
+
+func TestLuhn(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	r.True(Luhn("79927398713"))
+	r.True(Luhn("4242424242424242"))
+	r.False(Luhn("79927398710"))
+	r.False(Luhn("4242424242424243"))
+}
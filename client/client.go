@@ -0,0 +1,121 @@
+// Package client is a Go client for the payment gateway API, for merchants calling it from their servers.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+)
+
+// DefaultTimeout is the timeout of the http.Client used by New when none is provided.
+const DefaultTimeout = 30 * time.Second
+
+// Error is returned for an error response from the gateway.
+type Error struct {
+	StatusCode int
+	models.ErrorResponse
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gateway responded with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client calls the payment gateway as a merchant. Every request is authenticated with the merchant's API
+// key, and signed with its signing secret if it has one.
+type Client struct {
+	baseURL       string
+	apiKey        string
+	signingSecret string
+	httpClient    *http.Client
+	now           func() time.Time
+}
+
+// New instantiates a Client that sends requests to the gateway at baseURL. If signingSecret is empty,
+// requests are not signed, and if httpClient is nil, a client with DefaultTimeout is used.
+func New(baseURL, apiKey, signingSecret string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Client{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		apiKey:        apiKey,
+		signingSecret: signingSecret,
+		httpClient:    httpClient,
+		now:           time.Now,
+	}
+}
+
+// ProcessPayment makes a payment. If idempotencyKey is not empty, retrying with the same key and request
+// returns the original payment instead of making a new one.
+func (c *Client) ProcessPayment(ctx context.Context, request models.ProcessPaymentRequest, idempotencyKey string) (*models.MaskedPayment, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	header := http.Header{}
+	if idempotencyKey != "" {
+		header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	payment := models.MaskedPayment{}
+	if err := c.do(ctx, http.MethodPost, utils.Path, header, body, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetPayment fetches the payment with the given ID.
+func (c *Client) GetPayment(ctx context.Context, id string) (*models.MaskedPayment, error) {
+	payment := models.MaskedPayment{}
+	if err := c.do(ctx, http.MethodGet, utils.Path+"/"+url.PathEscape(id), nil, nil, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// do sends a signed request with the given method, path, headers and body, and decodes a successful JSON
+// response into out. An error response is returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte, out any) error {
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if c.signingSecret != "" {
+		request.Header.Set(signing.Header, signing.Sign(c.signingSecret, c.now(), method, request.URL.EscapedPath(), body))
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call the gateway: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		gatewayError := &Error{StatusCode: response.StatusCode}
+		if err := json.NewDecoder(response.Body).Decode(&gatewayError.ErrorResponse); err != nil {
+			gatewayError.Message = http.StatusText(response.StatusCode)
+		}
+		return gatewayError
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response from the gateway: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/mockbank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/server"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	store := server.NewMemoryStore()
+	store.AddMerchant(&models.Merchant{ID: "merchant", Name: "Merchant", Status: models.MerchantActive, DefaultCurrency: "GBP"})
+	apiKeys := server.NewAPIKeyStore(server.SystemClock)
+	apiKeys.AddHashedKey("merchant", server.HashAPIKey("sk_merchant"))
+	signingSecrets := server.NewSigningSecrets()
+	signingSecrets.Set("merchant", "secret")
+
+	gateway := server.New(server.Config{
+		Store:          store,
+		Merchants:      store,
+		Bank:           mockbank.NewBankClient(),
+		APIKeys:        apiKeys,
+		SigningSecrets: signingSecrets,
+		Logger:         slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	})
+	gatewayServer := httptest.NewServer(gateway.Routes())
+	defer gatewayServer.Close()
+
+	t.Run("signed requests are accepted", func(t *testing.T) {
+		r := require.New(t)
+		c := New(gatewayServer.URL, "sk_merchant", "secret", nil)
+
+		payment, err := c.ProcessPayment(context.Background(), *utils.ValidProcessPaymentRequest(), "some-key")
+		r.NoError(err)
+		r.NotEmpty(payment.ID)
+		r.Equal("merchant", payment.MerchantID)
+
+		fetched, err := c.GetPayment(context.Background(), payment.ID)
+		r.NoError(err)
+		r.Equal(payment, fetched)
+	})
+
+	type testCase struct {
+		name            string
+		signingSecret   string
+		clockSkew       time.Duration
+		expectedMessage string
+	}
+
+	testCases := []testCase{
+		{"unsigned request is rejected", "", 0, "missing X-Signature header"},
+		{"wrong secret is rejected", "wrong", 0, "signature does not match the request"},
+		{"old signature is rejected", "secret", -time.Hour, "signature timestamp is too old or in the future"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			c := New(gatewayServer.URL, "sk_merchant", tc.signingSecret, nil)
+			c.now = func() time.Time { return time.Now().Add(tc.clockSkew) }
+
+			_, err := c.GetPayment(context.Background(), "some-id")
+			gatewayError := &Error{}
+			r.True(errors.As(err, &gatewayError), "error should be an *Error")
+			r.Equal(http.StatusUnauthorized, gatewayError.StatusCode)
+			r.Equal(server.CodeInvalidSignature, gatewayError.Code)
+			r.Equal(tc.expectedMessage, gatewayError.Message)
+		})
+	}
+}
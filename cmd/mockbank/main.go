@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/celestebrant/processout-payment-gateway/mockbank"
+)
+
+func main() {
+	port := flag.String("port", "8001", "port to listen on")
+	latency := flag.Duration("latency", 0, "latency added to every response")
+	latencyJitter := flag.Duration("latency-jitter", 0, "up to this much extra random latency is added to every response")
+	errorRate := flag.Float64("error-rate", 0, "probability, from 0 to 1, that an unscripted payment fails with a bank error")
+	timeout := flag.Duration("timeout", mockbank.DefaultServerTimeout, "how long to hang before responding to a timeout scenario")
+	flag.Parse()
+
+	handler := mockbank.NewServer(mockbank.ServerOptions{
+		Latency:       *latency,
+		LatencyJitter: *latencyJitter,
+		ErrorRate:     *errorRate,
+		Timeout:       *timeout,
+	})
+
+	log.Printf("mock bank listening on port %s...", *port)
+	log.Fatal(http.ListenAndServe(":"+*port, handler))
+}
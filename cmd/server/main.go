@@ -1,22 +1,271 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/logging"
+	"github.com/celestebrant/processout-payment-gateway/mockbank"
+	"github.com/celestebrant/processout-payment-gateway/money"
 	"github.com/celestebrant/processout-payment-gateway/server"
-	"github.com/celestebrant/processout-payment-gateway/utils"
-	"github.com/gorilla/mux"
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
 )
 
 const (
 	port = "8000"
+	// readHeaderTimeout is how long clients have to send request headers, which stops slow clients from
+	// holding connections open
+	readHeaderTimeout = 5 * time.Second
 )
 
 func main() {
-	router := mux.NewRouter()
-	router.HandleFunc(utils.Path, server.ProcessPaymentHandler).Methods("POST")
-	router.HandleFunc(utils.Path+"/{id}", server.GetPaymentHandler).Methods("GET")
-	log.Printf("server listening on port %s...", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	bankURL := flag.String("bank-url", "", "base URL of the bank API; if empty, the mock bank is used")
+	storeBackend := flag.String("store", "memory", `payment store backend, either "memory" or "file"`)
+	storePath := flag.String("store-path", "payments.log", `path of the payment log used by the "file" store`)
+	idempotencyKeyRetention := flag.Duration(
+		"idempotency-key-retention", server.DefaultIdempotencyKeyRetention,
+		"how long Idempotency-Key values are remembered for replaying payments",
+	)
+	decimalAmounts := flag.Bool(
+		"decimal-amounts", false,
+		"accept request amounts as decimals in major units, like 10.05, instead of whole minor units",
+	)
+	bankCurrencies := flag.String(
+		"bank-currencies", strings.Join(bank.DefaultSettlementCurrencies, ","),
+		"comma-separated ISO 4217 codes of the currencies the bank at -bank-url settles in",
+	)
+	apiKeysPath := flag.String(
+		"api-keys", "", "path of a file of merchant API keys, with a merchant ID and the SHA-256 hash of a key on each line",
+	)
+	adminKeysPath := flag.String(
+		"admin-keys", "",
+		"path of a file of admin API keys, in the same format as -api-keys; if empty, there are no admin endpoints",
+	)
+	signingSecretsPath := flag.String(
+		"signing-secrets", "",
+		"path of a file of merchant signing secrets, with a merchant ID and its secret on each line; if empty, requests are not signed",
+	)
+	webhookRetryBackoff := flag.Duration(
+		"webhook-retry-backoff", server.DefaultWebhookRetryBackoff,
+		"how long after a failed first attempt a webhook delivery is retried; doubles with every attempt",
+	)
+	webhookMaxAttempts := flag.Int(
+		"webhook-max-attempts", server.DefaultWebhookMaxAttempts, "how many times a webhook delivery is attempted before it fails",
+	)
+	webhookEndpointConcurrency := flag.Int(
+		"webhook-endpoint-concurrency", server.DefaultWebhookEndpointConcurrency,
+		"how many webhook deliveries to one endpoint are sent at once",
+	)
+	webhookAllowPrivate := flag.Bool(
+		"webhook-allow-private-addresses", false,
+		"let webhooks be sent to loopback, link-local and private addresses; only meant for local development",
+	)
+	signatureTolerance := flag.Duration(
+		"signature-tolerance", signing.DefaultTolerance, "how far the time of a request signature may be from the server's time",
+	)
+	readTimeout := flag.Duration("read-timeout", 15*time.Second, "how long clients have to send a whole request")
+	writeTimeout := flag.Duration(
+		"write-timeout", 35*time.Second,
+		"how long the server has to respond once a request is read; should be longer than the 30s that requests wait for the bank",
+	)
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long idle keep-alive connections are kept open")
+	maxHeaderBytes := flag.Int("max-header-bytes", 64<<10, "largest size of request headers, in bytes")
+	shutdownDelay := flag.Duration(
+		"shutdown-delay", 5*time.Second,
+		"how long the server keeps serving after it stops being ready on SIGINT or SIGTERM, for load balancers to notice",
+	)
+	shutdownTimeout := flag.Duration(
+		"shutdown-timeout", 30*time.Second, "how long in-flight requests have to finish once the server stops listening",
+	)
+	traceStdout := flag.Bool("trace-stdout", false, "write the spans of traced payments to stdout as JSON lines")
+	flag.Parse()
+	if *webhookRetryBackoff <= 0 {
+		log.Fatalf("-webhook-retry-backoff must be positive")
+	}
+	if *webhookMaxAttempts <= 0 {
+		log.Fatalf("-webhook-max-attempts must be positive")
+	}
+	if *webhookEndpointConcurrency <= 0 {
+		log.Fatalf("-webhook-endpoint-concurrency must be positive")
+	}
+
+	// Logs are JSON with card data masked, including those written with the log package
+	logger := slog.New(logging.NewJSONHandler(os.Stderr, nil))
+	slog.SetDefault(logger)
+
+	apiKeys := server.NewAPIKeyStore(server.SystemClock)
+	if *apiKeysPath == "" {
+		log.Printf("no -api-keys file given, so only keys issued through the admin endpoints will authenticate")
+	} else {
+		loadFile(*apiKeysPath, "API keys", apiKeys.Load)
+	}
+
+	var adminKeys *server.APIKeyStore
+	if *adminKeysPath != "" {
+		adminKeys = server.NewAPIKeyStore(server.SystemClock)
+		loadFile(*adminKeysPath, "admin keys", adminKeys.Load)
+	}
+
+	var signingSecrets *server.SigningSecrets
+	if *signingSecretsPath != "" {
+		signingSecrets = server.NewSigningSecrets()
+		loadFile(*signingSecretsPath, "signing secrets", signingSecrets.Load)
+	}
+
+	// Both stores hold merchants and webhooks as well as payments
+	var store interface {
+		server.PaymentStore
+		server.MerchantStore
+		server.WebhookStore
+	}
+	switch *storeBackend {
+	case "memory":
+		store = server.NewMemoryStore()
+	case "file":
+		fileStore, err := server.OpenFileStore(*storePath)
+		if err != nil {
+			log.Fatalf("failed to open file store: %v", err)
+		}
+		store = fileStore
+	default:
+		log.Fatalf("unknown store backend %q", *storeBackend)
+	}
+	if err := apiKeys.LoadStored(store); err != nil {
+		log.Fatalf("failed to load issued API keys: %v", err)
+	}
+
+	var acquirer bank.Acquirer = mockbank.NewBankClient()
+	if *bankURL != "" {
+		acquirer = bank.NewHTTPClient(*bankURL, parseCurrencies(*bankCurrencies), nil)
+	}
+
+	// Without an exporter spans are discarded, but the trace context is still passed on to the bank
+	var exporter tracing.Exporter
+	if *traceStdout {
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	}
+
+	gateway := server.New(server.Config{
+		Store:                        store,
+		Merchants:                    store,
+		Webhooks:                     store,
+		WebhookRetryBackoff:          *webhookRetryBackoff,
+		WebhookMaxAttempts:           *webhookMaxAttempts,
+		WebhookEndpointConcurrency:   *webhookEndpointConcurrency,
+		WebhookAllowPrivateAddresses: *webhookAllowPrivate,
+		Bank:                         acquirer,
+		APIKeys:                      apiKeys,
+		AdminKeys:                    adminKeys,
+		SigningSecrets:               signingSecrets,
+		SignatureTolerance:           *signatureTolerance,
+		Clock:                        server.SystemClock,
+		Logger:                       logger,
+		Tracer:                       tracing.NewTracer(exporter),
+		IdempotencyKeyRetention:      *idempotencyKeyRetention,
+		DecimalAmounts:               *decimalAmounts,
+	})
+
+	httpServer := &http.Server{
+		Addr:              ":" + port,
+		Handler:           gateway.Routes(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+	}
+
+	webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := make(chan struct{})
+	go func() {
+		gateway.RunWebhooks(webhooksCtx)
+		close(webhooksDone)
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	log.Printf("server listening on port %s using %s store...", port, *storeBackend)
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("server failed: %v", err)
+	case <-signals.Done():
+	}
+	// A second signal stops the server straight away
+	stopSignals()
+
+	shutdown(gateway, httpServer, *shutdownDelay, *shutdownTimeout)
+	stopWebhooks()
+	<-webhooksDone
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("failed to close store: %v", err)
+		}
+	}
+	log.Printf("server stopped")
+}
+
+/*
+shutdown stops httpServer gracefully:
+  - gateway stops being ready, but keeps serving for delay so that load balancers stop sending it requests
+  - httpServer stops listening, and in-flight requests, including their bank calls, have until timeout to
+    finish
+  - connections that are still open after timeout are closed
+*/
+func shutdown(gateway *server.Server, httpServer *http.Server, delay, timeout time.Duration) {
+	log.Printf("shutting down: no longer ready, and closing the listener in %s", delay)
+	gateway.SetReady(false)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	log.Printf("draining in-flight requests for up to %s", timeout)
+	if err := httpServer.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("failed to drain requests: %v", err)
+		httpServer.Close()
+	}
+}
+
+// loadFile loads the file at path with load, exiting if it cannot. name describes the file in errors.
+func loadFile(path, name string, load func(io.Reader) error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("failed to open %s: %v", name, err)
+	}
+	defer file.Close()
+	if err := load(file); err != nil {
+		log.Fatalf("failed to load %s: %v", name, err)
+	}
+}
+
+// parseCurrencies parses a comma-separated list of ISO 4217 currency codes, exiting if any is unknown.
+func parseCurrencies(list string) []string {
+	var codes []string
+	for _, code := range strings.Split(list, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if _, exists := money.LookupCurrency(code); !exists {
+			log.Fatalf("unknown currency code %q", code)
+		}
+		codes = append(codes, code)
+	}
+	return codes
 }
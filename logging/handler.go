@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// redactingHandler is a slog.Handler that redacts records before passing them to another handler.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler returns a slog.Handler that masks PANs and CVVs in the message and attributes of
+// every record, including attributes added with WithAttrs, before passing it to next.
+func NewRedactingHandler(next slog.Handler) slog.Handler {
+	return redactingHandler{next: next}
+}
+
+// NewJSONHandler returns a redacting handler that writes records to w as JSON objects, one per line.
+func NewJSONHandler(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	return NewRedactingHandler(slog.NewJSONHandler(w, opts))
+}
+
+func (h redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(RedactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = RedactAttr(attr)
+	}
+	return redactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{next: h.next.WithGroup(name)}
+}
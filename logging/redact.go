@@ -0,0 +1,207 @@
+/*
+Package logging keeps card data out of logs. NewRedactingHandler wraps a log/slog handler so that anything
+that looks like a card number (PAN) or a card security code (CVV) in a record is masked before it is
+written, whichever attribute or message it is in:
+  - A PAN is 12 to 19 digits, in a row or separated by single spaces or dashes, and every digit but the
+    final 4 is masked, like ************4242. Digits that may be part of a longer ID, because they run on
+    into letters, digits or underscores, and the values of ID attributes like request_id are only masked
+    if they pass the Luhn check
+  - A CVV is only recognisable by its key, so the values of attributes named like cvv, cvc or
+    security_code are replaced, and so are digits after such a key in text, like "cvv":"123" or CVV:123
+  - Values of attributes named like card_number or pan are replaced entirely
+
+Other values, like structs and errors, are redacted in the form they would be logged in.
+*/
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Redacted replaces the values of sensitive attributes.
+const Redacted = "[REDACTED]"
+
+var (
+	// panPattern matches candidate PANs, which are checked further by maskPAN.
+	panPattern = regexp.MustCompile(`\d(?:[ -]?\d){11,18}`)
+	// cvvPattern matches a CVV after a key in text, like JSON, key=value pairs or Go's %+v.
+	cvvPattern = regexp.MustCompile(`(?i)(\b(?:cvv2?|cvc2?|security_code|card_verification_value)"?\s*[:=]\s*"?)\d{3,4}`)
+)
+
+// sensitiveKeys are the normalised keys of attributes whose values are always replaced.
+var sensitiveKeys = map[string]bool{
+	"cvv": true, "cvv2": true, "cvc": true, "cvc2": true, "securitycode": true, "cardverificationvalue": true,
+	"cardnumber": true, "pan": true,
+}
+
+// idKeys are the normalised keys of attributes whose values are IDs, in which digits are only masked if they
+// pass the Luhn check.
+var idKeys = map[string]bool{"requestid": true, "paymentid": true, "traceid": true}
+
+// Redact masks anything that looks like a PAN or a CVV in text.
+func Redact(text string) string {
+	return redact(text, false)
+}
+
+// redact is Redact, where ambiguous is whether every candidate PAN is ambiguous, and so only masked if it
+// passes the Luhn check.
+func redact(text string, ambiguous bool) string {
+	var redacted strings.Builder
+	end := 0
+	for _, match := range panPattern.FindAllStringIndex(text, -1) {
+		redacted.WriteString(text[end:match[0]])
+		candidate := text[match[0]:match[1]]
+		if (ambiguous || embedded(text, match[0], match[1])) && !luhn(digitsOf(candidate)) {
+			redacted.WriteString(candidate)
+		} else {
+			redacted.WriteString(maskPAN(candidate))
+		}
+		end = match[1]
+	}
+	redacted.WriteString(text[end:])
+	return cvvPattern.ReplaceAllString(redacted.String(), "${1}***")
+}
+
+// embedded reports whether the candidate PAN text[start:end] may be part of a longer ID, like a UUID or a
+// prefixed ID, because it runs on into letters, digits or underscores, either directly or across a dash.
+func embedded(text string, start, end int) bool {
+	if start > 0 && (idChar(text[start-1]) || text[start-1] == '-' && start > 1 && idChar(text[start-2])) {
+		return true
+	}
+	return end < len(text) && (idChar(text[end]) || text[end] == '-' && end+1 < len(text) && idChar(text[end+1]))
+}
+
+// idChar reports whether c is a letter, digit or underscore.
+func idChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// digitsOf returns the digits of candidate, without its separators.
+func digitsOf(candidate string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, candidate)
+}
+
+// maskPAN masks every digit of candidate but the final 4, keeping separators.
+func maskPAN(candidate string) string {
+	masked := []byte(candidate)
+	remaining := len(digitsOf(candidate))
+	for i, c := range masked {
+		if c >= '0' && c <= '9' {
+			if remaining > 4 {
+				masked[i] = '*'
+			}
+			remaining--
+		}
+	}
+	return string(masked)
+}
+
+// luhn reports whether digits pass the Luhn check.
+func luhn(digits string) bool {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// normaliseKey returns key in lower case without underscores and dashes, as it is in sensitiveKeys and
+// idKeys.
+func normaliseKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+}
+
+// sensitiveKey reports whether key names an attribute whose value is always replaced, ignoring case,
+// underscores and dashes.
+func sensitiveKey(key string) bool {
+	return sensitiveKeys[normaliseKey(key)]
+}
+
+// RedactAttr returns attr with any PAN or CVV masked, including in the attributes of groups.
+func RedactAttr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if sensitiveKey(attr.Key) && attr.Value.Kind() != slog.KindGroup {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	id := idKeys[normaliseKey(attr.Key)]
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(redact(attr.Value.String(), id))
+	case slog.KindInt64, slog.KindUint64:
+		if text := attr.Value.String(); redact(text, id) != text {
+			attr.Value = slog.StringValue(redact(text, id))
+		}
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = RedactAttr(member)
+		}
+		attr.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		attr.Value = redactAny(attr.Value.Any())
+	}
+	return attr
+}
+
+// redactAny redacts a value of any other type. Errors are redacted as their message, values that can be
+// marshalled as JSON as that JSON, with the values of sensitive keys replaced, and others as they are
+// formatted by fmt.
+func redactAny(value any) slog.Value {
+	if err, ok := value.(error); ok {
+		return slog.StringValue(Redact(err.Error()))
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return slog.StringValue(Redact(fmt.Sprintf("%+v", value)))
+	}
+	var decoded any
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return slog.StringValue(Redact(string(encoded)))
+	}
+	redacted, _ := json.Marshal(redactJSON(decoded))
+	return slog.AnyValue(json.RawMessage(redacted))
+}
+
+// redactJSON redacts a value decoded from JSON, replacing the values of sensitive keys in objects.
+func redactJSON(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, member := range value {
+			if sensitiveKey(key) {
+				value[key] = Redacted
+			} else {
+				value[key] = redactJSON(member)
+			}
+		}
+	case []any:
+		for i, element := range value {
+			value[i] = redactJSON(element)
+		}
+	case string:
+		return Redact(value)
+	case float64:
+		if text := strconv.FormatFloat(value, 'f', -1, 64); Redact(text) != text {
+			return Redact(text)
+		}
+	}
+	return value
+}
@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name     string
+		text     string
+		expected string
+	}
+
+	testCases := []testCase{
+		{"PAN", "card 4242424242424242 declined", "card ************4242 declined"},
+		{"shortest PAN", "123456789012", "********9012"},
+		{"longest PAN", "6011000990139424123", "***************4123"},
+		{"PAN with spaces", "4242 4242 4242 4242", "**** **** **** 4242"},
+		{"PAN with dashes", "3782-822463-10005", "****-******-*0005"},
+		{"PAN in JSON", `{"card_number":"5555555555554444"}`, `{"card_number":"************4444"}`},
+		{"grouped digits failing the Luhn check", "11111111-1111-4111-8111-abcdefabcdef", "11111111-1111-4111-8111-abcdefabcdef"},
+		{"PAN failing the Luhn check", "card 4111111111111112 declined", "card ************1112 declined"},
+		{"PAN after a key", "request_id=1790000000000000123", "request_id=***************0123"},
+		{"digits in a longer ID failing the Luhn check", "ord_123456789012 txn123456789012x", "ord_123456789012 txn123456789012x"},
+		{"PAN in a longer ID", "ref_4242424242424242", "ref_************4242"},
+		{"too many digits", "12345678901234567890123", "12345678901234567890123"},
+		{"too few digits", "order 12345678901", "order 12345678901"},
+		{"CVV in JSON", `{"cvv":"987","amount":"1005"}`, `{"cvv":"***","amount":"1005"}`},
+		{"CVV in Go syntax", "{CVV:1234 Amount:1005}", "{CVV:*** Amount:1005}"},
+		{"CVC as key and value", "cvc=123", "cvc=***"},
+		{"numbers without keys", "status 200 of 1005 GBP", "status 200 of 1005 GBP"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, Redact(tc.text))
+		})
+	}
+}
+
+// paymentRequest is like the request to process a payment, which must never be logged as it is.
+type paymentRequest struct {
+	CardNumber string `json:"card_number"`
+	CVV        string `json:"cvv"`
+	Amount     string `json:"amount"`
+	Reference  string `json:"reference"`
+}
+
+// unmarshallable cannot be marshalled as JSON, so it is logged as formatted by fmt.
+type unmarshallable struct {
+	CVV     string
+	Card    string
+	Channel chan int
+}
+
+func TestRedactingHandler(t *testing.T) {
+	t.Parallel()
+
+	request := paymentRequest{CardNumber: "4242424242424242", CVV: "987", Amount: "1005", Reference: "pan 4000056655665556"}
+	type testCase struct {
+		name     string
+		log      func(logger *slog.Logger)
+		expected map[string]any
+	}
+
+	testCases := []testCase{
+		{
+			"message",
+			func(logger *slog.Logger) { logger.Info("Declined 4242424242424242 with cvv: 987") },
+			map[string]any{"msg": "Declined ************4242 with cvv: ***"},
+		}, {
+			"string attributes",
+			func(logger *slog.Logger) { logger.Info("", "card", "4242424242424242", "note", `"cvc":"123"`) },
+			map[string]any{"card": "************4242", "note": `"cvc":"***"`},
+		}, {
+			"sensitive keys",
+			func(logger *slog.Logger) {
+				logger.Info("", "cvv", "987", "CardNumber", "4242", "security-code", 987, "amount", 1005)
+			},
+			map[string]any{"cvv": Redacted, "CardNumber": Redacted, "security-code": Redacted, "amount": float64(1005)},
+		}, {
+			"number attribute",
+			func(logger *slog.Logger) { logger.Info("", "number", int64(4242424242424242)) },
+			map[string]any{"number": "************4242"},
+		}, {
+			"struct attribute",
+			func(logger *slog.Logger) { logger.Info("", "request", request) },
+			map[string]any{"request": map[string]any{
+				"card_number": Redacted, "cvv": Redacted, "amount": "1005", "reference": "pan ************5556",
+			}},
+		}, {
+			"pointer attribute",
+			func(logger *slog.Logger) { logger.Info("", "request", &request) },
+			map[string]any{"request": map[string]any{
+				"card_number": Redacted, "cvv": Redacted, "amount": "1005", "reference": "pan ************5556",
+			}},
+		}, {
+			"unmarshallable attribute",
+			func(logger *slog.Logger) {
+				logger.Info("", "value", unmarshallable{CVV: "987", Card: "4242424242424242"})
+			},
+			map[string]any{"value": "{CVV:*** Card:************4242 Channel:<nil>}"},
+		}, {
+			"error attribute",
+			func(logger *slog.Logger) {
+				logger.Error("", "error", fmt.Errorf("bank rejected: %w", errors.New("card 4242424242424242 cvv=987")))
+			},
+			map[string]any{"error": "bank rejected: card ************4242 cvv=***"},
+		}, {
+			"group attribute",
+			func(logger *slog.Logger) {
+				logger.Info("", slog.Group("card", "number", "4242424242424242", "cvv", "987", "brand", "visa"))
+			},
+			map[string]any{"card": map[string]any{"number": "************4242", "cvv": Redacted, "brand": "visa"}},
+		}, {
+			"attributes of the logger",
+			func(logger *slog.Logger) {
+				logger.With("cvv", "987").WithGroup("payment").With("card", "4242424242424242").Info("", "last4", "4242")
+			},
+			map[string]any{"cvv": Redacted, "payment": map[string]any{"card": "************4242", "last4": "4242"}},
+		}, {
+			"ID attributes",
+			func(logger *slog.Logger) {
+				logger.Info("", "request_id", "1790000000000000123", "trace_id", int64(1790000000000000123), "payment_id", "4242424242424242")
+			},
+			map[string]any{"request_id": "1790000000000000123", "trace_id": float64(1790000000000000123), "payment_id": "************4242"},
+		}, {
+			"log valuer",
+			func(logger *slog.Logger) { logger.Info("", "card", cardValuer("4242424242424242")) },
+			map[string]any{"card": "************4242"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			var output bytes.Buffer
+			tc.log(slog.New(NewJSONHandler(&output, nil)))
+
+			r.NotContains(output.String(), "4242424242424242")
+			record := map[string]any{}
+			r.NoError(json.Unmarshal(output.Bytes(), &record), "failed to unmarshal %s", output.String())
+			for key, expected := range tc.expected {
+				r.Equal(expected, record[key], key)
+			}
+		})
+	}
+}
+
+// cardValuer is a slog.LogValuer that logs itself as a card number.
+type cardValuer string
+
+func (c cardValuer) LogValue() slog.Value {
+	return slog.StringValue(string(c))
+}
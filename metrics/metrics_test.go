@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Number of requests.", "route", "status")
+	latency := registry.NewHistogram("latency_seconds", "Latency of requests.", []float64{0.1, 1}, "route")
+	registry.NewGaugeFunc("queue_size", "Size of the\nqueue.", func() float64 { return 3 })
+
+	requests.Inc("/payments", "200")
+	requests.Add(2, "/payments", "200")
+	requests.Inc("/health", "503")
+	requests.Inc(`/say "hi"\`, "200")
+	requests.Inc("/line\nbreak", "200")
+	requests.Inc("/tab\tand café", "200")
+	latency.Observe(0.05, "/payments")
+	latency.Observe(0.1, "/payments")
+	latency.Observe(0.5, "/payments")
+	latency.Observe(2, "/payments")
+
+	expected := strings.Join([]string{
+		`# HELP requests_total Number of requests.`,
+		`# TYPE requests_total counter`,
+		`requests_total{route="/health",status="503"} 1`,
+		`requests_total{route="/line\nbreak",status="200"} 1`,
+		`requests_total{route="/payments",status="200"} 3`,
+		`requests_total{route="/say \"hi\"\\",status="200"} 1`,
+		"requests_total{route=\"/tab\tand café\",status=\"200\"} 1",
+		`# HELP latency_seconds Latency of requests.`,
+		`# TYPE latency_seconds histogram`,
+		`latency_seconds_bucket{route="/payments",le="0.1"} 2`,
+		`latency_seconds_bucket{route="/payments",le="1"} 3`,
+		`latency_seconds_bucket{route="/payments",le="+Inf"} 4`,
+		`latency_seconds_sum{route="/payments"} 2.65`,
+		`latency_seconds_count{route="/payments"} 4`,
+		`# HELP queue_size Size of the\nqueue.`,
+		`# TYPE queue_size gauge`,
+		`queue_size 3`,
+		``,
+	}, "\n")
+
+	var written strings.Builder
+	r.NoError(registry.Write(&written))
+	r.Equal(expected, written.String())
+	r.Equal(float64(3), requests.Value("/payments", "200"))
+	r.Equal(uint64(4), latency.Count("/payments"))
+}
+
+func TestRegistryHandler(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	registry := NewRegistry()
+	registry.NewCounter("events_total", "Number of events.").Inc()
+
+	response := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	r.Equal(http.StatusOK, response.Code)
+	r.Equal(ContentType, response.Header().Get("Content-Type"))
+	r.Equal("# HELP events_total Number of events.\n# TYPE events_total counter\nevents_total 1\n", response.Body.String())
+}
+
+func TestMisuse(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name string
+		use  func(registry *Registry)
+	}
+
+	testCases := []testCase{
+		{"duplicate name", func(registry *Registry) {
+			registry.NewCounter("events_total", "")
+			registry.NewCounter("events_total", "")
+		}},
+		{"too few label values", func(registry *Registry) {
+			registry.NewCounter("events_total", "", "type").Inc()
+		}},
+		{"decreasing counter", func(registry *Registry) {
+			registry.NewCounter("events_total", "").Add(-1)
+		}},
+		{"unsorted buckets", func(registry *Registry) {
+			registry.NewHistogram("latency_seconds", "", []float64{1, 0.1})
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Panics(t, func() { tc.use(NewRegistry()) })
+		})
+	}
+}
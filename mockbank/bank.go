@@ -2,49 +2,75 @@ package mockbank
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"sync"
 
 	"golang.org/x/exp/rand"
 
+	"github.com/celestebrant/processout-payment-gateway/bank"
 	"github.com/google/uuid"
 )
 
-// BankClient is the client for making mocked requests to the bank.
+// maxRecordedRequests is how many of its latest requests a BankClient keeps, so that a long running server
+// using the mocked bank does not run out of memory.
+const maxRecordedRequests = 100
+
+// BankClient is a bank.Acquirer that mocks requests to the bank without making any network calls. It
+// records the requests it receives, like a bank would, so that they can be tied to gateway requests.
 type BankClient struct {
-	BaseURL    string
-	HTTPClient *http.Client
+	mu       sync.Mutex
+	requests []RecordedRequest
+}
+
+// RecordedRequest is a request that a BankClient received. RequestID is the request ID passed to the bank,
+// as bank.HTTPClient sends it in the bank.RequestIDHeader header.
+type RecordedRequest struct {
+	// Operation is "make_payment", "authorize", "capture", "refund" or "void".
+	Operation string
+	// PaymentID is the bank's payment ID, for requests about an existing payment.
+	PaymentID string
+	RequestID string
 }
 
+var _ bank.Acquirer = (*BankClient)(nil)
+
 // NewBankClient instantiates a new bank client.
 func NewBankClient() *BankClient {
-	return &BankClient{
-		BaseURL:    "mockbank.com",
-		HTTPClient: &http.Client{},
-	}
+	return &BankClient{}
 }
 
-// MakePaymentRequest represents the assumed request data the bank API requires, including card details
-// and data about the money to be transacted.
-type MakePaymentRequest struct {
-	CardNumber  string  `json:"card_number"`
-	ExpiryYear  uint    `json:"expiry_year"`
-	ExpiryMonth uint    `json:"expiry_month"`
-	CVV         string  `json:"cvv"`
-	Amount      float64 `json:"amount"`
-	Currency    string  `json:"currency"`
+// Requests returns the latest requests the BankClient received, oldest first.
+func (b *BankClient) Requests() []RecordedRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]RecordedRequest(nil), b.requests...)
 }
 
-// MakePaymentResponse represents the assumed response the bank API returns, containing payment ID and status.
-type MakePaymentResponse struct {
-	PaymentID string `json:"payment_id"`
-	Status    string `json:"status"`
+// record records a request of operation about the payment with the given ID, with the request ID of ctx.
+func (b *BankClient) record(ctx context.Context, operation, paymentID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, RecordedRequest{
+		Operation: operation,
+		PaymentID: paymentID,
+		RequestID: bank.RequestIDFromContext(ctx),
+	})
+	if len(b.requests) > maxRecordedRequests {
+		b.requests = b.requests[len(b.requests)-maxRecordedRequests:]
+	}
 }
 
 // MakePayment mocks a call to an external bank server and then returns the response that
 // is decoded into CallBankResponse. It is assumed that the data returned are: payment_id, status.
-func (b *BankClient) MakePayment(r MakePaymentRequest) (*MakePaymentResponse, error) {
+func (b *BankClient) MakePayment(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	b.record(ctx, "make_payment", "")
+	return makeMockedPayment()
+}
+
+// makeMockedPayment returns the response to a mocked payment.
+func makeMockedPayment() (*bank.MakePaymentResponse, error) {
 	// Generate CallBankResponse with mock data
 	mockData := generateMockedData()
 	mockDataJSON, err := json.Marshal(mockData)
@@ -60,9 +86,60 @@ func (b *BankClient) MakePayment(r MakePaymentRequest) (*MakePaymentResponse, er
 	return callBankResponse, nil
 }
 
+// Authorize mocks a call to an external bank server to authorize a payment. It has the same chance of
+// failure as MakePayment, and a successful authorization has status "AUTHORIZED".
+func (b *BankClient) Authorize(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	b.record(ctx, "authorize", "")
+	response, err := makeMockedPayment()
+	if err != nil {
+		return nil, err
+	}
+	if response.Status == "SUCCESS" {
+		response.Status = "AUTHORIZED"
+	}
+	return response, nil
+}
+
+// Capture mocks a call to an external bank server to capture an authorized payment, which always succeeds.
+func (b *BankClient) Capture(ctx context.Context, r bank.CaptureRequest) (*bank.CaptureResponse, error) {
+	b.record(ctx, "capture", r.PaymentID)
+	return &bank.CaptureResponse{
+		PaymentID: r.PaymentID,
+		Status:    "SUCCESS",
+	}, nil
+}
+
+// Refund mocks a call to an external bank server to refund a captured payment, which always succeeds.
+func (b *BankClient) Refund(ctx context.Context, r bank.RefundRequest) (*bank.RefundResponse, error) {
+	b.record(ctx, "refund", r.PaymentID)
+	return &bank.RefundResponse{
+		RefundID: uuid.New().String(),
+		Status:   "SUCCESS",
+	}, nil
+}
+
+// Void mocks a call to an external bank server to void an authorized payment, which always succeeds.
+func (b *BankClient) Void(ctx context.Context, r bank.VoidRequest) (*bank.VoidResponse, error) {
+	b.record(ctx, "void", r.PaymentID)
+	return &bank.VoidResponse{
+		PaymentID: r.PaymentID,
+		Status:    "VOIDED",
+	}, nil
+}
+
+// Ping always succeeds, since the mocked bank is in the same process.
+func (b *BankClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+// SettlementCurrencies returns bank.DefaultSettlementCurrencies.
+func (b *BankClient) SettlementCurrencies() []string {
+	return bank.DefaultSettlementCurrencies
+}
+
 // decodeBankResponse decodes the bank response into CallBankResponse.
-func decodeBankResponse(responseJSON []byte) (*MakePaymentResponse, error) {
-	callBankResponse := MakePaymentResponse{}
+func decodeBankResponse(responseJSON []byte) (*bank.MakePaymentResponse, error) {
+	callBankResponse := bank.MakePaymentResponse{}
 	data := bytes.NewReader(responseJSON)
 	err := json.NewDecoder(data).Decode(&callBankResponse)
 	if err != nil {
@@ -1,9 +1,11 @@
 package mockbank
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
+	"github.com/celestebrant/processout-payment-gateway/bank"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,9 +34,76 @@ func TestMockData(t *testing.T) {
 func TestCallBank(t *testing.T) {
 	r, a := require.New(t), assert.New(t)
 	bankClient := NewBankClient()
-	callBankRequest := MakePaymentRequest{}
-	callBankResponse, err := bankClient.MakePayment(callBankRequest)
+	callBankRequest := bank.MakePaymentRequest{}
+	callBankResponse, err := bankClient.MakePayment(context.Background(), callBankRequest)
 	r.NoError(err)
 	a.NotEmpty(callBankResponse.PaymentID)
 	a.NotEmpty(callBankResponse.Status)
 }
+
+func TestAuthorizeThenCapture(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+	bankClient := NewBankClient()
+
+	authorizeResponse, err := bankClient.Authorize(context.Background(), bank.MakePaymentRequest{})
+	r.NoError(err)
+	a.NotEmpty(authorizeResponse.PaymentID)
+	r.True(
+		authorizeResponse.Status == "AUTHORIZED" || authorizeResponse.Status == "FAILED",
+		`expected status to be either "AUTHORIZED" or "FAILED", got "%s"`, authorizeResponse.Status,
+	)
+
+	captureResponse, err := bankClient.Capture(context.Background(), bank.CaptureRequest{
+		PaymentID: authorizeResponse.PaymentID,
+		Amount:    500,
+	})
+	r.NoError(err)
+	a.Equal(authorizeResponse.PaymentID, captureResponse.PaymentID)
+	a.Equal("SUCCESS", captureResponse.Status)
+}
+
+func TestRefund(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+	bankClient := NewBankClient()
+
+	refundResponse, err := bankClient.Refund(context.Background(), bank.RefundRequest{PaymentID: "some-id", Amount: 500})
+	r.NoError(err)
+	a.NotEmpty(refundResponse.RefundID)
+	a.Equal("SUCCESS", refundResponse.Status)
+}
+
+func TestVoid(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+	bankClient := NewBankClient()
+
+	voidResponse, err := bankClient.Void(context.Background(), bank.VoidRequest{PaymentID: "some-id"})
+	r.NoError(err)
+	a.Equal("some-id", voidResponse.PaymentID)
+	a.Equal("VOIDED", voidResponse.Status)
+}
+
+func TestBankClientRecordsRequests(t *testing.T) {
+	r := require.New(t)
+	bankClient := NewBankClient()
+	ctx := bank.ContextWithRequestID(context.Background(), "req-123")
+
+	_, err := bankClient.MakePayment(ctx, bank.MakePaymentRequest{})
+	r.NoError(err)
+	_, err = bankClient.Authorize(context.Background(), bank.MakePaymentRequest{})
+	r.NoError(err)
+	_, err = bankClient.Void(ctx, bank.VoidRequest{PaymentID: "some-id"})
+	r.NoError(err)
+
+	r.Equal([]RecordedRequest{
+		{Operation: "make_payment", RequestID: "req-123"},
+		{Operation: "authorize"},
+		{Operation: "void", PaymentID: "some-id", RequestID: "req-123"},
+	}, bankClient.Requests())
+
+	for i := 0; i < maxRecordedRequests; i++ {
+		bankClient.Capture(ctx, bank.CaptureRequest{PaymentID: "other-id"})
+	}
+	requests := bankClient.Requests()
+	r.Len(requests, maxRecordedRequests, "only the latest requests should be kept")
+	r.Equal("capture", requests[0].Operation)
+}
@@ -0,0 +1,241 @@
+package mockbank
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/rand"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Outcome is what the mock bank server does with a payment.
+type Outcome string
+
+const (
+	// OutcomeSuccess responds with status "SUCCESS".
+	OutcomeSuccess Outcome = "success"
+	// OutcomeDeclined responds with status "FAILED" and reason "declined".
+	OutcomeDeclined Outcome = "declined"
+	// OutcomeInsufficientFunds responds with status "FAILED" and reason "insufficient_funds".
+	OutcomeInsufficientFunds Outcome = "insufficient_funds"
+	// OutcomeTimeout does not respond until ServerOptions.Timeout has passed or the caller gives up.
+	OutcomeTimeout Outcome = "timeout"
+	// OutcomeError responds with a http 500 error.
+	OutcomeError Outcome = "error"
+)
+
+// Magic values that script the outcome of a payment made to the mock bank server.
+const (
+	DeclinedCardSuffix          = "0002"
+	InsufficientFundsCardSuffix = "0005"
+	ErrorCardSuffix             = "0500"
+	// TimeoutAmount is in minor units, e.g. 999.99 GBP.
+	TimeoutAmount = 99999
+)
+
+// DefaultServerTimeout is how long the mock bank server hangs for OutcomeTimeout unless configured otherwise.
+const DefaultServerTimeout = 30 * time.Second
+
+// ServerOptions configures the behaviour of the mock bank server.
+type ServerOptions struct {
+	// Latency is added to every response.
+	Latency time.Duration
+	// LatencyJitter adds up to this much extra random latency to every response.
+	LatencyJitter time.Duration
+	// ErrorRate is the probability, from 0 to 1, that a payment without a scripted outcome gets OutcomeError.
+	ErrorRate float64
+	// Timeout is how long to hang for OutcomeTimeout. Defaults to DefaultServerTimeout.
+	Timeout time.Duration
+}
+
+type mockServer struct {
+	options ServerOptions
+}
+
+// NewServer returns a handler serving the bank API that bank.HTTPClient calls. Outcomes are scripted by
+// ScriptedOutcome, so that end-to-end scenarios are deterministic, unless error injection is configured.
+// The request ID in the bank.RequestIDHeader header of a request is echoed in its response.
+func NewServer(options ServerOptions) http.Handler {
+	if options.Timeout == 0 {
+		options.Timeout = DefaultServerTimeout
+	}
+
+	s := &mockServer{options: options}
+	router := mux.NewRouter()
+	router.HandleFunc("/payments", s.makePaymentHandler).Methods("POST")
+	router.HandleFunc("/authorizations", s.authorizeHandler).Methods("POST")
+	router.HandleFunc("/payments/{id}/capture", s.captureHandler).Methods("POST")
+	router.HandleFunc("/payments/{id}/refunds", s.refundHandler).Methods("POST")
+	router.HandleFunc("/payments/{id}/void", s.voidHandler).Methods("POST")
+	router.HandleFunc("/health", s.healthHandler).Methods("GET")
+	return echoRequestID(router)
+}
+
+// echoRequestID echoes the request ID of every request in its response, as banks do so that both sides can
+// find a call in their logs.
+func echoRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(bank.RequestIDHeader); id != "" {
+			w.Header().Set(bank.RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+ScriptedOutcome returns the Outcome the mock bank server gives request, based on these magic values:
+  - Card number ending 0002 is declined
+  - Card number ending 0005 has insufficient funds
+  - Card number ending 0500 causes a bank error
+  - Amount 99999 minor units, e.g. 999.99 GBP, causes a timeout
+  - Anything else succeeds
+*/
+func ScriptedOutcome(request bank.MakePaymentRequest) Outcome {
+	switch {
+	case strings.HasSuffix(request.CardNumber, DeclinedCardSuffix):
+		return OutcomeDeclined
+	case strings.HasSuffix(request.CardNumber, InsufficientFundsCardSuffix):
+		return OutcomeInsufficientFunds
+	case strings.HasSuffix(request.CardNumber, ErrorCardSuffix):
+		return OutcomeError
+	case request.Amount == TimeoutAmount:
+		return OutcomeTimeout
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// healthHandler responds that the mock bank server is up, which it always is if it can respond.
+func (s *mockServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// makePaymentHandler responds to a payment request with its scripted outcome.
+func (s *mockServer) makePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	s.respondWithOutcome(w, r, "SUCCESS")
+}
+
+// authorizeHandler responds to an authorization request with its scripted outcome.
+func (s *mockServer) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	s.respondWithOutcome(w, r, "AUTHORIZED")
+}
+
+// captureHandler captures an authorized payment, which always succeeds.
+func (s *mockServer) captureHandler(w http.ResponseWriter, r *http.Request) {
+	request := bank.CaptureRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "failed to unmarshal the request", http.StatusBadRequest)
+		return
+	}
+
+	if !s.wait(r, s.latency()) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bank.CaptureResponse{
+		PaymentID: mux.Vars(r)["id"],
+		Status:    "SUCCESS",
+	})
+}
+
+// refundHandler refunds a captured payment, which always succeeds.
+func (s *mockServer) refundHandler(w http.ResponseWriter, r *http.Request) {
+	request := bank.RefundRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "failed to unmarshal the request", http.StatusBadRequest)
+		return
+	}
+
+	if !s.wait(r, s.latency()) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bank.RefundResponse{
+		RefundID: uuid.New().String(),
+		Status:   "SUCCESS",
+	})
+}
+
+// voidHandler voids an authorized payment, which always succeeds.
+func (s *mockServer) voidHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.wait(r, s.latency()) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bank.VoidResponse{
+		PaymentID: mux.Vars(r)["id"],
+		Status:    "VOIDED",
+	})
+}
+
+// respondWithOutcome decodes a payment request and responds with its scripted outcome, where a successful
+// payment has successStatus.
+func (s *mockServer) respondWithOutcome(w http.ResponseWriter, r *http.Request, successStatus string) {
+	request := bank.MakePaymentRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "failed to unmarshal the request", http.StatusBadRequest)
+		return
+	}
+
+	outcome := ScriptedOutcome(request)
+	if outcome == OutcomeSuccess && rand.Float64() < s.options.ErrorRate {
+		outcome = OutcomeError
+	}
+
+	if !s.wait(r, s.latency()) {
+		return
+	}
+
+	response := bank.MakePaymentResponse{PaymentID: uuid.New().String()}
+	switch outcome {
+	case OutcomeSuccess:
+		response.Status = successStatus
+	case OutcomeDeclined, OutcomeInsufficientFunds:
+		response.Status = "FAILED"
+		response.Reason = string(outcome)
+	case OutcomeTimeout:
+		if s.wait(r, s.options.Timeout) {
+			http.Error(w, "timed out processing the payment", http.StatusGatewayTimeout)
+		}
+		return
+	case OutcomeError:
+		http.Error(w, "internal bank error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// latency returns the configured latency plus a random amount of jitter.
+func (s *mockServer) latency() time.Duration {
+	latency := s.options.Latency
+	if s.options.LatencyJitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(s.options.LatencyJitter)))
+	}
+	return latency
+}
+
+// wait sleeps for d, returning false if the caller gave up first.
+func (s *mockServer) wait(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,193 @@
+package mockbank
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerScriptedOutcomes(t *testing.T) {
+	t.Parallel()
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{Timeout: time.Second}))
+	defer bankServer.Close()
+	client := bank.NewHTTPClient(bankServer.URL, nil, nil)
+
+	type testCase struct {
+		name                 string
+		modifyRequest        func(req *bank.MakePaymentRequest)
+		expectedStatus       string
+		expectedReason       string
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{
+			"unscripted card succeeds",
+			func(req *bank.MakePaymentRequest) {},
+			"SUCCESS",
+			"",
+			"",
+		}, {
+			"card ending 0002 is declined",
+			func(req *bank.MakePaymentRequest) {
+				req.CardNumber = "1234123412340002"
+			},
+			"FAILED",
+			"declined",
+			"",
+		}, {
+			"card ending 0005 has insufficient funds",
+			func(req *bank.MakePaymentRequest) {
+				req.CardNumber = "1234123412340005"
+			},
+			"FAILED",
+			"insufficient_funds",
+			"",
+		}, {
+			"card ending 0500 returns bank error",
+			func(req *bank.MakePaymentRequest) {
+				req.CardNumber = "1234123412340500"
+			},
+			"",
+			"",
+			"bank responded with status 500",
+		}, {
+			"amount 999.99 times out",
+			func(req *bank.MakePaymentRequest) {
+				req.Amount = 99999
+			},
+			"",
+			"",
+			"bank responded with status 504",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			request := bank.MakePaymentRequest{
+				CardNumber:  "1234123412341234",
+				ExpiryYear:  2099,
+				ExpiryMonth: 12,
+				CVV:         "987",
+				Money:       money.New(1005, "GBP"),
+			}
+			tc.modifyRequest(&request)
+
+			response, err := client.MakePayment(context.Background(), request)
+			if tc.expectedErrorMessage != "" {
+				r.ErrorContains(err, tc.expectedErrorMessage)
+				return
+			}
+			r.NoError(err)
+			r.NotEmpty(response.PaymentID)
+			r.Equal(tc.expectedStatus, response.Status)
+			r.Equal(tc.expectedReason, response.Reason)
+		})
+	}
+}
+
+func TestServerTimeoutRespectsCaller(t *testing.T) {
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{}))
+	defer bankServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := bank.NewHTTPClient(bankServer.URL, nil, nil).MakePayment(ctx, bank.MakePaymentRequest{Money: money.New(TimeoutAmount, "GBP")})
+	r.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func TestServerErrorInjection(t *testing.T) {
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{ErrorRate: 1}))
+	defer bankServer.Close()
+
+	_, err := bank.NewHTTPClient(bankServer.URL, nil, nil).MakePayment(context.Background(), bank.MakePaymentRequest{})
+	r.ErrorContains(err, "bank responded with status 500")
+}
+
+func TestServerLatency(t *testing.T) {
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{Latency: 50 * time.Millisecond}))
+	defer bankServer.Close()
+
+	start := time.Now()
+	_, err := bank.NewHTTPClient(bankServer.URL, nil, nil).MakePayment(context.Background(), bank.MakePaymentRequest{})
+	r.NoError(err)
+	r.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+}
+
+func TestServerAuthorizeCaptureRefund(t *testing.T) {
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{}))
+	defer bankServer.Close()
+	client := bank.NewHTTPClient(bankServer.URL, nil, nil)
+
+	authorizeResponse, err := client.Authorize(context.Background(), bank.MakePaymentRequest{CardNumber: "1234123412341234"})
+	r.NoError(err)
+	r.Equal("AUTHORIZED", authorizeResponse.Status)
+
+	declinedResponse, err := client.Authorize(context.Background(), bank.MakePaymentRequest{CardNumber: "1234123412340002"})
+	r.NoError(err)
+	r.Equal("FAILED", declinedResponse.Status)
+
+	captureResponse, err := client.Capture(context.Background(), bank.CaptureRequest{
+		PaymentID: authorizeResponse.PaymentID,
+		Amount:    500,
+	})
+	r.NoError(err)
+	r.Equal(authorizeResponse.PaymentID, captureResponse.PaymentID)
+	r.Equal("SUCCESS", captureResponse.Status)
+
+	refundResponse, err := client.Refund(context.Background(), bank.RefundRequest{
+		PaymentID: authorizeResponse.PaymentID,
+		Amount:    200,
+	})
+	r.NoError(err)
+	r.NotEmpty(refundResponse.RefundID)
+	r.Equal("SUCCESS", refundResponse.Status)
+}
+
+func TestServerAuthorizeThenVoid(t *testing.T) {
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{}))
+	defer bankServer.Close()
+	client := bank.NewHTTPClient(bankServer.URL, nil, nil)
+
+	authorizeResponse, err := client.Authorize(context.Background(), bank.MakePaymentRequest{CardNumber: "1234123412341234"})
+	r.NoError(err)
+
+	voidResponse, err := client.Void(context.Background(), bank.VoidRequest{PaymentID: authorizeResponse.PaymentID})
+	r.NoError(err)
+	r.Equal(authorizeResponse.PaymentID, voidResponse.PaymentID)
+	r.Equal("VOIDED", voidResponse.Status)
+}
+
+func TestServerEchoesRequestID(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	bankServer := httptest.NewServer(NewServer(ServerOptions{}))
+	defer bankServer.Close()
+
+	request, err := http.NewRequest(http.MethodGet, bankServer.URL+"/health", nil)
+	r.NoError(err)
+	request.Header.Set(bank.RequestIDHeader, "req-123")
+	response, err := http.DefaultClient.Do(request)
+	r.NoError(err)
+	defer response.Body.Close()
+	r.Equal("req-123", response.Header.Get(bank.RequestIDHeader))
+}
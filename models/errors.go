@@ -0,0 +1,23 @@
+package models
+
+// ErrorResponse is the body of every error response from the payment gateway.
+type ErrorResponse struct {
+	// Code is a stable, machine-readable code for the error, e.g. "validation_failed".
+	Code string `json:"code"`
+	// Message describes the error for humans, and may change between releases.
+	Message string `json:"message"`
+	// Field is the request field at fault, if there is exactly one.
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id"`
+	// Errors lists every invalid field of a request that failed validation.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field"`
+}
+
+func (e FieldError) Error() string { return e.Message }
@@ -0,0 +1,21 @@
+package models
+
+// Statuses of a HealthResponse and of each of its components.
+const (
+	HealthOK           = "ok"
+	HealthUnavailable  = "unavailable"
+	HealthShuttingDown = "shutting_down"
+)
+
+// HealthResponse is the body of the health, readiness and liveness probes of the payment gateway. Status is
+// ok if every component is ok.
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+// ComponentHealth is the health of one dependency of the payment gateway. Error describes why it is not ok.
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
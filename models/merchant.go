@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// MerchantStatus is whether a merchant can make payments.
+type MerchantStatus string
+
+const (
+	// MerchantActive is the status of a merchant that can make payments.
+	MerchantActive MerchantStatus = "ACTIVE"
+	// MerchantSuspended is the status of a merchant that cannot make new payments, but can still fetch,
+	// capture and refund its existing payments.
+	MerchantSuspended MerchantStatus = "SUSPENDED"
+)
+
+// TransactionLimit bounds the amount of a single payment in a currency, in minor units. A zero bound does
+// not limit.
+type TransactionLimit struct {
+	MinAmount int64 `json:"min_amount,omitempty"`
+	MaxAmount int64 `json:"max_amount,omitempty"`
+}
+
+// Merchant is a business that makes payments through the gateway. Each of its payments has its ID.
+type Merchant struct {
+	ID     string         `json:"id"`
+	Name   string         `json:"name"`
+	Status MerchantStatus `json:"status"`
+	// DefaultCurrency is the currency of payment requests that do not have one.
+	DefaultCurrency string `json:"default_currency"`
+	// AllowedCurrencies are the currencies the merchant accepts payments in. If empty, every currency the
+	// bank settles in is accepted.
+	AllowedCurrencies []string `json:"allowed_currencies"`
+	// Limits are the transaction limits by currency. Currencies without limits are not limited.
+	Limits    map[string]TransactionLimit `json:"limits,omitempty"`
+	CreatedAt time.Time                   `json:"created_at"`
+	UpdatedAt time.Time                   `json:"updated_at"`
+}
+
+// MerchantRequest is the request to create or replace a merchant. The ID is only read when creating, and
+// a merchant is created as active if it has no status.
+type MerchantRequest struct {
+	ID                string                      `json:"id,omitempty"`
+	Name              string                      `json:"name"`
+	Status            MerchantStatus              `json:"status,omitempty"`
+	DefaultCurrency   string                      `json:"default_currency"`
+	AllowedCurrencies []string                    `json:"allowed_currencies"`
+	Limits            map[string]TransactionLimit `json:"limits,omitempty"`
+}
+
+// CreatedMerchant is the response to creating a merchant, with the merchant's first API key. The key is
+// only ever returned here, so it must be given to the merchant straight away.
+type CreatedMerchant struct {
+	*Merchant
+	APIKey string `json:"api_key"`
+}
+
+// APIKey is a merchant API key issued by the gateway, or a rotated key from an API keys file, as it is
+// stored. Only the hash of the key is kept.
+type APIKey struct {
+	MerchantID string    `json:"merchant_id"`
+	Hash       string    `json:"hash"`
+	CreatedAt  time.Time `json:"created_at"`
+	// ExpiresAt is when a rotated key stops authenticating, or nil if the key does not expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// IssueAPIKeyRequest is the request to issue a merchant a new API key. If GracePeriodSeconds is set, the
+// key rotates the merchant's other keys, which stop authenticating once the grace period has passed.
+type IssueAPIKeyRequest struct {
+	GracePeriodSeconds *int64 `json:"grace_period_seconds,omitempty"`
+}
+
+// IssuedAPIKey is the response to issuing an API key. The key is only ever returned here.
+type IssuedAPIKey struct {
+	MerchantID string    `json:"merchant_id"`
+	APIKey     string    `json:"api_key"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -1,20 +1,77 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/card"
+	"github.com/celestebrant/processout-payment-gateway/money"
+)
+
+// MaskedPayment is a payment as stored and returned by the payment gateway. The embedded Money is the
+// amount requested, and all amounts are in minor units of its currency.
 type MaskedPayment struct {
-	ID               string  `json:"id"`
-	Status           string  `json:"status"`
-	MaskedCardNumber string  `json:"masked_card_number"`
-	ExpiryYear       uint    `json:"expiry_year"`
-	ExpiryMonth      uint    `json:"expiry_month"`
-	Amount           float64 `json:"amount"`
-	Currency         string  `json:"currency"`
+	ID               string        `json:"id"`
+	MerchantID       string        `json:"merchant_id"`
+	Status           PaymentStatus `json:"status"`
+	MaskedCardNumber string        `json:"masked_card_number"`
+	CardBrand        card.Brand    `json:"card_brand"`
+	ExpiryYear       uint          `json:"expiry_year"`
+	ExpiryMonth      uint          `json:"expiry_month"`
+	money.Money
+	CapturedAmount int64     `json:"captured_amount"`
+	RefundedAmount int64     `json:"refunded_amount"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// PaymentList is a page of payments. NextCursor is set if there are more payments, and is passed as the
+// cursor of the request for the next page.
+type PaymentList struct {
+	Payments   []*MaskedPayment `json:"payments"`
+	NextCursor string           `json:"next_cursor,omitempty"`
 }
 
+// ProcessPaymentRequest is the request to make a new payment. Amounts in requests are whole numbers of
+// minor units, or decimals in major units if the server accepts decimal amounts.
 type ProcessPaymentRequest struct {
-	CardNumber  string  `json:"card_number"`
-	ExpiryYear  uint    `json:"expiry_year"`
-	ExpiryMonth uint    `json:"expiry_month"`
-	CVV         string  `json:"cvv"`
-	Amount      float64 `json:"amount"`
-	Currency    string  `json:"currency"`
+	CardNumber  string      `json:"card_number"`
+	ExpiryYear  uint        `json:"expiry_year"`
+	ExpiryMonth uint        `json:"expiry_month"`
+	CVV         string      `json:"cvv"`
+	Amount      json.Number `json:"amount"`
+	Currency    string      `json:"currency"`
+	// Capture defaults to true. If false, the payment is only authorized and must be captured later.
+	Capture *bool `json:"capture,omitempty"`
+}
+
+// CapturePaymentRequest is the request to capture an authorized payment.
+type CapturePaymentRequest struct {
+	// Amount to capture, up to the authorized amount. Defaults to the full authorized amount.
+	Amount *json.Number `json:"amount,omitempty"`
+}
+
+// RefundStatus is the outcome of a refund.
+type RefundStatus string
+
+const (
+	// RefundSucceeded means the bank returned the money.
+	RefundSucceeded RefundStatus = "SUCCESS"
+	// RefundFailed means the bank did not accept the refund.
+	RefundFailed RefundStatus = "FAILED"
+)
+
+// Refund is money returned from a captured payment, in minor units of the payment's currency.
+type Refund struct {
+	ID        string       `json:"id"`
+	PaymentID string       `json:"payment_id"`
+	Status    RefundStatus `json:"status"`
+	money.Money
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateRefundRequest is the request to refund some or all of a captured payment.
+type CreateRefundRequest struct {
+	Amount json.Number `json:"amount"`
+	Reason string      `json:"reason"`
 }
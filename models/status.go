@@ -0,0 +1,94 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// PaymentStatus is the state of a payment. A payment only changes status through Transition, which
+// enforces the allowed transitions between statuses.
+type PaymentStatus string
+
+const (
+	// StatusNew is the status of a payment that the bank has not yet responded to.
+	StatusNew PaymentStatus = ""
+	// StatusAuthorized means the bank holds the funds, and the payment is waiting to be captured.
+	StatusAuthorized PaymentStatus = "AUTHORIZED"
+	// StatusSuccess means the funds have been captured.
+	StatusSuccess PaymentStatus = "SUCCESS"
+	// StatusFailed means the bank did not accept the payment.
+	StatusFailed PaymentStatus = "FAILED"
+	// StatusPartiallyRefunded means some, but not all, of the captured amount has been refunded.
+	StatusPartiallyRefunded PaymentStatus = "PARTIALLY_REFUNDED"
+	// StatusRefunded means all of the captured amount has been refunded.
+	StatusRefunded PaymentStatus = "REFUNDED"
+	// StatusVoided means the authorization was cancelled before capture, and the bank released the funds.
+	StatusVoided PaymentStatus = "VOIDED"
+)
+
+// transitions lists the statuses that each status can move to. Statuses that are not listed are final.
+var transitions = map[PaymentStatus][]PaymentStatus{
+	StatusNew:               {StatusAuthorized, StatusSuccess, StatusFailed},
+	StatusAuthorized:        {StatusSuccess, StatusVoided},
+	StatusSuccess:           {StatusPartiallyRefunded, StatusRefunded},
+	StatusPartiallyRefunded: {StatusPartiallyRefunded, StatusRefunded},
+}
+
+// CanTransitionTo reports whether a payment with status s can move to status next.
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether s is the status of a payment that the bank has responded to.
+func (s PaymentStatus) Valid() bool {
+	switch s {
+	case StatusAuthorized, StatusSuccess, StatusFailed, StatusPartiallyRefunded, StatusRefunded, StatusVoided:
+		return true
+	}
+	return false
+}
+
+// IllegalTransitionError is returned when a payment cannot move from one status to another.
+type IllegalTransitionError struct {
+	From PaymentStatus
+	To   PaymentStatus
+}
+
+func (e *IllegalTransitionError) Error() string {
+	if e.From == StatusNew {
+		return fmt.Sprintf("a new payment cannot have status %q", e.To)
+	}
+	return fmt.Sprintf("payment with status %s cannot move to status %s", e.From, e.To)
+}
+
+// PaymentEvent records a payment moving from one status to another.
+type PaymentEvent struct {
+	PaymentID string        `json:"payment_id"`
+	From      PaymentStatus `json:"from,omitempty"`
+	To        PaymentStatus `json:"to"`
+	Reason    string        `json:"reason"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// Transition moves p to status to, and returns the event recording the transition at time at with reason.
+// It returns an IllegalTransitionError, and leaves p unchanged, if p cannot move to status to.
+func (p *MaskedPayment) Transition(to PaymentStatus, reason string, at time.Time) (*PaymentEvent, error) {
+	if !p.Status.CanTransitionTo(to) {
+		return nil, &IllegalTransitionError{From: p.Status, To: to}
+	}
+
+	event := &PaymentEvent{
+		PaymentID: p.ID,
+		From:      p.Status,
+		To:        to,
+		Reason:    reason,
+		Timestamp: at.UTC(),
+	}
+	p.Status = to
+	return event, nil
+}
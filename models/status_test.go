@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransition(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name                 string
+		from                 PaymentStatus
+		to                   PaymentStatus
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{"new to authorized", StatusNew, StatusAuthorized, ""},
+		{"new to success", StatusNew, StatusSuccess, ""},
+		{"new to failed", StatusNew, StatusFailed, ""},
+		{"new to unknown status returns error", StatusNew, "PENDING", `a new payment cannot have status "PENDING"`},
+		{"authorized to success", StatusAuthorized, StatusSuccess, ""},
+		{"authorized to voided", StatusAuthorized, StatusVoided, ""},
+		{"authorized to refunded returns error", StatusAuthorized, StatusRefunded, "payment with status AUTHORIZED cannot move to status REFUNDED"},
+		{"success to partially refunded", StatusSuccess, StatusPartiallyRefunded, ""},
+		{"success to refunded", StatusSuccess, StatusRefunded, ""},
+		{"success to voided returns error", StatusSuccess, StatusVoided, "payment with status SUCCESS cannot move to status VOIDED"},
+		{"partially refunded to partially refunded", StatusPartiallyRefunded, StatusPartiallyRefunded, ""},
+		{"partially refunded to refunded", StatusPartiallyRefunded, StatusRefunded, ""},
+		{"failed to success returns error", StatusFailed, StatusSuccess, "payment with status FAILED cannot move to status SUCCESS"},
+		{"voided to success returns error", StatusVoided, StatusSuccess, "payment with status VOIDED cannot move to status SUCCESS"},
+		{"refunded to partially refunded returns error", StatusRefunded, StatusPartiallyRefunded, "payment with status REFUNDED cannot move to status PARTIALLY_REFUNDED"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			at := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+			payment := &MaskedPayment{ID: "some-id", Status: tc.from}
+
+			r.Equal(tc.expectedErrorMessage == "", tc.from.CanTransitionTo(tc.to))
+			event, err := payment.Transition(tc.to, "some reason", at)
+
+			if tc.expectedErrorMessage != "" {
+				r.EqualError(err, tc.expectedErrorMessage)
+				r.Nil(event)
+				r.Equal(tc.from, payment.Status, "status should not change")
+				return
+			}
+
+			r.NoError(err)
+			r.Equal(tc.to, payment.Status)
+			r.Equal(&PaymentEvent{
+				PaymentID: "some-id",
+				From:      tc.from,
+				To:        tc.to,
+				Reason:    "some reason",
+				Timestamp: at,
+			}, event)
+		})
+	}
+}
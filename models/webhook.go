@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookEventType is the kind of payment lifecycle event that a webhook endpoint can be sent.
+type WebhookEventType string
+
+const (
+	// EventPaymentAuthorized is sent when the bank authorizes a payment that is not captured yet.
+	EventPaymentAuthorized WebhookEventType = "payment.authorized"
+	// EventPaymentSucceeded is sent when a payment is captured, whether when it is made or later.
+	EventPaymentSucceeded WebhookEventType = "payment.succeeded"
+	// EventPaymentFailed is sent when the bank does not accept a payment.
+	EventPaymentFailed WebhookEventType = "payment.failed"
+	// EventPaymentVoided is sent when an authorized payment is voided.
+	EventPaymentVoided WebhookEventType = "payment.voided"
+	// EventPaymentRefunded is sent when some or all of a payment is refunded.
+	EventPaymentRefunded WebhookEventType = "payment.refunded"
+	// EventRefundCreated is sent for every refund, including refunds that the bank does not accept.
+	EventRefundCreated WebhookEventType = "refund.created"
+)
+
+// WebhookEventTypes are every WebhookEventType, in the order they are documented.
+var WebhookEventTypes = []WebhookEventType{
+	EventPaymentAuthorized,
+	EventPaymentSucceeded,
+	EventPaymentFailed,
+	EventPaymentVoided,
+	EventPaymentRefunded,
+	EventRefundCreated,
+}
+
+// WebhookEndpoint is a URL of a merchant that is sent the events of its payments of EventTypes.
+type WebhookEndpoint struct {
+	ID         string             `json:"id"`
+	MerchantID string             `json:"merchant_id"`
+	URL        string             `json:"url"`
+	EventTypes []WebhookEventType `json:"event_types"`
+	// Secret signs the deliveries to the endpoint. It is only shown when the endpoint is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookEndpointRequest is the request to create a webhook endpoint.
+type WebhookEndpointRequest struct {
+	URL        string             `json:"url"`
+	EventTypes []WebhookEventType `json:"event_types"`
+}
+
+// WebhookEvent is the body of a webhook delivery. Data is the payment, or the refund for refund events.
+type WebhookEvent struct {
+	ID        string           `json:"id"`
+	Type      WebhookEventType `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      json.RawMessage  `json:"data"`
+}
+
+// WebhookDeliveryStatus is whether a webhook delivery has reached its endpoint.
+type WebhookDeliveryStatus string
+
+const (
+	// DeliveryPending is the status of a delivery that is waiting for its next attempt.
+	DeliveryPending WebhookDeliveryStatus = "PENDING"
+	// DeliverySucceeded is the status of a delivery that the endpoint responded to with a 2xx status.
+	DeliverySucceeded WebhookDeliveryStatus = "SUCCEEDED"
+	// DeliveryFailed is the status of a delivery that ran out of attempts, or whose endpoint was deleted.
+	DeliveryFailed WebhookDeliveryStatus = "FAILED"
+)
+
+// WebhookDelivery is an event to be sent to a webhook endpoint, and the attempts to send it so far.
+type WebhookDelivery struct {
+	ID         string                `json:"id"`
+	EndpointID string                `json:"endpoint_id"`
+	MerchantID string                `json:"merchant_id"`
+	Event      WebhookEvent          `json:"event"`
+	Status     WebhookDeliveryStatus `json:"status"`
+	Attempts   []WebhookAttempt      `json:"attempts"`
+	// NextAttemptAt is when a pending delivery is next attempted, and is unset otherwise.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// WebhookAttempt is one attempt to send a webhook delivery. StatusCode is the endpoint's response status,
+// and Error describes why the attempt failed, if it did.
+type WebhookAttempt struct {
+	Number     int       `json:"number"`
+	Timestamp  time.Time `json:"timestamp"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
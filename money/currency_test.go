@@ -0,0 +1,65 @@
+package money
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupCurrency(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		code             string
+		expectedExists   bool
+		expectedNumeric  string
+		expectedExponent int
+	}
+
+	testCases := []testCase{
+		{"GBP", true, "826", 2},
+		{"USD", true, "840", 2},
+		{"CHF", true, "756", 2},
+		{"SEK", true, "752", 2},
+		{"JPY", true, "392", 0},
+		{"KWD", true, "414", 3},
+		{"CLF", true, "990", 4},
+		{"ALL", true, "008", 2},
+		{"gbp", false, "", 0},
+		{"XAU", false, "", 0},
+		{"ABC", false, "", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.code, func(t *testing.T) {
+			r := require.New(t)
+			currency, exists := LookupCurrency(tc.code)
+			r.Equal(tc.expectedExists, exists)
+			if exists {
+				r.Equal(tc.code, currency.Code)
+				r.Equal(tc.expectedNumeric, currency.Numeric)
+				r.Equal(tc.expectedExponent, currency.Exponent)
+			}
+		})
+	}
+}
+
+func TestCurrencyTable(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	alphabetic, numeric := regexp.MustCompile(`^[A-Z]{3}$`), regexp.MustCompile(`^\d{3}$`)
+	numericCodes := make(map[string]string)
+	for code, currency := range currencies {
+		r.Equal(code, currency.Code, "currency should be keyed by its code")
+		r.Regexp(alphabetic, currency.Code)
+		r.Regexp(numeric, currency.Numeric, "numeric code of %s", code)
+		r.Contains([]int{0, 2, 3, 4}, currency.Exponent, "exponent of %s", code)
+		r.NotEmpty(currency.Name)
+
+		other, duplicate := numericCodes[currency.Numeric]
+		r.False(duplicate, "%s and %s have the same numeric code", code, other)
+		numericCodes[currency.Numeric] = code
+	}
+}
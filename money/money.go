@@ -0,0 +1,103 @@
+// Package money represents amounts of money as whole numbers of a currency's minor unit, e.g. pence for GBP,
+// so that amounts are exact and no precision is lost to floating point arithmetic.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnknownCurrency is returned for a currency code that is not in the ISO 4217 table.
+	ErrUnknownCurrency = errors.New("unknown currency code")
+	// ErrInvalidAmount is returned for an amount that is not a non-negative number.
+	ErrInvalidAmount = errors.New("amount must be a positive number")
+	// ErrTooPrecise is returned for a decimal amount with more decimal places than the currency's exponent.
+	ErrTooPrecise = errors.New("amount has too many decimal places for the currency")
+)
+
+// Money is an amount of a currency, in the currency's minor unit.
+type Money struct {
+	// Amount in minor units, e.g. 1005 is 10.05 GBP, and 1005 is 1005 JPY.
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// New returns Money of amount minor units of currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMinor parses a whole number of minor units of currency, e.g. "1005" GBP is 10.05 GBP.
+func ParseMinor(amount, currency string) (Money, error) {
+	if _, err := Exponent(currency); err != nil {
+		return Money{}, err
+	}
+	if !isDigits(amount) {
+		return Money{}, ErrInvalidAmount
+	}
+	minor, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	return New(minor, currency), nil
+}
+
+// ParseDecimal parses a decimal amount in major units of currency, e.g. "10.05" GBP is 1005 minor units.
+// The amount is parsed exactly, and may have up to as many decimal places as the currency's exponent.
+func ParseDecimal(amount, currency string) (Money, error) {
+	exponent, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	whole, fraction, hasPoint := strings.Cut(amount, ".")
+	if !isDigits(whole) || (hasPoint && !isDigits(fraction)) {
+		return Money{}, ErrInvalidAmount
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > exponent {
+		return Money{}, ErrTooPrecise
+	}
+
+	minor, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", exponent-len(fraction)), 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	return New(minor, currency), nil
+}
+
+// Decimal formats m in major units with as many decimal places as the currency's exponent, e.g. "10.05".
+func (m Money) Decimal() string {
+	exponent, err := Exponent(m.Currency)
+	if err != nil || exponent == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	digits := fmt.Sprintf("%0*d", exponent+1, amount)
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// String formats m in major units followed by the currency code, e.g. "10.05 GBP".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDecimal(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name          string
+		amount        string
+		currency      string
+		expected      Money
+		expectedError error
+	}
+
+	testCases := []testCase{
+		{"two decimal places", "10.05", "GBP", New(1005, "GBP"), nil},
+		{"one decimal place", "0.1", "GBP", New(10, "GBP"), nil},
+		{"whole number", "12", "EUR", New(1200, "EUR"), nil},
+		{"trailing zeros beyond exponent", "10.050", "GBP", New(1005, "GBP"), nil},
+		{"zero decimal currency", "1005", "JPY", New(1005, "JPY"), nil},
+		{"zero decimal currency with decimals returns error", "10.5", "JPY", Money{}, ErrTooPrecise},
+		{"three decimal currency", "1.234", "KWD", New(1234, "KWD"), nil},
+		{"three decimal currency with four decimals returns error", "1.2345", "KWD", Money{}, ErrTooPrecise},
+		{"more than two decimal places returns error", "0.009", "GBP", Money{}, ErrTooPrecise},
+		{"negative returns error", "-0.01", "GBP", Money{}, ErrInvalidAmount},
+		{"exponent notation returns error", "1e3", "GBP", Money{}, ErrInvalidAmount},
+		{"missing whole part returns error", ".5", "GBP", Money{}, ErrInvalidAmount},
+		{"overflow returns error", "99999999999999999999", "GBP", Money{}, ErrInvalidAmount},
+		{"unknown currency returns error", "10", "XYZ", Money{}, ErrUnknownCurrency},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			parsed, err := ParseDecimal(tc.amount, tc.currency)
+			if tc.expectedError != nil {
+				r.ErrorIs(err, tc.expectedError)
+				return
+			}
+			r.NoError(err)
+			r.Equal(tc.expected, parsed)
+		})
+	}
+}
+
+func TestParseMinor(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name          string
+		amount        string
+		currency      string
+		expected      Money
+		expectedError error
+	}
+
+	testCases := []testCase{
+		{"whole number", "1005", "GBP", New(1005, "GBP"), nil},
+		{"zero", "0", "GBP", New(0, "GBP"), nil},
+		{"decimal returns error", "10.05", "GBP", Money{}, ErrInvalidAmount},
+		{"negative returns error", "-1", "GBP", Money{}, ErrInvalidAmount},
+		{"unknown currency returns error", "10", "XYZ", Money{}, ErrUnknownCurrency},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			parsed, err := ParseMinor(tc.amount, tc.currency)
+			if tc.expectedError != nil {
+				r.ErrorIs(err, tc.expectedError)
+				return
+			}
+			r.NoError(err)
+			r.Equal(tc.expected, parsed)
+		})
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		money    Money
+		expected string
+	}
+
+	testCases := []testCase{
+		{New(1005, "GBP"), "10.05"},
+		{New(5, "GBP"), "0.05"},
+		{New(0, "EUR"), "0.00"},
+		{New(-150, "EUR"), "-1.50"},
+		{New(1005, "JPY"), "1005"},
+		{New(1234, "KWD"), "1.234"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.money.Currency+" "+tc.expected, func(t *testing.T) {
+			r := require.New(t)
+			r.Equal(tc.expected, tc.money.Decimal())
+			r.Equal(tc.expected+" "+tc.money.Currency, tc.money.String())
+		})
+	}
+}
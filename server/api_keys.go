@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+// apiKeyPrefix starts every generated API key, so that leaked keys are easy to recognise.
+const apiKeyPrefix = "sk_"
+
+type apiKey struct {
+	merchantID string
+	createdAt  time.Time
+	expiresAt  time.Time // zero if the key does not expire
+	// issued is whether the key was issued by the gateway, rather than read from an API keys file, and so
+	// is kept in the MerchantStore
+	issued bool
+}
+
+/*
+APIKeyStore holds the secret API keys that merchants authenticate with. Only the SHA-256 hash of each key
+is kept, so the keys cannot be recovered from the store.
+
+A merchant can have several keys at once, so that a key can be rotated without downtime: RotateKey issues a
+new key, and the merchant's previous keys keep working until the end of a grace period. The hashes of the
+keys issued by IssueKey and RotateKey, and the expiries of rotated keys, are kept in the MerchantStore by
+the server, and read back by LoadStored when it restarts.
+*/
+type APIKeyStore struct {
+	mu    sync.Mutex
+	clock Clock
+	keys  map[string]*apiKey // by hash
+}
+
+// NewAPIKeyStore instantiates an empty APIKeyStore, which expires rotated keys by clock.
+func NewAPIKeyStore(clock Clock) *APIKeyStore {
+	return &APIKeyStore{
+		clock: clock,
+		keys:  make(map[string]*apiKey),
+	}
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of key, which is how keys are stored.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// AddHashedKey lets the merchant with the given ID authenticate with the key whose HashAPIKey is hash.
+func (s *APIKeyStore) AddHashedKey(merchantID, hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[strings.ToLower(hash)] = &apiKey{merchantID: merchantID}
+}
+
+// IssueKey generates a new API key for the merchant with the given ID. The key is only returned here, so
+// it must be given to the merchant straight away.
+func (s *APIKeyStore) IssueKey(merchantID string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[HashAPIKey(key)] = &apiKey{merchantID: merchantID, createdAt: s.clock.Now().UTC(), issued: true}
+	return key, nil
+}
+
+// RotateKey issues a new API key for the merchant with the given ID, and expires the merchant's other keys
+// after gracePeriod. Keys that already expire sooner are left as they are.
+func (s *APIKeyStore) RotateKey(merchantID string, gracePeriod time.Duration) (string, error) {
+	s.mu.Lock()
+	expiresAt := s.clock.Now().Add(gracePeriod)
+	for _, key := range s.keys {
+		if key.merchantID == merchantID && (key.expiresAt.IsZero() || key.expiresAt.After(expiresAt)) {
+			key.expiresAt = expiresAt
+		}
+	}
+	s.mu.Unlock()
+	return s.IssueKey(merchantID)
+}
+
+// Authenticate returns the ID of the merchant that key belongs to, or false if key is unknown or expired.
+func (s *APIKeyStore) Authenticate(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := HashAPIKey(key)
+	stored, exists := s.keys[hash]
+	if !exists {
+		return "", false
+	}
+	if !stored.expiresAt.IsZero() && !s.clock.Now().Before(stored.expiresAt) {
+		delete(s.keys, hash)
+		return "", false
+	}
+	return stored.merchantID, true
+}
+
+/*
+Load adds the hashed keys read from r, which has one key per line as a merchant ID and the HashAPIKey of the
+key, separated by whitespace. Blank lines and lines starting with # are ignored. A merchant may have several
+lines, one for each key that is valid while it rotates keys.
+*/
+func (s *APIKeyStore) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("API keys line %d: expected a merchant ID and a key hash", line)
+		}
+		if hash, err := hex.DecodeString(fields[1]); err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("API keys line %d: key hash should be a hex-encoded SHA-256 hash", line)
+		}
+		s.AddHashedKey(fields[0], fields[1])
+	}
+	return scanner.Err()
+}
+
+/*
+LoadStored adds the keys kept in merchants: those issued to merchants, and the expiries that rotation gave
+keys read from an API keys file. It must be called after Load, so that a rotated key from the file stops
+working once its grace period has passed, rather than working again after a restart. Keys that have expired
+are left out, or revoked if they were read from the file.
+*/
+func (s *APIKeyStore) LoadStored(merchants MerchantStore) error {
+	stored, err := merchants.ListAPIKeys()
+	if err != nil {
+		return fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for _, key := range stored {
+		hash := strings.ToLower(key.Hash)
+		if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+			delete(s.keys, hash)
+			continue
+		}
+		loaded, exists := s.keys[hash]
+		if !exists {
+			loaded = &apiKey{merchantID: key.MerchantID, createdAt: key.CreatedAt, issued: true}
+			s.keys[hash] = loaded
+		}
+		if key.ExpiresAt != nil {
+			loaded.expiresAt = *key.ExpiresAt
+		}
+	}
+	return nil
+}
+
+// storedKeys returns the keys of the merchant with the given ID that are kept in a MerchantStore, oldest
+// first: those issued by the gateway, and those from a file that expire because they were rotated.
+func (s *APIKeyStore) storedKeys(merchantID string) []*models.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []*models.APIKey
+	for hash, key := range s.keys {
+		if key.merchantID != merchantID || !key.issued && key.expiresAt.IsZero() {
+			continue
+		}
+		stored := &models.APIKey{MerchantID: merchantID, Hash: hash, CreatedAt: key.createdAt}
+		if !key.expiresAt.IsZero() {
+			expiresAt := key.expiresAt
+			stored.ExpiresAt = &expiresAt
+		}
+		keys = append(keys, stored)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// revokeKeys stops every key of the merchant with the given ID from authenticating.
+func (s *APIKeyStore) revokeKeys(merchantID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, key := range s.keys {
+		if key.merchantID == merchantID {
+			delete(s.keys, hash)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("issued key authenticates its merchant", func(t *testing.T) {
+		r := require.New(t)
+		keys := NewAPIKeyStore(SystemClock)
+
+		key, err := keys.IssueKey("merchant")
+		r.NoError(err)
+		r.True(strings.HasPrefix(key, apiKeyPrefix))
+
+		merchantID, ok := keys.Authenticate(key)
+		r.True(ok)
+		r.Equal("merchant", merchantID)
+
+		_, ok = keys.Authenticate(key + "x")
+		r.False(ok, "unknown key should not authenticate")
+	})
+
+	t.Run("keys are stored hashed", func(t *testing.T) {
+		r := require.New(t)
+		keys := NewAPIKeyStore(SystemClock)
+
+		key, err := keys.IssueKey("merchant")
+		r.NoError(err)
+		r.NotContains(keys.keys, key)
+		r.Contains(keys.keys, HashAPIKey(key))
+	})
+
+	t.Run("rotated keys expire after the grace period", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		keys := NewAPIKeyStore(clock)
+
+		oldKey, err := keys.IssueKey("merchant")
+		r.NoError(err)
+		otherKey, err := keys.IssueKey("other")
+		r.NoError(err)
+
+		newKey, err := keys.RotateKey("merchant", time.Hour)
+		r.NoError(err)
+		r.NotEqual(oldKey, newKey)
+
+		clock.Advance(time.Hour - time.Second)
+		_, ok := keys.Authenticate(oldKey)
+		r.True(ok, "old key should work during the grace period")
+
+		clock.Advance(time.Second)
+		_, ok = keys.Authenticate(oldKey)
+		r.False(ok, "old key should expire after the grace period")
+		_, ok = keys.Authenticate(newKey)
+		r.True(ok)
+		_, ok = keys.Authenticate(otherKey)
+		r.True(ok, "other merchants' keys should not be rotated")
+	})
+
+	t.Run("issued keys are loaded from the merchant store", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		keys := NewAPIKeyStore(clock)
+		keys.AddHashedKey("merchant", HashAPIKey("sk_file"))
+		keys.AddHashedKey("merchant", HashAPIKey("sk_other_file"))
+
+		r.Empty(keys.storedKeys("merchant"), "keys from a file should not be stored until they are rotated")
+		oldKey, err := keys.IssueKey("merchant")
+		r.NoError(err)
+		newKey, err := keys.RotateKey("merchant", time.Hour)
+		r.NoError(err)
+		stored := keys.storedKeys("merchant")
+		r.Len(stored, 4, "issued keys and the expiries of rotated file keys should be stored")
+
+		store := NewMemoryStore()
+		for _, key := range stored {
+			r.NoError(store.AddAPIKey(key))
+		}
+		// Like a restart, which reads the keys file before the store
+		restart := func() *APIKeyStore {
+			reloaded := NewAPIKeyStore(clock)
+			reloaded.AddHashedKey("merchant", HashAPIKey("sk_file"))
+			reloaded.AddHashedKey("merchant", HashAPIKey("sk_other_file"))
+			r.NoError(reloaded.LoadStored(store))
+			return reloaded
+		}
+
+		reloaded := restart()
+		_, ok := reloaded.Authenticate(oldKey)
+		r.True(ok, "rotated key should work until it expires")
+		_, ok = reloaded.Authenticate("sk_file")
+		r.True(ok, "rotated key from a file should work until it expires")
+
+		clock.Advance(time.Hour)
+		reloaded = restart()
+		r.Len(reloaded.keys, 1, "expired keys should not be loaded, even from a file")
+		merchantID, ok := reloaded.Authenticate(newKey)
+		r.True(ok)
+		r.Equal("merchant", merchantID)
+	})
+
+	t.Run("load hashed keys", func(t *testing.T) {
+		r := require.New(t)
+		keys := NewAPIKeyStore(SystemClock)
+
+		r.NoError(keys.Load(strings.NewReader(
+			"# merchant key-hash\n\nfirst " + HashAPIKey("sk_first") + "\nsecond " + HashAPIKey("sk_second") + "\n",
+		)))
+		merchantID, ok := keys.Authenticate("sk_second")
+		r.True(ok)
+		r.Equal("second", merchantID)
+
+		r.ErrorContains(keys.Load(strings.NewReader("first\n")), "line 1")
+		r.ErrorContains(keys.Load(strings.NewReader("first sk_first\n")), "hex-encoded SHA-256 hash")
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	t.Parallel()
+
+	t.Run("requests without a valid key are rejected", func(t *testing.T) {
+		type testCase struct {
+			name   string
+			header string
+		}
+
+		testCases := []testCase{
+			{"no header", ""},
+			{"wrong scheme", "Basic " + testAPIKey},
+			{"no key", "Bearer "},
+			{"unknown key", "Bearer sk_unknown"},
+		}
+
+		s := newTestServer(t)
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := require.New(t)
+				request, response := httptest.NewRequest("GET", utils.Path, nil), httptest.NewRecorder()
+				if tc.header != "" {
+					request.Header.Set("Authorization", tc.header)
+				}
+				s.Routes().ServeHTTP(response, request)
+
+				r.Equal(http.StatusUnauthorized, response.Code)
+				r.Contains(response.Header().Get("WWW-Authenticate"), "Bearer")
+				r.Equal(CodeUnauthorized, decodeError(t, response).Code)
+			})
+		}
+	})
+
+	t.Run("payments are scoped to their merchant", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		r.NoError(s.merchants.AddMerchant(newTestMerchant("other-merchant")))
+		otherKey, err := s.apiKeys.IssueKey("other-merchant")
+		r.NoError(err)
+
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, response.Code)
+		payment := decodePayment(t, response)
+		r.Equal(testMerchantID, payment.MerchantID)
+
+		response = serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+		r.Equal(http.StatusOK, response.Code)
+
+		for _, path := range []string{"", "/refunds", "/events"} {
+			response = serveWithKey(t, s, otherKey, "GET", utils.Path+"/"+payment.ID+path, nil)
+			r.Equal(http.StatusNotFound, response.Code, "other merchant should not find the payment at %s", path)
+			r.Equal(CodePaymentNotFound, decodeError(t, response).Code)
+		}
+		response = serveWithKey(t, s, otherKey, "POST", utils.Path+"/"+payment.ID+"/refunds", models.CreateRefundRequest{Amount: "100"})
+		r.Equal(http.StatusNotFound, response.Code, "other merchant should not refund the payment")
+
+		response = serveWithKey(t, s, otherKey, "GET", utils.Path, nil)
+		r.Equal(http.StatusOK, response.Code)
+		r.Contains(response.Body.String(), `"payments":[]`, "other merchant should not list the payment")
+	})
+
+	t.Run("keys of unknown merchants are rejected", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServer(t)
+		deletedKey, err := s.apiKeys.IssueKey("deleted-merchant")
+		r.NoError(err)
+
+		response := serveWithKey(t, s, deletedKey, "GET", utils.Path, nil)
+		r.Equal(http.StatusUnauthorized, response.Code)
+		r.Equal("invalid API key", decodeError(t, response).Message)
+	})
+
+	t.Run("idempotency keys are scoped to their merchant", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		r.NoError(s.merchants.AddMerchant(newTestMerchant("other-merchant")))
+		otherKey, err := s.apiKeys.IssueKey("other-merchant")
+		r.NoError(err)
+
+		send := func(apiKey string) models.MaskedPayment {
+			body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+			r.NoError(err, "failed to marshal request")
+			request, response := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body)), httptest.NewRecorder()
+			request.Header.Set("Authorization", "Bearer "+apiKey)
+			request.Header.Set(IdempotencyKeyHeader, "same-key")
+			s.Routes().ServeHTTP(response, request)
+			r.Equal(http.StatusOK, response.Code)
+			r.Empty(response.Header().Get(IdempotentReplayedHeader))
+			return decodePayment(t, response)
+		}
+
+		first, second := send(testAPIKey), send(otherKey)
+		r.NotEqual(first.ID, second.ID, "other merchant should not replay the payment")
+		r.Equal("other-merchant", second.MerchantID)
+	})
+}
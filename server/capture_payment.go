@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/gorilla/mux"
+)
+
+/*
+CapturePaymentHandler handles capturing an authorized payment. The request body may set an amount to
+capture, up to the authorized amount, and otherwise the full authorized amount is captured. A payment can
+only be captured once, and the bank releases any authorized funds that are not captured.
+*/
+func (s *Server) CapturePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := validatePaymentID(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	request := models.CapturePaymentRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, r, readError(err, "failed to unmarshal the request"))
+		return
+	}
+
+	unlock := s.paymentLocks.Lock(id)
+	defer unlock()
+
+	maskedPayment, ok := s.fetchPayment(w, r, id)
+	if !ok {
+		return
+	}
+
+	if !maskedPayment.Status.CanTransitionTo(models.StatusSuccess) {
+		s.writeError(w, r, newError(http.StatusConflict, CodeInvalidPaymentStatus, fmt.Sprintf("payment with status %s cannot be captured", maskedPayment.Status)))
+		return
+	}
+
+	amount := maskedPayment.Amount
+	if request.Amount != nil {
+		captureAmount, err := parseAmount(*request.Amount, maskedPayment.Currency, s.decimalAmounts)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if captureAmount.Amount > maskedPayment.Amount {
+			s.writeError(w, r, amountError(CodeAmountTooLarge, "capture amount must not exceed the authorized amount"))
+			return
+		}
+		amount = captureAmount.Amount
+	}
+
+	bankResponse, err := s.bank.Capture(r.Context(), bank.CaptureRequest{PaymentID: id, Amount: amount})
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to capture payment with the bank", "error", err)
+		s.writeError(w, r, newError(http.StatusBadGateway, CodeBankError, "unexpected error from call to the bank"))
+		return
+	}
+	if bankResponse == nil {
+		s.logger.ErrorContext(r.Context(), "Received no capture response from the bank")
+		s.writeError(w, r, newError(http.StatusBadGateway, CodeBankError, "failed to receive a response from the bank"))
+		return
+	}
+	if models.PaymentStatus(bankResponse.Status) != models.StatusSuccess {
+		s.writeError(w, r, newError(http.StatusBadGateway, CodeBankRejected, "the bank did not capture the payment"))
+		return
+	}
+
+	maskedPayment.CapturedAmount = amount
+	if err := s.transition(r.Context(), maskedPayment, models.StatusSuccess, "captured "+money.New(amount, maskedPayment.Currency).String()); err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to store captured payment", "error", err)
+		s.writeError(w, r, newError(http.StatusInternalServerError, CodeInternalError, "failed to store the payment"))
+		return
+	}
+	s.logger.InfoContext(r.Context(), "Captured payment", "amount", money.New(maskedPayment.CapturedAmount, maskedPayment.Currency).String())
+
+	json.NewEncoder(w).Encode(maskedPayment)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapturePaymentHandler(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name                   string
+		captureRequest         *models.CapturePaymentRequest
+		expectedStatusCode     int
+		expectedCapturedAmount int64
+		expectedErrorMessage   string
+	}
+
+	amount := func(a json.Number) *json.Number { return &a }
+
+	testCases := []testCase{
+		{
+			"no body captures full amount",
+			nil,
+			http.StatusOK,
+			1005,
+			"",
+		}, {
+			"partial amount is captured",
+			&models.CapturePaymentRequest{Amount: amount("500")},
+			http.StatusOK,
+			500,
+			"",
+		}, {
+			"amount exceeding authorized amount returns 400 error response",
+			&models.CapturePaymentRequest{Amount: amount("1006")},
+			http.StatusBadRequest,
+			0,
+			"capture amount must not exceed the authorized amount",
+		}, {
+			"invalid amount returns 400 error response",
+			&models.CapturePaymentRequest{Amount: amount("0")},
+			http.StatusBadRequest,
+			0,
+			"amount must be greater than zero",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, &fakeBank{})
+
+			processRequest := utils.ValidProcessPaymentRequest()
+			capture := false
+			processRequest.Capture = &capture
+			response := serve(t, s, "POST", utils.Path, processRequest)
+			r.Equal(http.StatusOK, response.Code)
+			authorized := decodePayment(t, response)
+			r.Equal(models.StatusAuthorized, authorized.Status)
+			r.Zero(authorized.CapturedAmount)
+
+			var body any
+			if tc.captureRequest != nil {
+				body = tc.captureRequest
+			}
+			response = serve(t, s, "POST", utils.Path+"/"+authorized.ID+"/capture", body)
+			r.Equal(tc.expectedStatusCode, response.Code)
+
+			if tc.expectedStatusCode != http.StatusOK {
+				r.Equal(tc.expectedErrorMessage, decodeError(t, response).Message)
+				return
+			}
+
+			captured := decodePayment(t, response)
+			r.Equal(models.StatusSuccess, captured.Status)
+			r.Equal(tc.expectedCapturedAmount, captured.CapturedAmount)
+
+			// The captured payment should be stored
+			response = serve(t, s, "GET", utils.Path+"/"+authorized.ID, nil)
+			r.Equal(http.StatusOK, response.Code)
+			r.Equal(captured, decodePayment(t, response))
+		})
+	}
+}
+
+func TestCapturePaymentHandlerConflicts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("captured payment cannot be captured again", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, response.Code)
+		captured := decodePayment(t, response)
+		r.Equal(models.StatusSuccess, captured.Status)
+
+		response = serve(t, s, "POST", utils.Path+"/"+captured.ID+"/capture", nil)
+		r.Equal(http.StatusConflict, response.Code)
+		errorResponse := decodeError(t, response)
+		r.Equal(CodeInvalidPaymentStatus, errorResponse.Code)
+		r.Equal("payment with status SUCCESS cannot be captured", errorResponse.Message)
+	})
+
+	t.Run("failed payment cannot be captured", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{failPayments: true})
+
+		processRequest := utils.ValidProcessPaymentRequest()
+		capture := false
+		processRequest.Capture = &capture
+		response := serve(t, s, "POST", utils.Path, processRequest)
+		r.Equal(http.StatusOK, response.Code)
+		failed := decodePayment(t, response)
+
+		response = serve(t, s, "POST", utils.Path+"/"+failed.ID+"/capture", nil)
+		r.Equal(http.StatusConflict, response.Code)
+	})
+
+	t.Run("nonexistent payment returns 404 error response", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		response := serve(t, s, "POST", utils.Path+"/"+uuid.New().String()+"/capture", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+)
+
+/*
+checkCurrency checks that merchant can make a payment in currency, and if not, returns an error describing
+why:
+  - The merchant must accept the currency, if it has a list of allowed currencies
+  - The bank must settle in the currency
+*/
+func (s *Server) checkCurrency(merchant *models.Merchant, currency string) error {
+	if len(merchant.AllowedCurrencies) > 0 && !slices.Contains(merchant.AllowedCurrencies, currency) {
+		return newFieldError(http.StatusBadRequest, CodeCurrencyNotAccepted, fmt.Sprintf("currency %s is not accepted", currency), "currency")
+	}
+	if !slices.Contains(s.bank.SettlementCurrencies(), currency) {
+		return newFieldError(http.StatusBadRequest, CodeCurrencyNotSettled, fmt.Sprintf("no bank can settle payments in %s", currency), "currency")
+	}
+	return nil
+}
+
+// checkLimits checks that amount is within the transaction limits of merchant for its currency.
+func checkLimits(merchant *models.Merchant, amount money.Money) error {
+	limit, exists := merchant.Limits[amount.Currency]
+	switch {
+	case !exists:
+		return nil
+	case limit.MinAmount > 0 && amount.Amount < limit.MinAmount:
+		minimum := money.New(limit.MinAmount, amount.Currency)
+		return newFieldError(http.StatusBadRequest, CodeAmountOutsideLimits, fmt.Sprintf("amount is less than the minimum of %s", minimum), "amount")
+	case limit.MaxAmount > 0 && amount.Amount > limit.MaxAmount:
+		maximum := money.New(limit.MaxAmount, amount.Currency)
+		return newFieldError(http.StatusBadRequest, CodeAmountOutsideLimits, fmt.Sprintf("amount is more than the maximum of %s", maximum), "amount")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessPaymentCurrencies(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name                 string
+		allowedCurrencies    []string
+		bankCurrencies       []string
+		currency             string
+		expectedStatusCode   int
+		expectedErrorCode    string
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{"settled and accepted by default", nil, nil, "SEK", http.StatusOK, "", ""},
+		{"accepted by the merchant", []string{"GBP", "JPY"}, nil, "JPY", http.StatusOK, "", ""},
+		{"not accepted by the merchant", []string{"GBP"}, nil, "USD", http.StatusBadRequest, CodeCurrencyNotAccepted, "currency USD is not accepted"},
+		{"not settled by the bank", nil, []string{"GBP"}, "EUR", http.StatusBadRequest, CodeCurrencyNotSettled, "no bank can settle payments in EUR"},
+		{"accepted but not settled", []string{"GBP", "NZD"}, nil, "NZD", http.StatusBadRequest, CodeCurrencyNotSettled, "no bank can settle payments in NZD"},
+		{"unknown currency", nil, nil, "XYZ", http.StatusBadRequest, CodeValidationFailed, "invalid currency code"},
+		{"merchant's default currency if none is given", []string{"GBP"}, nil, "", http.StatusOK, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, &fakeBank{currencies: tc.bankCurrencies})
+			merchant := newTestMerchant(testMerchantID)
+			merchant.AllowedCurrencies = tc.allowedCurrencies
+			r.NoError(s.merchants.AddMerchant(merchant))
+
+			request := utils.ValidProcessPaymentRequest()
+			request.Currency = tc.currency
+			response := serve(t, s, "POST", utils.Path, request)
+
+			r.Equal(tc.expectedStatusCode, response.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				errorResponse := decodeError(t, response)
+				r.Equal(tc.expectedErrorCode, errorResponse.Code)
+				r.Equal(tc.expectedErrorMessage, errorResponse.Message)
+				r.Equal("currency", errorResponse.Field)
+			}
+		})
+	}
+}
+
+func TestProcessPaymentLimits(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServerWithBank(t, &fakeBank{})
+	merchant := newTestMerchant(testMerchantID)
+	merchant.Limits = map[string]models.TransactionLimit{"GBP": {MinAmount: 100, MaxAmount: 5000}}
+	require.NoError(t, s.merchants.AddMerchant(merchant))
+
+	type testCase struct {
+		name                 string
+		amount               json.Number
+		currency             string
+		expectedStatusCode   int
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{"within the limits", "1005", "GBP", http.StatusOK, ""},
+		{"at the minimum", "100", "GBP", http.StatusOK, ""},
+		{"at the maximum", "5000", "GBP", http.StatusOK, ""},
+		{"below the minimum", "99", "GBP", http.StatusBadRequest, "amount is less than the minimum of 1.00 GBP"},
+		{"above the maximum", "5001", "GBP", http.StatusBadRequest, "amount is more than the maximum of 50.00 GBP"},
+		{"currency without limits", "999999", "EUR", http.StatusOK, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			request := utils.ValidProcessPaymentRequest()
+			request.Amount, request.Currency = tc.amount, tc.currency
+			response := serve(t, s, "POST", utils.Path, request)
+
+			r.Equal(tc.expectedStatusCode, response.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				errorResponse := decodeError(t, response)
+				r.Equal(CodeAmountOutsideLimits, errorResponse.Code)
+				r.Equal(tc.expectedErrorMessage, errorResponse.Message)
+				r.Equal("amount", errorResponse.Field)
+			}
+		})
+	}
+}
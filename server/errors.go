@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+)
+
+// Codes of error responses.
+const (
+	CodeValidationFailed         = "validation_failed"
+	CodeMalformedRequest         = "malformed_request"
+	CodeRequestTooLarge          = "request_too_large"
+	CodeUnauthorized             = "unauthorized"
+	CodeInvalidSignature         = "invalid_signature"
+	CodeCurrencyNotAccepted      = "currency_not_accepted"
+	CodeCurrencyNotSettled       = "currency_not_settled"
+	CodeAmountOutsideLimits      = "amount_outside_limits"
+	CodeMerchantSuspended        = "merchant_suspended"
+	CodeMerchantNotFound         = "merchant_not_found"
+	CodeMerchantExists           = "merchant_exists"
+	CodeWebhookNotFound          = "webhook_not_found"
+	CodePaymentNotFound          = "payment_not_found"
+	CodeInvalidPaymentStatus     = "invalid_payment_status"
+	CodeIdempotencyKeyReused     = "idempotency_key_reused"
+	CodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+	CodeIdempotencyKeyFailed     = "idempotency_key_failed"
+	CodeBankError                = "bank_error"
+	CodeBankRejected             = "bank_rejected"
+	CodeNotFound                 = "not_found"
+	CodeMethodNotAllowed         = "method_not_allowed"
+	CodeInternalError            = "internal_error"
+)
+
+// Codes of field errors, listed in the errors of a validation_failed error response.
+const (
+	CodeInvalidCardNumber     = "invalid_card_number"
+	CodeUnsupportedCardBrand  = "unsupported_card_brand"
+	CodeInvalidExpiryYear     = "invalid_expiry_year"
+	CodeInvalidExpiryMonth    = "invalid_expiry_month"
+	CodeCardExpired           = "card_expired"
+	CodeInvalidCVV            = "invalid_cvv"
+	CodeInvalidCurrency       = "invalid_currency"
+	CodeInvalidAmount         = "invalid_amount"
+	CodeAmountTooLarge        = "amount_too_large"
+	CodeInvalidReason         = "invalid_reason"
+	CodeInvalidPaymentID      = "invalid_payment_id"
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeInvalidQuery          = "invalid_query"
+	CodeInvalidMerchantID     = "invalid_merchant_id"
+	CodeInvalidMerchantName   = "invalid_merchant_name"
+	CodeInvalidMerchantStatus = "invalid_merchant_status"
+	CodeInvalidGracePeriod    = "invalid_grace_period"
+	CodeInvalidWebhookURL     = "invalid_webhook_url"
+	CodeInvalidEventType      = "invalid_event_type"
+)
+
+// apiError is an error that is sent to the client with an http status code and an error code.
+type apiError struct {
+	status  int
+	code    string
+	message string
+	field   string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+// newError returns an apiError with the given http status code, error code and message.
+func newError(status int, code, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+// readError returns the error for a request body that could not be read or decoded because of err: a http
+// 413 error if the body is larger than maxRequestBodyBytes, and otherwise a http 400 error with message.
+func readError(err error, message string) *apiError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return newError(
+			http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+			fmt.Sprintf("request body should have up to %d bytes", maxRequestBodyBytes),
+		)
+	}
+	return newError(http.StatusBadRequest, CodeMalformedRequest, message)
+}
+
+// newFieldError is newError for an error about a single field of the request.
+func newFieldError(status int, code, message, field string) *apiError {
+	err := newError(status, code, message)
+	err.field = field
+	return err
+}
+
+// validationErrors are the problems with each invalid field of a request.
+type validationErrors []models.FieldError
+
+func (e validationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+/*
+writeError writes err as a JSON ErrorResponse:
+  - an apiError with its status and code
+  - validationErrors or a models.FieldError as a http 400 error response with code validation_failed,
+    listing every field error
+  - any other error is logged and hidden behind a http 500 error response with code internal_error
+
+The code is also the outcome of the handler's span, if it has one.
+*/
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	response := models.ErrorResponse{RequestID: RequestIDFromContext(r.Context())}
+	status := http.StatusInternalServerError
+
+	var (
+		apiErr     *apiError
+		fieldErrs  validationErrors
+		fieldError models.FieldError
+	)
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.status
+		response.Code, response.Message, response.Field = apiErr.code, apiErr.message, apiErr.field
+	case errors.As(err, &fieldError):
+		fieldErrs = validationErrors{fieldError}
+		fallthrough
+	case errors.As(err, &fieldErrs):
+		status = http.StatusBadRequest
+		response.Code, response.Message, response.Errors = CodeValidationFailed, fieldErrs.Error(), fieldErrs
+		if len(fieldErrs) == 1 {
+			response.Field = fieldErrs[0].Field
+		}
+	default:
+		s.logger.ErrorContext(r.Context(), "Unexpected error handling request", "method", r.Method, "path", r.URL.Path, "error", err)
+		response.Code, response.Message = CodeInternalError, "internal server error"
+	}
+
+	span := tracing.SpanFromContext(r.Context())
+	span.SetAttributes("outcome", response.Code)
+	if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(response.Message))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// NotFoundHandler writes a http 404 error response for requests to paths that do not exist.
+func (s *Server) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, newError(http.StatusNotFound, CodeNotFound, "no such endpoint"))
+}
+
+// MethodNotAllowedHandler writes a http 405 error response for requests with a method the path does not
+// support.
+func (s *Server) MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path))
+}
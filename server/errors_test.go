@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenBank is a fakeBank whose payments fail with err, or panic if err is nil.
+type brokenBank struct {
+	fakeBank
+	err error
+}
+
+func (b *brokenBank) MakePayment(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	if b.err == nil {
+		panic("bank exploded")
+	}
+	return nil, b.err
+}
+
+// silentBank is a fakeBank that makes payments, but returns no response and no error for anything else.
+type silentBank struct {
+	fakeBank
+}
+
+func (b *silentBank) Capture(ctx context.Context, r bank.CaptureRequest) (*bank.CaptureResponse, error) {
+	return nil, nil
+}
+
+func (b *silentBank) Refund(ctx context.Context, r bank.RefundRequest) (*bank.RefundResponse, error) {
+	return nil, nil
+}
+
+func (b *silentBank) Void(ctx context.Context, r bank.VoidRequest) (*bank.VoidResponse, error) {
+	return nil, nil
+}
+
+func TestErrorResponses(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name               string
+		bank               bank.Acquirer
+		method             string
+		path               string
+		body               any
+		expectedStatusCode int
+		expectedCode       string
+		expectedMessage    string
+	}
+
+	testCases := []testCase{
+		{
+			"unknown path", &fakeBank{}, "GET", "/unknown", nil,
+			http.StatusNotFound, CodeNotFound, "no such endpoint",
+		}, {
+			"method not allowed", &fakeBank{}, "DELETE", utils.Path, nil,
+			http.StatusMethodNotAllowed, CodeMethodNotAllowed, "DELETE is not allowed for /payments",
+		}, {
+			"malformed body", &fakeBank{}, "POST", utils.Path, "not a payment",
+			http.StatusBadRequest, CodeMalformedRequest, "failed to unmarshal the request",
+		}, {
+			"bank error", &brokenBank{err: errors.New("connection refused")}, "POST", utils.Path, utils.ValidProcessPaymentRequest(),
+			http.StatusBadGateway, CodeBankError, "unexpected error from call to the bank",
+		}, {
+			"panic in a handler", &brokenBank{}, "POST", utils.Path, utils.ValidProcessPaymentRequest(),
+			http.StatusInternalServerError, CodeInternalError, "internal server error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, tc.bank)
+
+			response := serve(t, s, tc.method, tc.path, tc.body)
+			r.Equal(tc.expectedStatusCode, response.Code)
+			r.Equal("application/json", response.Header().Get("Content-Type"))
+
+			errorResponse := decodeError(t, response)
+			r.Equal(tc.expectedCode, errorResponse.Code)
+			r.Equal(tc.expectedMessage, errorResponse.Message)
+			r.NotEmpty(errorResponse.RequestID)
+			r.Empty(errorResponse.Errors)
+		})
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	s := newTestServerWithBank(t, &fakeBank{})
+
+	request := utils.ValidProcessPaymentRequest()
+	request.CVV = "12"
+	request.Currency = "XYZ"
+	response := serve(t, s, "POST", utils.Path, request)
+	r.Equal(http.StatusBadRequest, response.Code)
+
+	errorResponse := decodeError(t, response)
+	r.Equal(CodeValidationFailed, errorResponse.Code)
+	r.Equal("cvv should have 3 digits for visa; invalid currency code", errorResponse.Message)
+	r.Empty(errorResponse.Field, "field should only be set for a single invalid field")
+	r.Len(errorResponse.Errors, 2)
+	r.Equal("cvv", errorResponse.Errors[0].Field)
+	r.Equal(CodeInvalidCVV, errorResponse.Errors[0].Code)
+	r.Equal("currency", errorResponse.Errors[1].Field)
+	r.Equal(CodeInvalidCurrency, errorResponse.Errors[1].Code)
+
+	// Every request has its own ID
+	other := decodeError(t, serve(t, s, "POST", utils.Path, request))
+	r.NotEqual(errorResponse.RequestID, other.RequestID)
+}
+
+func TestBankWithoutResponse(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		operation string
+		capture   bool
+		body      any
+	}
+
+	// Only authorized payments can be captured or voided, and only captured payments can be refunded
+	testCases := []testCase{
+		{"capture", false, nil},
+		{"void", false, nil},
+		{"refunds", true, models.CreateRefundRequest{Amount: "100", Reason: "returned"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.operation, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, &silentBank{})
+
+			request := utils.ValidProcessPaymentRequest()
+			request.Capture = &tc.capture
+			payment := decodePayment(t, serve(t, s, "POST", utils.Path, request))
+
+			response := serve(t, s, "POST", utils.Path+"/"+payment.ID+"/"+tc.operation, tc.body)
+			r.Equal(http.StatusBadGateway, response.Code)
+			r.Equal(CodeBankError, decodeError(t, response).Code)
+		})
+	}
+}
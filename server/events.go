@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/gorilla/mux"
+)
+
+// transition moves payment to status to through the payment state machine, then stores the payment and the
+// event that records the transition with reason, and publishes the payment to the merchant's webhooks. The
+// caller must hold the lock for the payment.
+func (s *Server) transition(ctx context.Context, payment *models.MaskedPayment, to models.PaymentStatus, reason string) error {
+	event, err := payment.Transition(to, reason, s.clock.Now())
+	if err != nil {
+		return err
+	}
+	if err := s.addPayment(ctx, payment); err != nil {
+		return fmt.Errorf("failed to store payment: %w", err)
+	}
+	if err := s.store.AddEvent(event); err != nil {
+		return fmt.Errorf("failed to store payment event: %w", err)
+	}
+	s.publish(payment.MerchantID, paymentEventType(to), payment)
+	return nil
+}
+
+// ListEventsHandler handles listing the status transitions of a payment, oldest first.
+func (s *Server) ListEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := validatePaymentID(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if _, ok := s.fetchPayment(w, r, id); !ok {
+		return
+	}
+
+	events, err := s.store.ListEvents(id)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to fetch payment events", "error", err)
+		s.writeError(w, r, newError(http.StatusInternalServerError, CodeInternalError, "failed to fetch the events"))
+		return
+	}
+
+	json.NewEncoder(w).Encode(events)
+}
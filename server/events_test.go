@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListEventsHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("every transition is recorded", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		s := newTestServerWithBank(t, &fakeBank{})
+		s.clock = clock
+
+		processRequest := utils.ValidProcessPaymentRequest()
+		capture := false
+		processRequest.Capture = &capture
+		response := serve(t, s, "POST", utils.Path, processRequest)
+		r.Equal(http.StatusOK, response.Code)
+		payment := decodePayment(t, response)
+
+		clock.Advance(time.Minute)
+		response = serve(t, s, "POST", utils.Path+"/"+payment.ID+"/capture", nil)
+		r.Equal(http.StatusOK, response.Code)
+
+		clock.Advance(time.Minute)
+		response = serve(t, s, "POST", utils.Path+"/"+payment.ID+"/refunds", models.CreateRefundRequest{Amount: "1005"})
+		r.Equal(http.StatusCreated, response.Code)
+		refund := models.Refund{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&refund))
+
+		response = serve(t, s, "GET", utils.Path+"/"+payment.ID+"/events", nil)
+		r.Equal(http.StatusOK, response.Code)
+		events := []models.PaymentEvent{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&events))
+
+		r.Equal([]models.PaymentEvent{
+			{
+				PaymentID: payment.ID,
+				From:      models.StatusNew,
+				To:        models.StatusAuthorized,
+				Reason:    "payment authorized by the bank",
+				Timestamp: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC),
+			}, {
+				PaymentID: payment.ID,
+				From:      models.StatusAuthorized,
+				To:        models.StatusSuccess,
+				Reason:    "captured 10.05 GBP",
+				Timestamp: time.Date(2024, 7, 1, 12, 1, 0, 0, time.UTC),
+			}, {
+				PaymentID: payment.ID,
+				From:      models.StatusSuccess,
+				To:        models.StatusRefunded,
+				Reason:    "refunded 10.05 GBP in refund " + refund.ID,
+				Timestamp: time.Date(2024, 7, 1, 12, 2, 0, 0, time.UTC),
+			},
+		}, events)
+	})
+
+	t.Run("failed payment is recorded", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{failPayments: true})
+
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, response.Code)
+		payment := decodePayment(t, response)
+
+		response = serve(t, s, "GET", utils.Path+"/"+payment.ID+"/events", nil)
+		r.Equal(http.StatusOK, response.Code)
+		events := []models.PaymentEvent{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&events))
+		r.Len(events, 1)
+		r.Equal(models.StatusFailed, events[0].To)
+		r.Equal("payment failed at the bank", events[0].Reason)
+	})
+
+	t.Run("nonexistent payment returns 404 error response", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		response := serve(t, s, "GET", utils.Path+"/"+uuid.New().String()+"/events", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+const (
+	recordTypePayment         = "payment"
+	recordTypeRefund          = "refund"
+	recordTypeEvent           = "event"
+	recordTypeMerchant        = "merchant"
+	recordTypeMerchantDeleted = "merchant_deleted"
+	recordTypeAPIKey          = "api_key"
+
+	recordTypeWebhookEndpoint        = "webhook_endpoint"
+	recordTypeWebhookEndpointDeleted = "webhook_endpoint_deleted"
+	recordTypeWebhookDelivery        = "webhook_delivery"
+)
+
+// logRecord is a single line of the FileStore log. Only the field matching Type is set.
+type logRecord struct {
+	Type    string                `json:"type"`
+	Payment *models.MaskedPayment `json:"payment,omitempty"`
+	Refund  *models.Refund        `json:"refund,omitempty"`
+	Event   *models.PaymentEvent  `json:"event,omitempty"`
+	// Merchant is set for both merchant and merchant_deleted records, and only has an ID for the latter
+	Merchant *models.Merchant `json:"merchant,omitempty"`
+	APIKey   *models.APIKey   `json:"api_key,omitempty"`
+	// WebhookEndpoint is set for both webhook_endpoint and webhook_endpoint_deleted records, and only has an
+	// ID for the latter
+	WebhookEndpoint *models.WebhookEndpoint `json:"webhook_endpoint,omitempty"`
+	WebhookDelivery *models.WebhookDelivery `json:"webhook_delivery,omitempty"`
+}
+
+/*
+FileStore is a PaymentStore, MerchantStore and WebhookStore backed by an append-only log file of JSON
+records, one per line.
+
+Every write is appended to the log and synced to disk before it is applied to an in-memory index, which
+serves all reads. When the store is opened the log is replayed to rebuild the index, and the latest record
+for each payment wins. A final line left incomplete by a crash is discarded. A write that fails is
+truncated from the log, so that it is neither replayed nor followed by later records.
+*/
+type FileStore struct {
+	mu    sync.Mutex
+	file  logFile
+	index *MemoryStore
+	// offset is the end of the last complete record in the log, where the next record is written
+	offset int64
+	// broken is set if a failed write could not be truncated from the log, after which every write fails
+	broken error
+}
+
+// logFile is the file a FileStore logs to, which tests replace to simulate failing disks.
+type logFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenFileStore opens the log file at path, creating it if necessary, and replays it into memory.
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open payment log: %w", err)
+	}
+
+	s := &FileStore{
+		file:  file,
+		index: NewMemoryStore(),
+	}
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddPayment appends payment to the log and then stores it in the index.
+func (s *FileStore) AddPayment(payment *models.MaskedPayment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypePayment, Payment: payment}); err != nil {
+		return err
+	}
+	return s.index.AddPayment(payment)
+}
+
+// GetPayment returns the payment with the given ID, or ErrPaymentNotFound.
+func (s *FileStore) GetPayment(id string) (*models.MaskedPayment, error) {
+	return s.index.GetPayment(id)
+}
+
+// ListPayments returns the payments matching query, in its order and up to its limit.
+func (s *FileStore) ListPayments(query PaymentQuery) ([]*models.MaskedPayment, error) {
+	return s.index.ListPayments(query)
+}
+
+// CountPayments returns the number of stored payments.
+func (s *FileStore) CountPayments() (int, error) {
+	return s.index.CountPayments()
+}
+
+// AddRefund appends refund to the log and then stores it in the index.
+func (s *FileStore) AddRefund(refund *models.Refund) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypeRefund, Refund: refund}); err != nil {
+		return err
+	}
+	return s.index.AddRefund(refund)
+}
+
+// ListRefunds returns the refunds of the payment with the given ID, oldest first.
+func (s *FileStore) ListRefunds(paymentID string) ([]*models.Refund, error) {
+	return s.index.ListRefunds(paymentID)
+}
+
+// AddEvent appends event to the log and then stores it in the index.
+func (s *FileStore) AddEvent(event *models.PaymentEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypeEvent, Event: event}); err != nil {
+		return err
+	}
+	return s.index.AddEvent(event)
+}
+
+// ListEvents returns the events of the payment with the given ID, oldest first.
+func (s *FileStore) ListEvents(paymentID string) ([]*models.PaymentEvent, error) {
+	return s.index.ListEvents(paymentID)
+}
+
+// Ping checks that the log file is still open and accessible.
+func (s *FileStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Stat(); err != nil {
+		return fmt.Errorf("payment log is not accessible: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying log file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// append writes record to the end of the log and syncs it to disk. If either fails, whatever was written of
+// the record is truncated from the log. The caller must hold s.mu.
+func (s *FileStore) append(record logRecord) error {
+	if s.broken != nil {
+		return s.broken
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record: %w", record.Type, err)
+	}
+	line = append(line, '\n')
+
+	if _, err := s.file.Write(line); err != nil {
+		return s.rollback(fmt.Errorf("failed to write %s record: %w", record.Type, err))
+	}
+	if err := s.file.Sync(); err != nil {
+		return s.rollback(fmt.Errorf("failed to sync payment log: %w", err))
+	}
+	s.offset += int64(len(line))
+	return nil
+}
+
+// rollback truncates the log to the end of the last complete record after a write failed with err, and
+// returns err. If the log cannot be truncated, the store is broken and refuses further writes, since they
+// would follow a torn record. The caller must hold s.mu.
+func (s *FileStore) rollback(err error) error {
+	if truncateErr := s.truncate(); truncateErr != nil {
+		s.broken = fmt.Errorf("payment log is broken by a failed write: %w", truncateErr)
+		return errors.Join(err, s.broken)
+	}
+	return err
+}
+
+// truncate removes everything after s.offset from the log, and positions the file there. The caller must
+// hold s.mu.
+func (s *FileStore) truncate() error {
+	if err := s.file.Truncate(s.offset); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// replay applies every record in the log to the index and leaves the file positioned for appending.
+func (s *FileStore) replay() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline is an incomplete write, so discard it
+			if err := s.file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate payment log: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read payment log: %w", err)
+		}
+
+		record := logRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("failed to decode payment log line %d: %w", lineNumber, err)
+		}
+		if err := s.apply(record); err != nil {
+			return fmt.Errorf("failed to apply payment log line %d: %w", lineNumber, err)
+		}
+		offset += int64(len(line))
+	}
+
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek payment log: %w", err)
+	}
+	s.offset = offset
+	return nil
+}
+
+// apply stores the contents of record in the index.
+func (s *FileStore) apply(record logRecord) error {
+	switch record.Type {
+	case recordTypePayment:
+		if record.Payment == nil {
+			return fmt.Errorf("payment record has no payment")
+		}
+		return s.index.AddPayment(record.Payment)
+	case recordTypeRefund:
+		if record.Refund == nil {
+			return fmt.Errorf("refund record has no refund")
+		}
+		return s.index.AddRefund(record.Refund)
+	case recordTypeEvent:
+		if record.Event == nil {
+			return fmt.Errorf("event record has no event")
+		}
+		return s.index.AddEvent(record.Event)
+	case recordTypeMerchant:
+		if record.Merchant == nil {
+			return fmt.Errorf("merchant record has no merchant")
+		}
+		return s.index.AddMerchant(record.Merchant)
+	case recordTypeMerchantDeleted:
+		if record.Merchant == nil {
+			return fmt.Errorf("merchant deletion record has no merchant")
+		}
+		return s.index.DeleteMerchant(record.Merchant.ID)
+	case recordTypeAPIKey:
+		if record.APIKey == nil {
+			return fmt.Errorf("API key record has no key")
+		}
+		return s.index.AddAPIKey(record.APIKey)
+	case recordTypeWebhookEndpoint:
+		if record.WebhookEndpoint == nil {
+			return fmt.Errorf("webhook endpoint record has no endpoint")
+		}
+		return s.index.AddWebhookEndpoint(record.WebhookEndpoint)
+	case recordTypeWebhookEndpointDeleted:
+		if record.WebhookEndpoint == nil {
+			return fmt.Errorf("webhook endpoint deletion record has no endpoint")
+		}
+		return s.index.DeleteWebhookEndpoint(record.WebhookEndpoint.ID)
+	case recordTypeWebhookDelivery:
+		if record.WebhookDelivery == nil {
+			return fmt.Errorf("webhook delivery record has no delivery")
+		}
+		return s.index.AddWebhookDelivery(record.WebhookDelivery)
+	default:
+		return fmt.Errorf("unknown record type %q", record.Type)
+	}
+}
@@ -2,29 +2,36 @@ package server
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
+	"github.com/celestebrant/processout-payment-gateway/models"
 	"github.com/gorilla/mux"
 )
 
 // GetPaymentHandler handles fetching individual payments by ID.
-func GetPaymentHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if len(id) > 36 {
-		http.Error(w, "payment ID should have up to 36 characters", http.StatusBadRequest)
+	if err := validatePaymentID(id); err != nil {
+		s.writeError(w, r, err)
 		return
 	}
 
-	maskedPayment, exists := paymentStore.GetPayment(id)
-	if !exists {
-		http.Error(w, "payment not found", http.StatusNotFound)
+	maskedPayment, ok := s.fetchPayment(w, r, id)
+	if !ok {
 		return
 	}
 
-	log.Println("Fetched payment:", *maskedPayment)
+	s.logger.InfoContext(r.Context(), "Fetched payment")
 
 	json.NewEncoder(w).Encode(maskedPayment)
 }
+
+// validatePaymentID checks that id could have been generated by the bank, which uses up to 36 characters.
+func validatePaymentID(id string) error {
+	if len(id) > 36 {
+		return models.FieldError{Code: CodeInvalidPaymentID, Field: "id", Message: "payment ID should have up to 36 characters"}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+// Paths of the probes, which are served without authentication.
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+	LivezPath   = "/livez"
+)
+
+// healthCheckTimeout is how long each dependency has to respond to a health check.
+const healthCheckTimeout = 2 * time.Second
+
+// Pinger is a dependency that can check it is reachable. A PaymentStore or bank.Acquirer that is a Pinger,
+// like FileStore or bank.HTTPClient, is checked by the health and readiness probes, and the others are
+// assumed to be available.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// LivezHandler handles liveness probes. It responds ok whenever the server can respond, without checking
+// any dependencies, so that a server is only restarted if it is stuck.
+func (s *Server) LivezHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, models.HealthResponse{Status: models.HealthOK})
+}
+
+// HealthzHandler handles health probes. It reports whether the store and the bank are reachable, with a
+// http 503 response if either is not.
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.checkDependencies(r.Context()))
+}
+
+// ReadyzHandler handles readiness probes. The server is ready for traffic if the store and the bank are
+// reachable and it is not shutting down, and otherwise it responds with http 503.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	health := s.checkDependencies(r.Context())
+	server := models.ComponentHealth{Status: models.HealthOK}
+	if !s.Ready() {
+		server.Status = models.HealthShuttingDown
+		health.Status = models.HealthUnavailable
+	}
+	health.Components["server"] = server
+	writeHealth(w, health)
+}
+
+// checkDependencies pings the store and the bank.
+func (s *Server) checkDependencies(ctx context.Context) models.HealthResponse {
+	health := models.HealthResponse{Status: models.HealthOK, Components: map[string]models.ComponentHealth{}}
+	for name, dependency := range map[string]any{"store": s.store, "bank": s.bank} {
+		component := checkDependency(ctx, dependency)
+		if component.Status != models.HealthOK {
+			health.Status = models.HealthUnavailable
+		}
+		health.Components[name] = component
+	}
+	return health
+}
+
+// checkDependency pings dependency if it is a Pinger, giving it up to healthCheckTimeout.
+func checkDependency(ctx context.Context, dependency any) models.ComponentHealth {
+	pinger, ok := dependency.(Pinger)
+	if !ok {
+		return models.ComponentHealth{Status: models.HealthOK}
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := pinger.Ping(ctx); err != nil {
+		return models.ComponentHealth{Status: models.HealthUnavailable, Error: err.Error()}
+	}
+	return models.ComponentHealth{Status: models.HealthOK}
+}
+
+// writeHealth writes health, with a http 503 status if it is not ok.
+func writeHealth(w http.ResponseWriter, health models.HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if health.Status != models.HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// serveProbes serves the probes and the metrics ahead of next, so that they bypass authentication and do not
+// appear in request logs or metrics. They still get a request ID, which their error responses include. They
+// only allow GET and HEAD.
+func (s *Server) serveProbes(next http.Handler) http.Handler {
+	probes := map[string]http.HandlerFunc{
+		HealthzPath: s.HealthzHandler,
+		ReadyzPath:  s.ReadyzHandler,
+		LivezPath:   s.LivezHandler,
+		MetricsPath: s.metrics.registry.Handler().ServeHTTP,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probe, exists := probes[r.URL.Path]
+		switch {
+		case !exists:
+			next.ServeHTTP(w, r)
+		case r.Method != http.MethodGet && r.Method != http.MethodHead:
+			w.Header().Set("Allow", "GET, HEAD")
+			s.MethodNotAllowedHandler(w, r)
+		default:
+			probe(w, r)
+		}
+	})
+}
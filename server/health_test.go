@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	t.Parallel()
+
+	ok := models.ComponentHealth{Status: models.HealthOK}
+	bankDown := models.ComponentHealth{Status: models.HealthUnavailable, Error: "bank is down"}
+
+	type testCase struct {
+		name               string
+		path               string
+		pingErr            error
+		draining           bool
+		expectedStatusCode int
+		expectedHealth     models.HealthResponse
+	}
+
+	testCases := []testCase{
+		{
+			"live", LivezPath, nil, false,
+			http.StatusOK, models.HealthResponse{Status: models.HealthOK},
+		}, {
+			"live while the bank is down", LivezPath, errors.New("bank is down"), false,
+			http.StatusOK, models.HealthResponse{Status: models.HealthOK},
+		}, {
+			"healthy", HealthzPath, nil, false,
+			http.StatusOK, models.HealthResponse{Status: models.HealthOK, Components: map[string]models.ComponentHealth{
+				"store": ok, "bank": ok,
+			}},
+		}, {
+			"unhealthy bank", HealthzPath, errors.New("bank is down"), false,
+			http.StatusServiceUnavailable, models.HealthResponse{Status: models.HealthUnavailable, Components: map[string]models.ComponentHealth{
+				"store": ok, "bank": bankDown,
+			}},
+		}, {
+			"healthy while shutting down", HealthzPath, nil, true,
+			http.StatusOK, models.HealthResponse{Status: models.HealthOK, Components: map[string]models.ComponentHealth{
+				"store": ok, "bank": ok,
+			}},
+		}, {
+			"ready", ReadyzPath, nil, false,
+			http.StatusOK, models.HealthResponse{Status: models.HealthOK, Components: map[string]models.ComponentHealth{
+				"server": ok, "store": ok, "bank": ok,
+			}},
+		}, {
+			"not ready while the bank is down", ReadyzPath, errors.New("bank is down"), false,
+			http.StatusServiceUnavailable, models.HealthResponse{Status: models.HealthUnavailable, Components: map[string]models.ComponentHealth{
+				"server": ok, "store": ok, "bank": bankDown,
+			}},
+		}, {
+			"not ready while shutting down", ReadyzPath, nil, true,
+			http.StatusServiceUnavailable, models.HealthResponse{Status: models.HealthUnavailable, Components: map[string]models.ComponentHealth{
+				"server": {Status: models.HealthShuttingDown}, "store": ok, "bank": ok,
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, &fakeBank{pingErr: tc.pingErr})
+			s.SetReady(!tc.draining)
+
+			// Probes are not authenticated
+			response := serveWithKey(t, s, "", "GET", tc.path, nil)
+			r.Equal(tc.expectedStatusCode, response.Code)
+			r.Equal("application/json", response.Header().Get("Content-Type"))
+			health := models.HealthResponse{}
+			r.NoError(json.NewDecoder(response.Body).Decode(&health))
+			r.Equal(tc.expectedHealth, health)
+		})
+	}
+
+	t.Run("probes only allow GET and HEAD", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		response := serveWithKey(t, s, "", "HEAD", ReadyzPath, nil)
+		r.Equal(http.StatusOK, response.Code)
+		response = serveWithKey(t, s, "", "POST", ReadyzPath, nil)
+		r.Equal(http.StatusMethodNotAllowed, response.Code)
+		errorResponse := decodeError(t, response)
+		r.Equal(CodeMethodNotAllowed, errorResponse.Code)
+		r.NotEmpty(errorResponse.RequestID)
+		r.Equal(response.Header().Get(RequestIDHeader), errorResponse.RequestID)
+	})
+
+	t.Run("closed file store is unavailable", func(t *testing.T) {
+		r := require.New(t)
+		store, err := OpenFileStore(filepath.Join(t.TempDir(), "payments.log"))
+		r.NoError(err)
+		s := newTestServerWithBank(t, &fakeBank{})
+		s.store = store
+
+		response := httptest.NewRecorder()
+		s.ReadyzHandler(response, httptest.NewRequest("GET", ReadyzPath, nil))
+		r.Equal(http.StatusOK, response.Code)
+
+		r.NoError(store.Close())
+		response = httptest.NewRecorder()
+		s.ReadyzHandler(response, httptest.NewRequest("GET", ReadyzPath, nil))
+		r.Equal(http.StatusServiceUnavailable, response.Code)
+		health := models.HealthResponse{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&health))
+		r.Equal(models.HealthUnavailable, health.Components["store"].Status)
+		r.Contains(health.Components["store"].Error, "payment log is not accessible")
+	})
+}
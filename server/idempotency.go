@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header clients set so that retried payment and refund requests
+	// are not sent to the bank twice.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses that were replayed from a previous request.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// DefaultIdempotencyKeyRetention is how long idempotency keys are remembered by default.
+	DefaultIdempotencyKeyRetention = 24 * time.Hour
+
+	// idempotencyKeyLease is how long a key stays in progress, after which the request that claimed it is
+	// assumed to have died and the key can be claimed again. It must outlast bankCallTimeout.
+	idempotencyKeyLease = 2 * time.Minute
+
+	maxIdempotencyKeyLength = 255
+	sweepInterval           = time.Minute
+)
+
+var (
+	errIdempotencyKeyReused = newError(
+		http.StatusConflict, CodeIdempotencyKeyReused, "idempotency key has already been used with a different request body",
+	)
+	errIdempotencyKeyInProgress = newError(
+		http.StatusConflict, CodeIdempotencyKeyInProgress, "a request with this idempotency key is already in progress",
+	)
+	errIdempotencyKeyFailed = newError(
+		http.StatusConflict, CodeIdempotencyKeyFailed,
+		"a request with this idempotency key failed after reaching the bank, so its outcome is unknown",
+	)
+)
+
+type idempotencyRecord struct {
+	requestHash string
+	response    any // nil while the original request is in progress
+	// failed is set if the original request failed after reaching the bank, which may have moved the money
+	failed    bool
+	createdAt time.Time
+}
+
+// IdempotencyStore remembers the payment or refund produced for each idempotency key, so that a repeated
+// request can be replayed instead of being sent to the bank again. Keys are forgotten after the retention
+// window, or after idempotencyKeyLease if they are still in progress. Keys are only held in memory, whatever
+// the PaymentStore, so all of them are forgotten when the server restarts.
+type IdempotencyStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	clock     Clock
+	lastSweep time.Time
+	records   map[string]*idempotencyRecord
+}
+
+// NewIdempotencyStore instantiates an IdempotencyStore that retains keys for the given duration,
+// measured with clock.
+func NewIdempotencyStore(retention time.Duration, clock Clock) *IdempotencyStore {
+	return &IdempotencyStore{
+		retention: retention,
+		clock:     clock,
+		records:   make(map[string]*idempotencyRecord),
+	}
+}
+
+/*
+Begin claims key for a request whose body hashes to requestHash. It returns:
+  - the stored response, if the key has already completed with the same request body
+  - errIdempotencyKeyReused, if the key has been used with a different request body
+  - errIdempotencyKeyInProgress, if another request with the key has not yet completed
+  - errIdempotencyKeyFailed, if the key has failed with an unknown outcome
+  - nil and no error, if the key is new, in which case the caller must later call Complete, Fail or Release
+*/
+func (s *IdempotencyStore) Begin(key, requestHash string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.sweep(now)
+
+	record, exists := s.records[key]
+	if exists && s.expired(record, now) {
+		delete(s.records, key)
+		exists = false
+	}
+
+	if !exists {
+		s.records[key] = &idempotencyRecord{requestHash: requestHash, createdAt: now}
+		return nil, nil
+	}
+
+	if record.requestHash != requestHash {
+		return nil, errIdempotencyKeyReused
+	}
+	if record.failed {
+		return nil, errIdempotencyKeyFailed
+	}
+	if record.response == nil {
+		return nil, errIdempotencyKeyInProgress
+	}
+	return record.response, nil
+}
+
+// Complete stores response, a payment or refund, as the outcome of the request that claimed key.
+func (s *IdempotencyStore) Complete(key string, response any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, exists := s.records[key]; exists {
+		record.response = response
+	}
+}
+
+// Fail records that the request that claimed key failed after reaching the bank, so that retrying it with
+// the same key cannot send it to the bank twice.
+func (s *IdempotencyStore) Fail(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, exists := s.records[key]; exists && record.response == nil {
+		record.failed = true
+	}
+}
+
+// Release forgets a key claimed by a request that did not complete, so that the request can be retried. It
+// must only be called if the request did not reach the bank. Completed and failed keys are kept.
+func (s *IdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, exists := s.records[key]; exists && record.response == nil && !record.failed {
+		delete(s.records, key)
+	}
+}
+
+// sweep removes expired records, at most once per sweepInterval. The caller must hold s.mu.
+func (s *IdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, record := range s.records {
+		if s.expired(record, now) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// expired reports whether record is older than the retention window, or than idempotencyKeyLease if it is
+// still in progress. The caller must hold s.mu.
+func (s *IdempotencyStore) expired(record *idempotencyRecord, now time.Time) bool {
+	if record.response == nil && !record.failed {
+		return now.Sub(record.createdAt) > min(idempotencyKeyLease, s.retention)
+	}
+	return now.Sub(record.createdAt) > s.retention
+}
+
+// hashRequestBody returns a hex-encoded SHA-256 digest of body, used to compare repeated requests.
+func hashRequestBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
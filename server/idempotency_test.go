@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("new key proceeds then replays completed payment", func(t *testing.T) {
+		r := require.New(t)
+		store := NewIdempotencyStore(time.Hour, SystemClock)
+
+		stored, err := store.Begin("key", "hash")
+		r.NoError(err)
+		r.Nil(stored)
+
+		payment := &models.MaskedPayment{ID: "some-id"}
+		store.Complete("key", payment)
+
+		stored, err = store.Begin("key", "hash")
+		r.NoError(err)
+		r.Equal(payment, stored)
+	})
+
+	t.Run("key reused with different body returns error", func(t *testing.T) {
+		r := require.New(t)
+		store := NewIdempotencyStore(time.Hour, SystemClock)
+
+		_, err := store.Begin("key", "hash")
+		r.NoError(err)
+		store.Complete("key", &models.MaskedPayment{ID: "some-id"})
+
+		_, err = store.Begin("key", "other-hash")
+		r.ErrorIs(err, errIdempotencyKeyReused)
+	})
+
+	t.Run("key in progress returns error", func(t *testing.T) {
+		r := require.New(t)
+		store := NewIdempotencyStore(time.Hour, SystemClock)
+
+		_, err := store.Begin("key", "hash")
+		r.NoError(err)
+
+		_, err = store.Begin("key", "hash")
+		r.ErrorIs(err, errIdempotencyKeyInProgress)
+	})
+
+	t.Run("released key can be claimed again", func(t *testing.T) {
+		r := require.New(t)
+		store := NewIdempotencyStore(time.Hour, SystemClock)
+
+		_, err := store.Begin("key", "hash")
+		r.NoError(err)
+		store.Release("key")
+
+		stored, err := store.Begin("key", "other-hash")
+		r.NoError(err)
+		r.Nil(stored)
+	})
+
+	t.Run("failed key cannot be claimed again", func(t *testing.T) {
+		r := require.New(t)
+		store := NewIdempotencyStore(time.Hour, SystemClock)
+
+		_, err := store.Begin("key", "hash")
+		r.NoError(err)
+		store.Fail("key")
+		store.Release("key")
+
+		_, err = store.Begin("key", "hash")
+		r.ErrorIs(err, errIdempotencyKeyFailed)
+	})
+
+	t.Run("key in progress expires after lease", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		store := NewIdempotencyStore(time.Hour, clock)
+
+		_, err := store.Begin("key", "hash")
+		r.NoError(err)
+
+		clock.Advance(idempotencyKeyLease)
+		_, err = store.Begin("key", "hash")
+		r.ErrorIs(err, errIdempotencyKeyInProgress)
+
+		clock.Advance(time.Second)
+		stored, err := store.Begin("key", "hash")
+		r.NoError(err)
+		r.Nil(stored, "a key left in progress should be claimable after the lease")
+	})
+
+	t.Run("key expires after retention window", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		store := NewIdempotencyStore(time.Hour, clock)
+
+		_, err := store.Begin("key", "hash")
+		r.NoError(err)
+		store.Complete("key", &models.MaskedPayment{ID: "some-id"})
+
+		clock.Advance(time.Hour + time.Second)
+		stored, err := store.Begin("key", "other-hash")
+		r.NoError(err)
+		r.Nil(stored, "expired key should be treated as new")
+	})
+}
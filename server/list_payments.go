@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var lastFourPattern = regexp.MustCompile(`^\d{4}$`)
+
+/*
+ListPaymentsHandler handles listing the merchant's payments, newest first unless sort is created_at. The query parameters
+filter the payments, and a page has up to limit payments. If there are more, the response has a
+next_cursor, which is passed as cursor to fetch the next page. Pages are stable: a payment created while
+paging does not shift the payments of later pages.
+*/
+func (s *Server) ListPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := parsePaymentQuery(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	query.MerchantID = MerchantIDFromContext(r.Context())
+
+	// Fetch one more payment than the page holds, to tell whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	payments, err := s.store.ListPayments(query)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to list payments", "error", err)
+		s.writeError(w, r, newError(http.StatusInternalServerError, CodeInternalError, "failed to list the payments"))
+		return
+	}
+
+	list := models.PaymentList{Payments: payments}
+	if len(payments) > limit {
+		list.Payments = payments[:limit]
+		last := list.Payments[limit-1]
+		list.NextCursor = encodeCursor(PaymentCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+/*
+parsePaymentQuery parses the query parameters of a list payments request into a PaymentQuery:
+  - status is a payment status, e.g. SUCCESS
+  - currency is an ISO 4217 currency code
+  - min_amount and max_amount are inclusive bounds in minor units
+  - created_from and created_to are RFC 3339 times, from inclusive and to exclusive
+  - last_four is the last four digits of the card number
+  - sort is -created_at for newest first, which is the default, or created_at for oldest first
+  - limit is the page size, from 1 to maxPageSize
+  - cursor is the next_cursor of the previous page
+
+Every invalid parameter is reported at once.
+*/
+func parsePaymentQuery(r *http.Request) (PaymentQuery, error) {
+	params := r.URL.Query()
+	query := PaymentQuery{Descending: true, Limit: defaultPageSize}
+	var errs validationErrors
+	invalid := func(param, format string, args ...any) {
+		errs = append(errs, models.FieldError{Code: CodeInvalidQuery, Field: param, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if status := params.Get("status"); status != "" {
+		query.Status = models.PaymentStatus(status)
+		if !query.Status.Valid() {
+			invalid("status", "status %s does not exist", status)
+		}
+	}
+
+	if currency := params.Get("currency"); currency != "" {
+		if _, exists := money.LookupCurrency(currency); !exists {
+			invalid("currency", "invalid currency code")
+		}
+		query.Currency = currency
+	}
+
+	parseAmount := func(param string) int64 {
+		value := params.Get(param)
+		if value == "" {
+			return 0
+		}
+		amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || amount <= 0 {
+			invalid(param, "%s must be a positive whole number of minor units", param)
+		}
+		return amount
+	}
+	query.MinAmount, query.MaxAmount = parseAmount("min_amount"), parseAmount("max_amount")
+	if query.MinAmount > 0 && query.MaxAmount > 0 && query.MinAmount > query.MaxAmount {
+		invalid("max_amount", "max_amount must not be less than min_amount")
+	}
+
+	parseTime := func(param string) time.Time {
+		value := params.Get(param)
+		if value == "" {
+			return time.Time{}
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			invalid(param, "%s must be an RFC 3339 time, like 2024-07-11T22:04:40Z", param)
+		}
+		return parsed
+	}
+	query.CreatedFrom, query.CreatedTo = parseTime("created_from"), parseTime("created_to")
+
+	if lastFour := params.Get("last_four"); lastFour != "" {
+		if !lastFourPattern.MatchString(lastFour) {
+			invalid("last_four", "last_four should have 4 digits")
+		}
+		query.LastFour = lastFour
+	}
+
+	switch sort := params.Get("sort"); sort {
+	case "", "-created_at":
+	case "created_at":
+		query.Descending = false
+	default:
+		invalid("sort", "sort must be created_at or -created_at")
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			invalid("limit", "limit must be from 1 to %d", maxPageSize)
+		}
+		query.Limit = parsed
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			invalid("cursor", "cursor is invalid")
+		}
+		query.After = &after
+	}
+
+	if len(errs) > 0 {
+		return PaymentQuery{}, errs
+	}
+	return query, nil
+}
+
+// encodeCursor encodes cursor as an opaque string for clients.
+func encodeCursor(cursor PaymentCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + cursor.ID))
+}
+
+// decodeCursor decodes a cursor encoded by encodeCursor.
+func decodeCursor(encoded string) (PaymentCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return PaymentCursor{}, err
+	}
+	createdAt, id, found := strings.Cut(string(decoded), "|")
+	if !found {
+		return PaymentCursor{}, fmt.Errorf("cursor has no payment ID")
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return PaymentCursor{}, err
+	}
+	return PaymentCursor{CreatedAt: parsed, ID: id}, nil
+}
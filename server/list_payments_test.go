@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// listPayments lists the payments of s with the given query parameters.
+func listPayments(t *testing.T, s *Server, params url.Values) models.PaymentList {
+	t.Helper()
+	response := serve(t, s, "GET", utils.Path+"?"+params.Encode(), nil)
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	list := models.PaymentList{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&list), "failed to unmarshal response")
+	return list
+}
+
+func TestListPaymentsHandler(t *testing.T) {
+	t.Parallel()
+
+	// Create payments a minute apart, alternating between GBP and EUR
+	clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestServerWithBank(t, &fakeBank{})
+	s.clock = clock
+
+	ids := []string{}
+	for i := 0; i < 5; i++ {
+		request := utils.ValidProcessPaymentRequest()
+		if i%2 == 1 {
+			request.Currency = "EUR"
+		}
+		response := serve(t, s, "POST", utils.Path, request)
+		require.Equal(t, http.StatusOK, response.Code)
+		ids = append(ids, decodePayment(t, response).ID)
+		clock.Advance(time.Minute)
+	}
+
+	t.Run("pages newest first by default", func(t *testing.T) {
+		r := require.New(t)
+
+		listed := []string{}
+		params := url.Values{"limit": {"2"}}
+		for pages := 1; ; pages++ {
+			list := listPayments(t, s, params)
+			for _, payment := range list.Payments {
+				listed = append(listed, payment.ID)
+			}
+			if list.NextCursor == "" {
+				r.Equal(3, pages)
+				break
+			}
+			params.Set("cursor", list.NextCursor)
+		}
+		r.Equal([]string{ids[4], ids[3], ids[2], ids[1], ids[0]}, listed)
+	})
+
+	t.Run("pages oldest first", func(t *testing.T) {
+		r := require.New(t)
+
+		list := listPayments(t, s, url.Values{"limit": {"3"}, "sort": {"created_at"}})
+		r.Len(list.Payments, 3)
+		r.Equal(ids[0], list.Payments[0].ID)
+		r.Equal(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), list.Payments[0].CreatedAt)
+
+		list = listPayments(t, s, url.Values{"limit": {"3"}, "sort": {"created_at"}, "cursor": {list.NextCursor}})
+		r.Len(list.Payments, 2)
+		r.Equal(ids[3], list.Payments[0].ID)
+		r.Equal(ids[4], list.Payments[1].ID)
+		r.Empty(list.NextCursor, "last page should not have a cursor")
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		r := require.New(t)
+
+		list := listPayments(t, s, url.Values{
+			"status":       {"SUCCESS"},
+			"currency":     {"EUR"},
+			"min_amount":   {"1005"},
+			"max_amount":   {"1005"},
+			"created_from": {"2024-07-01T12:00:00Z"},
+			"created_to":   {"2024-07-01T12:03:00Z"},
+			"last_four":    {"4242"},
+		})
+		r.Len(list.Payments, 1)
+		r.Equal(ids[1], list.Payments[0].ID)
+		r.Equal("************4242", list.Payments[0].MaskedCardNumber)
+
+		list = listPayments(t, s, url.Values{"last_four": {"0000"}})
+		r.Empty(list.Payments)
+		r.NotNil(list.Payments, "payments should be an empty list rather than null")
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		type testCase struct {
+			name          string
+			query         string
+			expectedField string
+		}
+
+		testCases := []testCase{
+			{"unknown status", "status=PENDING", "status"},
+			{"unknown currency", "currency=XYZ", "currency"},
+			{"negative amount", "min_amount=-1", "min_amount"},
+			{"decimal amount", "max_amount=10.05", "max_amount"},
+			{"amount range reversed", "min_amount=200&max_amount=100", "max_amount"},
+			{"time not RFC 3339", "created_from=2024-07-01", "created_from"},
+			{"last four too short", "last_four=424", "last_four"},
+			{"unknown sort", "sort=amount", "sort"},
+			{"limit too large", "limit=101", "limit"},
+			{"limit zero", "limit=0", "limit"},
+			{"cursor not base64", "cursor=!!!", "cursor"},
+			{"cursor without ID", "cursor=" + url.QueryEscape("MjAyNC0wNy0wMVQxMjowMDowMFo"), "cursor"},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := require.New(t)
+				response := serve(t, s, "GET", utils.Path+"?"+tc.query, nil)
+				r.Equal(http.StatusBadRequest, response.Code)
+
+				errorResponse := decodeError(t, response)
+				r.Equal(CodeValidationFailed, errorResponse.Code)
+				r.Equal(tc.expectedField, errorResponse.Field)
+				r.Equal(CodeInvalidQuery, errorResponse.Errors[0].Code)
+			})
+		}
+	})
+}
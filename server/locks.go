@@ -0,0 +1,43 @@
+package server
+
+import "sync"
+
+// keyedMutex provides a mutex per key, so that concurrent changes to the same payment are serialised
+// without blocking changes to other payments.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu      sync.Mutex
+	holders int // number of callers holding or waiting for mu
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock locks the mutex for key and returns a function that unlocks it.
+func (m *keyedMutex) Lock(key string) (unlock func()) {
+	m.mu.Lock()
+	lock, exists := m.locks[key]
+	if !exists {
+		lock = &keyedLock{}
+		m.locks[key] = lock
+	}
+	lock.holders++
+	m.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		lock.holders--
+		if lock.holders == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+)
+
+// contextHandler is a slog.Handler that adds what is known about the request that a record is logged for,
+// from the context the record is logged with: its request ID, route, merchant ID and payment ID.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		for _, attr := range []slog.Attr{
+			slog.String("route", info.route),
+			slog.String("merchant_id", info.merchantID),
+			slog.String("payment_id", info.paymentID),
+		} {
+			if attr.Value.String() != "" {
+				record.AddAttrs(attr)
+			}
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
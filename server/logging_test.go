@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// newLoggedTestServer is newTestServerWithBank with a logger that writes JSON logs to the returned buffer,
+// without any redaction of its own.
+func newLoggedTestServer(t *testing.T, acquirer bank.Acquirer) (*Server, *bytes.Buffer) {
+	t.Helper()
+	store := newTestStore()
+	logs := &bytes.Buffer{}
+	return New(Config{
+		Store:     store,
+		Merchants: store,
+		Bank:      acquirer,
+		APIKeys:   newTestAPIKeys(),
+		Logger:    slog.New(slog.NewJSONHandler(logs, nil)),
+	}), logs
+}
+
+// decodeLogs decodes every JSON log line in logs.
+func decodeLogs(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(logs.Bytes()))
+	for scanner.Scan() {
+		record := map[string]any{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), "failed to unmarshal log line %s", scanner.Text())
+		records = append(records, record)
+	}
+	return records
+}
+
+// findLog returns the first log record with message msg.
+func findLog(t *testing.T, records []map[string]any, msg string) map[string]any {
+	t.Helper()
+	for _, record := range records {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	require.Failf(t, "log not found", "no log line with message %q in %v", msg, records)
+	return nil
+}
+
+func TestRequestLogs(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	s, logs := newLoggedTestServer(t, &fakeBank{})
+	payment := decodePayment(t, serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest()))
+	records := decodeLogs(t, logs)
+
+	processed := findLog(t, records, "Processed payment")
+	handled := findLog(t, records, "Handled request")
+	requestID := handled["request_id"]
+	r.NotEmpty(requestID)
+	for _, record := range []map[string]any{processed, handled} {
+		r.Equal(requestID, record["request_id"])
+		r.Equal(testMerchantID, record["merchant_id"])
+		r.Equal(payment.ID, record["payment_id"])
+		r.Equal(utils.Path, record["route"])
+	}
+	r.Equal("SUCCESS", processed["status"])
+	r.Equal("INFO", handled["level"])
+	r.Equal("POST", handled["method"])
+	r.Equal(float64(http.StatusOK), handled["status"])
+	r.Contains(handled, "latency_ms")
+
+	logs.Reset()
+	serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+	handled = findLog(t, decodeLogs(t, logs), "Handled request")
+	r.Equal(utils.Path+"/{id}", handled["route"])
+	r.Equal(payment.ID, handled["payment_id"])
+	r.NotEqual(requestID, handled["request_id"])
+
+	logs.Reset()
+	serveWithKey(t, s, "", "GET", "/unknown", nil)
+	handled = findLog(t, decodeLogs(t, logs), "Handled request")
+	r.Equal(unmatchedRoute, handled["route"])
+	r.Equal(float64(http.StatusNotFound), handled["status"])
+	r.NotContains(handled, "merchant_id")
+	r.NotContains(handled, "payment_id")
+
+	logs.Reset()
+	serve(t, s, "GET", HealthzPath, nil)
+	r.Empty(logs.String(), "probes should not be logged")
+}
+
+func TestLogsAreRedacted(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	request := utils.ValidProcessPaymentRequest()
+	request.CVV = "987"
+	// The bank echoes the card details in its error, which a careless adapter might pass on
+	s, logs := newLoggedTestServer(t, &brokenBank{err: errors.New(
+		`bank rejected {"card_number":"4242424242424242","cvv":"987"} or 4242 4242 4242 4242`,
+	)})
+	response := serve(t, s, "POST", utils.Path, request)
+	r.Equal(http.StatusBadGateway, response.Code)
+
+	// A future change that logs the raw request must not leak it either
+	s.logger.Info("Received request", "request", request)
+
+	output := logs.String()
+	r.NotContains(output, request.CardNumber)
+	r.NotContains(output, "4242 4242 4242 4242")
+	r.NotContains(output, `"987"`)
+
+	records := decodeLogs(t, logs)
+	failed := findLog(t, records, "Failed to make payment with the bank")
+	r.Equal(`bank rejected {"card_number":"************4242","cvv":"***"} or **** **** **** 4242`, failed["error"])
+	handled := findLog(t, records, "Handled request")
+	r.Equal("ERROR", handled["level"])
+	logged := findLog(t, records, "Received request")["request"].(map[string]any)
+	r.Equal("[REDACTED]", logged["card_number"])
+	r.Equal("[REDACTED]", logged["cvv"])
+	r.Equal(request.Currency, logged["currency"])
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+// ErrMerchantNotFound is returned by a MerchantStore when no merchant has the requested ID.
+var ErrMerchantNotFound = errors.New("merchant not found")
+
+// MerchantStore stores merchants and the hashes of the API keys issued to them. MemoryStore and FileStore
+// are both MerchantStores as well as PaymentStores. Implementations must be safe for concurrent use.
+type MerchantStore interface {
+	// AddMerchant stores merchant, replacing any existing merchant with the same ID.
+	AddMerchant(merchant *models.Merchant) error
+	// GetMerchant returns the merchant with the given ID, or ErrMerchantNotFound.
+	GetMerchant(id string) (*models.Merchant, error)
+	// ListMerchants returns every merchant, ordered by ID.
+	ListMerchants() ([]*models.Merchant, error)
+	// DeleteMerchant deletes the merchant with the given ID and its API keys, or returns ErrMerchantNotFound.
+	DeleteMerchant(id string) error
+	// AddAPIKey stores key, replacing any existing key with the same hash.
+	AddAPIKey(key *models.APIKey) error
+	// ListAPIKeys returns every API key, ordered by merchant ID and then oldest first.
+	ListAPIKeys() ([]*models.APIKey, error)
+}
+
+// AddMerchant stores a copy of merchant.
+func (s *MemoryStore) AddMerchant(merchant *models.Merchant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.merchants[merchant.ID] = copyMerchant(merchant)
+	return nil
+}
+
+// GetMerchant returns a copy of the merchant with the given ID, or ErrMerchantNotFound.
+func (s *MemoryStore) GetMerchant(id string) (*models.Merchant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merchant, exists := s.merchants[id]
+	if !exists {
+		return nil, ErrMerchantNotFound
+	}
+	return copyMerchant(merchant), nil
+}
+
+// ListMerchants returns copies of every merchant, ordered by ID.
+func (s *MemoryStore) ListMerchants() ([]*models.Merchant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merchants := make([]*models.Merchant, 0, len(s.merchants))
+	for _, merchant := range s.merchants {
+		merchants = append(merchants, copyMerchant(merchant))
+	}
+	sort.Slice(merchants, func(i, j int) bool { return merchants[i].ID < merchants[j].ID })
+	return merchants, nil
+}
+
+// DeleteMerchant deletes the merchant with the given ID, or returns ErrMerchantNotFound.
+func (s *MemoryStore) DeleteMerchant(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.merchants[id]; !exists {
+		return ErrMerchantNotFound
+	}
+	delete(s.merchants, id)
+	for hash, key := range s.apiKeys {
+		if key.MerchantID == id {
+			delete(s.apiKeys, hash)
+		}
+	}
+	return nil
+}
+
+// AddAPIKey stores a copy of key.
+func (s *MemoryStore) AddAPIKey(key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKeys[key.Hash] = copyAPIKey(key)
+	return nil
+}
+
+// ListAPIKeys returns copies of every API key, ordered by merchant ID and then oldest first.
+func (s *MemoryStore) ListAPIKeys() ([]*models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]*models.APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].MerchantID != keys[j].MerchantID {
+			return keys[i].MerchantID < keys[j].MerchantID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// copyAPIKey returns a copy of key that does not share its expiry.
+func copyAPIKey(key *models.APIKey) *models.APIKey {
+	copied := *key
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		copied.ExpiresAt = &expiresAt
+	}
+	return &copied
+}
+
+// copyMerchant returns a copy of merchant that shares none of its slices or maps.
+func copyMerchant(merchant *models.Merchant) *models.Merchant {
+	copied := *merchant
+	copied.AllowedCurrencies = slices.Clone(merchant.AllowedCurrencies)
+	copied.Limits = maps.Clone(merchant.Limits)
+	return &copied
+}
+
+// AddMerchant appends merchant to the log and then stores it in the index.
+func (s *FileStore) AddMerchant(merchant *models.Merchant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypeMerchant, Merchant: merchant}); err != nil {
+		return err
+	}
+	return s.index.AddMerchant(merchant)
+}
+
+// GetMerchant returns the merchant with the given ID, or ErrMerchantNotFound.
+func (s *FileStore) GetMerchant(id string) (*models.Merchant, error) {
+	return s.index.GetMerchant(id)
+}
+
+// ListMerchants returns every merchant, ordered by ID.
+func (s *FileStore) ListMerchants() ([]*models.Merchant, error) {
+	return s.index.ListMerchants()
+}
+
+// DeleteMerchant appends a deletion of the merchant with the given ID to the log, and then deletes it and
+// its API keys from the index.
+func (s *FileStore) DeleteMerchant(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.index.GetMerchant(id); err != nil {
+		return err
+	}
+	if err := s.append(logRecord{Type: recordTypeMerchantDeleted, Merchant: &models.Merchant{ID: id}}); err != nil {
+		return err
+	}
+	return s.index.DeleteMerchant(id)
+}
+
+// AddAPIKey appends key to the log and then stores it in the index.
+func (s *FileStore) AddAPIKey(key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypeAPIKey, APIKey: key}); err != nil {
+		return err
+	}
+	return s.index.AddAPIKey(key)
+}
+
+// ListAPIKeys returns every API key, ordered by merchant ID and then oldest first.
+func (s *FileStore) ListAPIKeys() ([]*models.APIKey, error) {
+	return s.index.ListAPIKeys()
+}
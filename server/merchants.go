@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/gorilla/mux"
+)
+
+// AdminMerchantsPath is the path of the admin endpoints for managing merchants.
+const AdminMerchantsPath = "/admin/merchants"
+
+// maxGracePeriodSeconds is the longest that a merchant's old API keys can keep working after a rotation.
+const maxGracePeriodSeconds = 30 * 24 * 60 * 60
+
+// merchantIDPattern is the format of merchant IDs, which appear in API key files and logs.
+var merchantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// CreateMerchantHandler handles creating a merchant, which is issued its first API key. A merchant whose ID
+// is taken returns a http 409 error response.
+func (s *Server) CreateMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	request := models.MerchantRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, r, readError(err, "failed to unmarshal the request"))
+		return
+	}
+	if request.Status == "" {
+		request.Status = models.MerchantActive
+	}
+	errs := validateMerchantRequest(request)
+	if !merchantIDPattern.MatchString(request.ID) {
+		errs = append(errs, models.FieldError{
+			Code:    CodeInvalidMerchantID,
+			Field:   "id",
+			Message: "id should have up to 64 lower case letters, digits, _ or -, and start with a letter or digit",
+		})
+	}
+	if len(errs) > 0 {
+		s.writeError(w, r, errs)
+		return
+	}
+
+	unlock := s.merchantLocks.Lock(request.ID)
+	defer unlock()
+
+	_, err := s.merchants.GetMerchant(request.ID)
+	if err == nil {
+		s.writeError(w, r, newError(http.StatusConflict, CodeMerchantExists, fmt.Sprintf("merchant %s already exists", request.ID)))
+		return
+	}
+	if !errors.Is(err, ErrMerchantNotFound) {
+		s.writeError(w, r, fmt.Errorf("failed to fetch merchant %s: %w", request.ID, err))
+		return
+	}
+
+	now := s.clock.Now().UTC()
+	merchant := &models.Merchant{ID: request.ID, CreatedAt: now}
+	applyMerchantRequest(merchant, request, now)
+	if err := s.merchants.AddMerchant(merchant); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to store merchant %s: %w", merchant.ID, err))
+		return
+	}
+	s.logger.InfoContext(r.Context(), "Created merchant", "merchant_id", merchant.ID)
+
+	key, err := s.issueAPIKey(r.Context(), merchant.ID, nil)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreatedMerchant{Merchant: merchant, APIKey: key})
+}
+
+// ListMerchantsHandler handles listing every merchant, ordered by ID.
+func (s *Server) ListMerchantsHandler(w http.ResponseWriter, r *http.Request) {
+	merchants, err := s.merchants.ListMerchants()
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to list merchants: %w", err))
+		return
+	}
+	json.NewEncoder(w).Encode(merchants)
+}
+
+// GetMerchantHandler handles fetching a merchant by ID.
+func (s *Server) GetMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	merchant, ok := s.fetchMerchantByID(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// UpdateMerchantHandler handles replacing the details of a merchant. Its ID cannot be changed.
+func (s *Server) UpdateMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	request := models.MerchantRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, r, readError(err, "failed to unmarshal the request"))
+		return
+	}
+	errs := validateMerchantRequest(request)
+	if request.ID != "" && request.ID != id {
+		errs = append(errs, models.FieldError{Code: CodeInvalidMerchantID, Field: "id", Message: "id cannot be changed"})
+	}
+	if len(errs) > 0 {
+		s.writeError(w, r, errs)
+		return
+	}
+
+	unlock := s.merchantLocks.Lock(id)
+	defer unlock()
+
+	merchant, ok := s.fetchMerchantByID(w, r, id)
+	if !ok {
+		return
+	}
+	applyMerchantRequest(merchant, request, s.clock.Now().UTC())
+	if err := s.merchants.AddMerchant(merchant); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to store merchant %s: %w", merchant.ID, err))
+		return
+	}
+	s.logger.InfoContext(r.Context(), "Updated merchant", "merchant_id", merchant.ID, "status", merchant.Status)
+
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// DeleteMerchantHandler handles deleting a merchant. Its payments are kept, but its API keys no longer
+// authenticate.
+func (s *Server) DeleteMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	unlock := s.merchantLocks.Lock(id)
+	defer unlock()
+
+	err := s.merchants.DeleteMerchant(id)
+	if errors.Is(err, ErrMerchantNotFound) {
+		s.writeError(w, r, newError(http.StatusNotFound, CodeMerchantNotFound, "merchant not found"))
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to delete merchant %s: %w", id, err))
+		return
+	}
+	s.apiKeys.revokeKeys(id)
+	s.logger.InfoContext(r.Context(), "Deleted merchant", "merchant_id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+IssueMerchantKeyHandler handles issuing a merchant a new API key, which is only ever returned in the
+response. The merchant's other keys keep working, unless the request has a grace_period_seconds: then the
+new key rotates them, and they stop working once the grace period has passed.
+*/
+func (s *Server) IssueMerchantKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	request := models.IssueAPIKeyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, r, readError(err, "failed to unmarshal the request"))
+		return
+	}
+	var gracePeriod *time.Duration
+	if request.GracePeriodSeconds != nil {
+		if *request.GracePeriodSeconds < 0 || *request.GracePeriodSeconds > maxGracePeriodSeconds {
+			s.writeError(w, r, models.FieldError{
+				Code:    CodeInvalidGracePeriod,
+				Field:   "grace_period_seconds",
+				Message: fmt.Sprintf("grace_period_seconds should be between 0 and %d", maxGracePeriodSeconds),
+			})
+			return
+		}
+		period := time.Duration(*request.GracePeriodSeconds) * time.Second
+		gracePeriod = &period
+	}
+
+	unlock := s.merchantLocks.Lock(id)
+	defer unlock()
+
+	if _, ok := s.fetchMerchantByID(w, r, id); !ok {
+		return
+	}
+	key, err := s.issueAPIKey(r.Context(), id, gracePeriod)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.IssuedAPIKey{MerchantID: id, APIKey: key, CreatedAt: s.clock.Now().UTC()})
+}
+
+// issueAPIKey issues the merchant with the given ID a new API key, rotating its other keys after gracePeriod
+// if it is not nil, and then stores the merchant's keys and their expiries so that they survive restarts.
+// It must be called with the merchant's lock held.
+func (s *Server) issueAPIKey(ctx context.Context, merchantID string, gracePeriod *time.Duration) (string, error) {
+	var (
+		key string
+		err error
+	)
+	if gracePeriod != nil {
+		key, err = s.apiKeys.RotateKey(merchantID, *gracePeriod)
+	} else {
+		key, err = s.apiKeys.IssueKey(merchantID)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, stored := range s.apiKeys.storedKeys(merchantID) {
+		if err := s.merchants.AddAPIKey(stored); err != nil {
+			return "", fmt.Errorf("failed to store API key of merchant %s: %w", merchantID, err)
+		}
+	}
+	if gracePeriod != nil {
+		s.logger.InfoContext(ctx, "Rotated API keys", "merchant_id", merchantID, "grace_period", gracePeriod.String())
+	} else {
+		s.logger.InfoContext(ctx, "Issued API key", "merchant_id", merchantID)
+	}
+	return key, nil
+}
+
+// fetchMerchant fetches the merchant that authenticated r. If it cannot, it writes an error response to r
+// and returns false.
+func (s *Server) fetchMerchant(w http.ResponseWriter, r *http.Request) (*models.Merchant, bool) {
+	return s.fetchMerchantByID(w, r, MerchantIDFromContext(r.Context()))
+}
+
+// fetchMerchantByID fetches the merchant with the given ID from the store. If it cannot, it writes an error
+// response to r and returns false.
+func (s *Server) fetchMerchantByID(w http.ResponseWriter, r *http.Request, id string) (*models.Merchant, bool) {
+	merchant, err := s.merchants.GetMerchant(id)
+	if errors.Is(err, ErrMerchantNotFound) {
+		s.writeError(w, r, newError(http.StatusNotFound, CodeMerchantNotFound, "merchant not found"))
+		return nil, false
+	}
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to fetch merchant %s: %w", id, err))
+		return nil, false
+	}
+	return merchant, true
+}
+
+// applyMerchantRequest sets the details of merchant from request, as of now.
+func applyMerchantRequest(merchant *models.Merchant, request models.MerchantRequest, now time.Time) {
+	merchant.Name = request.Name
+	if request.Status != "" {
+		merchant.Status = request.Status
+	}
+	merchant.DefaultCurrency = request.DefaultCurrency
+	merchant.AllowedCurrencies = request.AllowedCurrencies
+	merchant.Limits = request.Limits
+	merchant.UpdatedAt = now
+}
+
+/*
+validateMerchantRequest checks the details of a merchant, but not its ID, and returns every invalid field:
+  - Name must not be empty
+  - Status, if set, must be ACTIVE or SUSPENDED
+  - DefaultCurrency must be an ISO 4217 currency code, and one of AllowedCurrencies if there are any
+  - AllowedCurrencies and the currencies of Limits must be ISO 4217 currency codes
+  - Limits must be positive, with the minimum no more than the maximum
+*/
+func validateMerchantRequest(request models.MerchantRequest) validationErrors {
+	var errs validationErrors
+	invalid := func(code, field, format string, args ...any) {
+		errs = append(errs, models.FieldError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if request.Name == "" {
+		invalid(CodeInvalidMerchantName, "name", "name is required")
+	}
+	switch request.Status {
+	case "", models.MerchantActive, models.MerchantSuspended:
+	default:
+		invalid(CodeInvalidMerchantStatus, "status", "status should be %s or %s", models.MerchantActive, models.MerchantSuspended)
+	}
+
+	if _, exists := money.LookupCurrency(request.DefaultCurrency); !exists {
+		invalid(CodeInvalidCurrency, "default_currency", "invalid currency code")
+	} else if len(request.AllowedCurrencies) > 0 && !slices.Contains(request.AllowedCurrencies, request.DefaultCurrency) {
+		invalid(CodeInvalidCurrency, "default_currency", "default currency should be one of the allowed currencies")
+	}
+	for _, currency := range request.AllowedCurrencies {
+		if _, exists := money.LookupCurrency(currency); !exists {
+			invalid(CodeInvalidCurrency, "allowed_currencies", "invalid currency code %q", currency)
+		}
+	}
+
+	currencies := make([]string, 0, len(request.Limits))
+	for currency := range request.Limits {
+		currencies = append(currencies, currency)
+	}
+	slices.Sort(currencies)
+	for _, currency := range currencies {
+		limit, field := request.Limits[currency], "limits."+currency
+		if _, exists := money.LookupCurrency(currency); !exists {
+			invalid(CodeInvalidCurrency, field, "invalid currency code %q", currency)
+		}
+		if limit.MinAmount < 0 || limit.MaxAmount < 0 {
+			invalid(CodeInvalidAmount, field, "limits should be positive")
+		} else if limit.MaxAmount > 0 && limit.MinAmount > limit.MaxAmount {
+			invalid(CodeInvalidAmount, field, "min_amount should not be more than max_amount")
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminKey = "sk_admin"
+
+// newTestAdminServer returns a test server with admin endpoints, which accept testAdminKey.
+func newTestAdminServer(t *testing.T) *Server {
+	t.Helper()
+	s := newTestServerWithBank(t, &fakeBank{})
+	s.adminKeys = NewAPIKeyStore(SystemClock)
+	s.adminKeys.AddHashedKey("admin", HashAPIKey(testAdminKey))
+	return s
+}
+
+// decodeMerchant decodes the Merchant in the body of response.
+func decodeMerchant(t *testing.T, response *httptest.ResponseRecorder) models.Merchant {
+	t.Helper()
+	merchant := models.Merchant{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&merchant), "failed to unmarshal response")
+	return merchant
+}
+
+func TestMerchantHandlers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("create, fetch, update, list and delete", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		s := newTestAdminServer(t)
+		s.clock = clock
+
+		request := models.MerchantRequest{
+			ID:                "acme",
+			Name:              "Acme",
+			DefaultCurrency:   "GBP",
+			AllowedCurrencies: []string{"GBP", "EUR"},
+			Limits:            map[string]models.TransactionLimit{"GBP": {MaxAmount: 100000}},
+		}
+		response := serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath, request)
+		r.Equal(http.StatusCreated, response.Code)
+		created := decodeMerchant(t, response)
+		r.Equal(models.Merchant{
+			ID:                "acme",
+			Name:              "Acme",
+			Status:            models.MerchantActive,
+			DefaultCurrency:   "GBP",
+			AllowedCurrencies: []string{"GBP", "EUR"},
+			Limits:            map[string]models.TransactionLimit{"GBP": {MaxAmount: 100000}},
+			CreatedAt:         clock.Now(),
+			UpdatedAt:         clock.Now(),
+		}, created)
+
+		response = serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath, request)
+		r.Equal(http.StatusConflict, response.Code)
+		r.Equal(CodeMerchantExists, decodeError(t, response).Code)
+
+		response = serveWithKey(t, s, testAdminKey, "GET", AdminMerchantsPath+"/acme", nil)
+		r.Equal(http.StatusOK, response.Code)
+		r.Equal(created, decodeMerchant(t, response))
+
+		clock.Advance(time.Hour)
+		request.ID, request.Status = "", models.MerchantSuspended
+		response = serveWithKey(t, s, testAdminKey, "PUT", AdminMerchantsPath+"/acme", request)
+		r.Equal(http.StatusOK, response.Code)
+		updated := decodeMerchant(t, response)
+		r.Equal(models.MerchantSuspended, updated.Status)
+		r.Equal(created.CreatedAt, updated.CreatedAt)
+		r.Equal(clock.Now(), updated.UpdatedAt)
+
+		response = serveWithKey(t, s, testAdminKey, "GET", AdminMerchantsPath, nil)
+		r.Equal(http.StatusOK, response.Code)
+		merchants := []models.Merchant{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&merchants))
+		r.Len(merchants, 2)
+		r.Equal("acme", merchants[0].ID)
+		r.Equal(testMerchantID, merchants[1].ID)
+
+		response = serveWithKey(t, s, testAdminKey, "DELETE", AdminMerchantsPath+"/acme", nil)
+		r.Equal(http.StatusNoContent, response.Code)
+		response = serveWithKey(t, s, testAdminKey, "GET", AdminMerchantsPath+"/acme", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+		r.Equal(CodeMerchantNotFound, decodeError(t, response).Code)
+		response = serveWithKey(t, s, testAdminKey, "DELETE", AdminMerchantsPath+"/acme", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+	})
+
+	t.Run("invalid merchants are rejected", func(t *testing.T) {
+		type testCase struct {
+			name           string
+			request        models.MerchantRequest
+			expectedErrors []models.FieldError
+		}
+
+		testCases := []testCase{
+			{
+				"invalid ID",
+				models.MerchantRequest{ID: "Acme Ltd", Name: "Acme", DefaultCurrency: "GBP"},
+				[]models.FieldError{{
+					Code:    CodeInvalidMerchantID,
+					Message: "id should have up to 64 lower case letters, digits, _ or -, and start with a letter or digit",
+					Field:   "id",
+				}},
+			}, {
+				"missing name and unknown status",
+				models.MerchantRequest{ID: "acme", Status: "CLOSED", DefaultCurrency: "GBP"},
+				[]models.FieldError{
+					{Code: CodeInvalidMerchantName, Message: "name is required", Field: "name"},
+					{Code: CodeInvalidMerchantStatus, Message: "status should be ACTIVE or SUSPENDED", Field: "status"},
+				},
+			}, {
+				"default currency not allowed",
+				models.MerchantRequest{ID: "acme", Name: "Acme", DefaultCurrency: "GBP", AllowedCurrencies: []string{"EUR", "XYZ"}},
+				[]models.FieldError{
+					{Code: CodeInvalidCurrency, Message: "default currency should be one of the allowed currencies", Field: "default_currency"},
+					{Code: CodeInvalidCurrency, Message: `invalid currency code "XYZ"`, Field: "allowed_currencies"},
+				},
+			}, {
+				"invalid limits",
+				models.MerchantRequest{ID: "acme", Name: "Acme", DefaultCurrency: "GBP", Limits: map[string]models.TransactionLimit{
+					"GBP": {MinAmount: 500, MaxAmount: 100},
+					"EUR": {MinAmount: -1},
+				}},
+				[]models.FieldError{
+					{Code: CodeInvalidAmount, Message: "limits should be positive", Field: "limits.EUR"},
+					{Code: CodeInvalidAmount, Message: "min_amount should not be more than max_amount", Field: "limits.GBP"},
+				},
+			},
+		}
+
+		s := newTestAdminServer(t)
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := require.New(t)
+				response := serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath, tc.request)
+				r.Equal(http.StatusBadRequest, response.Code)
+				errorResponse := decodeError(t, response)
+				r.Equal(CodeValidationFailed, errorResponse.Code)
+				r.Equal(tc.expectedErrors, errorResponse.Errors)
+			})
+		}
+	})
+
+	t.Run("issue and rotate API keys", func(t *testing.T) {
+		r := require.New(t)
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		s := newTestAdminServer(t)
+		s.clock, s.apiKeys.clock = clock, clock
+
+		response := serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath, models.MerchantRequest{ID: "acme", Name: "Acme", DefaultCurrency: "GBP"})
+		r.Equal(http.StatusCreated, response.Code)
+		created := models.CreatedMerchant{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&created))
+		r.Equal("acme", created.ID)
+		firstKey := created.APIKey
+		r.Equal(http.StatusOK, serveWithKey(t, s, firstKey, "GET", utils.Path, nil).Code, "first key should authenticate")
+
+		response = serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath+"/acme/keys", nil)
+		r.Equal(http.StatusCreated, response.Code)
+		issued := models.IssuedAPIKey{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&issued))
+		r.Equal(models.IssuedAPIKey{MerchantID: "acme", APIKey: issued.APIKey, CreatedAt: clock.Now()}, issued)
+		secondKey := issued.APIKey
+		r.NotEqual(firstKey, secondKey)
+		r.Equal(http.StatusOK, serveWithKey(t, s, firstKey, "GET", utils.Path, nil).Code, "issuing a key should not rotate the others")
+		r.Equal(http.StatusOK, serveWithKey(t, s, secondKey, "GET", utils.Path, nil).Code)
+
+		gracePeriod := int64(3600)
+		response = serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath+"/acme/keys", models.IssueAPIKeyRequest{GracePeriodSeconds: &gracePeriod})
+		r.Equal(http.StatusCreated, response.Code)
+		r.NoError(json.NewDecoder(response.Body).Decode(&issued))
+		rotatedKey := issued.APIKey
+
+		clock.Advance(time.Hour - time.Second)
+		r.Equal(http.StatusOK, serveWithKey(t, s, firstKey, "GET", utils.Path, nil).Code, "old keys should work during the grace period")
+		clock.Advance(time.Second)
+		r.Equal(http.StatusUnauthorized, serveWithKey(t, s, firstKey, "GET", utils.Path, nil).Code, "old keys should expire after the grace period")
+		r.Equal(http.StatusUnauthorized, serveWithKey(t, s, secondKey, "GET", utils.Path, nil).Code)
+		r.Equal(http.StatusOK, serveWithKey(t, s, rotatedKey, "GET", utils.Path, nil).Code)
+
+		stored, err := s.merchants.ListAPIKeys()
+		r.NoError(err)
+		r.Len(stored, 3, "issued keys should be stored")
+		for _, key := range stored {
+			r.Equal("acme", key.MerchantID)
+			r.NotEqual(rotatedKey, key.Hash, "keys should be stored hashed")
+		}
+
+		response = serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath+"/unknown/keys", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+		r.Equal(CodeMerchantNotFound, decodeError(t, response).Code)
+
+		gracePeriod = -1
+		response = serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath+"/acme/keys", models.IssueAPIKeyRequest{GracePeriodSeconds: &gracePeriod})
+		r.Equal(http.StatusBadRequest, response.Code)
+		r.Equal([]models.FieldError{{
+			Code:    CodeInvalidGracePeriod,
+			Message: "grace_period_seconds should be between 0 and 2592000",
+			Field:   "grace_period_seconds",
+		}}, decodeError(t, response).Errors)
+
+		r.Equal(http.StatusNoContent, serveWithKey(t, s, testAdminKey, "DELETE", AdminMerchantsPath+"/acme", nil).Code)
+		response = serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath, models.MerchantRequest{ID: "acme", Name: "Acme", DefaultCurrency: "GBP"})
+		r.Equal(http.StatusCreated, response.Code)
+		r.Equal(http.StatusUnauthorized, serveWithKey(t, s, rotatedKey, "GET", utils.Path, nil).Code, "keys of a deleted merchant should not come back with a new merchant of the same ID")
+	})
+
+	t.Run("admin endpoints need an admin key", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestAdminServer(t)
+
+		response := serve(t, s, "GET", AdminMerchantsPath, nil)
+		r.Equal(http.StatusUnauthorized, response.Code, "merchant keys should not be admin keys")
+		r.Equal(CodeUnauthorized, decodeError(t, response).Code)
+
+		response = serveWithKey(t, newTestServer(t), testAdminKey, "GET", AdminMerchantsPath, nil)
+		r.Equal(http.StatusNotFound, response.Code, "there should be no admin endpoints without admin keys")
+	})
+}
+
+func TestAPIKeyRotationSurvivesRestart(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "payments.log")
+	clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+
+	// start starts a server on the file store at path, with testAPIKey read from a keys file like cmd/server
+	start := func() (*Server, *FileStore) {
+		store, err := OpenFileStore(path)
+		r.NoError(err)
+		keys := NewAPIKeyStore(clock)
+		keys.AddHashedKey(testMerchantID, HashAPIKey(testAPIKey))
+		r.NoError(keys.LoadStored(store))
+		adminKeys := NewAPIKeyStore(clock)
+		adminKeys.AddHashedKey("admin", HashAPIKey(testAdminKey))
+		return New(Config{
+			Store:     store,
+			Merchants: store,
+			Bank:      &fakeBank{},
+			APIKeys:   keys,
+			AdminKeys: adminKeys,
+			Clock:     clock,
+			Logger:    slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		}), store
+	}
+
+	s, store := start()
+	r.NoError(store.AddMerchant(newTestMerchant(testMerchantID)))
+	gracePeriod := int64(3600)
+	response := serveWithKey(t, s, testAdminKey, "POST", AdminMerchantsPath+"/"+testMerchantID+"/keys", models.IssueAPIKeyRequest{GracePeriodSeconds: &gracePeriod})
+	r.Equal(http.StatusCreated, response.Code)
+	issued := models.IssuedAPIKey{}
+	r.NoError(json.NewDecoder(response.Body).Decode(&issued))
+	r.NoError(store.Close())
+
+	s, store = start()
+	r.Equal(http.StatusOK, serve(t, s, "GET", utils.Path, nil).Code, "key from the file should work during the grace period")
+	r.NoError(store.Close())
+
+	clock.Advance(time.Hour)
+	s, store = start()
+	defer store.Close()
+	r.Equal(http.StatusUnauthorized, serve(t, s, "GET", utils.Path, nil).Code, "rotated key from the file should stay expired after a restart")
+	r.Equal(http.StatusOK, serveWithKey(t, s, issued.APIKey, "GET", utils.Path, nil).Code, "issued key should survive a restart")
+}
+
+func TestSuspendedMerchant(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	s := newTestServerWithBank(t, &fakeBank{})
+
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code)
+	payment := decodePayment(t, response)
+
+	merchant := newTestMerchant(testMerchantID)
+	merchant.Status = models.MerchantSuspended
+	r.NoError(s.merchants.AddMerchant(merchant))
+
+	response = serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusForbidden, response.Code)
+	r.Equal(CodeMerchantSuspended, decodeError(t, response).Code)
+
+	response = serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+	r.Equal(http.StatusOK, response.Code, "suspended merchant should still fetch its payments")
+}
+
+func TestMerchantStores(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "payments.log")
+
+	store, err := OpenFileStore(path)
+	r.NoError(err)
+	_, err = store.GetMerchant("first")
+	r.ErrorIs(err, ErrMerchantNotFound)
+
+	first := &models.Merchant{ID: "first", Name: "First", Status: models.MerchantActive, AllowedCurrencies: []string{"GBP"}}
+	r.NoError(store.AddMerchant(first))
+	r.NoError(store.AddMerchant(&models.Merchant{ID: "second", Name: "Second", Status: models.MerchantActive}))
+	expiresAt := time.Date(2024, 7, 2, 12, 0, 0, 0, time.UTC)
+	firstKey := &models.APIKey{MerchantID: "first", Hash: HashAPIKey("sk_first"), CreatedAt: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), ExpiresAt: &expiresAt}
+	r.NoError(store.AddAPIKey(firstKey))
+	r.NoError(store.AddAPIKey(&models.APIKey{MerchantID: "second", Hash: HashAPIKey("sk_second")}))
+	r.NoError(store.DeleteMerchant("second"))
+	r.ErrorIs(store.DeleteMerchant("second"), ErrMerchantNotFound)
+
+	// Changes to the caller's copy should not leak into the store
+	first.AllowedCurrencies[0] = "EUR"
+	fetched, err := store.GetMerchant("first")
+	r.NoError(err)
+	r.Equal([]string{"GBP"}, fetched.AllowedCurrencies)
+	r.NoError(store.Close())
+
+	reopened, err := OpenFileStore(path)
+	r.NoError(err)
+	defer reopened.Close()
+
+	merchants, err := reopened.ListMerchants()
+	r.NoError(err)
+	r.Equal([]*models.Merchant{fetched}, merchants, "deleted merchant should stay deleted after reopening")
+	keys, err := reopened.ListAPIKeys()
+	r.NoError(err)
+	r.Equal([]*models.APIKey{firstKey}, keys, "keys should survive reopening, except for those of deleted merchants")
+}
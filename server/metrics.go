@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/metrics"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+)
+
+// MetricsPath is the path of the metrics, which are served without authentication for Prometheus to scrape.
+const MetricsPath = "/metrics"
+
+// outcomeError is the outcome of a payment that the bank did not respond to as expected. Other outcomes are
+// the lower case status of the payment, like success.
+const outcomeError = "error"
+
+// serverMetrics are the metrics that a Server records.
+type serverMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	payments        *metrics.Counter
+	bankDuration    *metrics.Histogram
+	bankErrors      *metrics.Counter
+}
+
+// newServerMetrics registers the metrics of a server with store on registry.
+func newServerMetrics(registry *metrics.Registry, store PaymentStore, logger *slog.Logger) *serverMetrics {
+	m := &serverMetrics{
+		registry: registry,
+		requests: registry.NewCounter("http_requests_total",
+			"Number of HTTP requests by route, method and status code.", "route", "method", "status"),
+		requestDuration: registry.NewHistogram("http_request_duration_seconds",
+			"Latency of HTTP requests by route, method and status code.", metrics.DefaultBuckets, "route", "method", "status"),
+		payments: registry.NewCounter("payments_total",
+			"Number of payments sent to the bank by currency and outcome.", "currency", "outcome"),
+		bankDuration: registry.NewHistogram("bank_request_duration_seconds",
+			"Latency of calls to the bank by operation.", metrics.DefaultBuckets, "operation"),
+		bankErrors: registry.NewCounter("bank_errors_total",
+			"Number of calls to the bank that failed without a response, by operation.", "operation"),
+	}
+	registry.NewGaugeFunc("payment_store_payments", "Number of payments in the store.", func() float64 {
+		count, err := store.CountPayments()
+		if err != nil {
+			logger.Error("Failed to count payments for metrics", "error", err)
+			return math.NaN()
+		}
+		return float64(count)
+	})
+	return m
+}
+
+// paymentOutcome returns the outcome label of a payment with status.
+func paymentOutcome(status models.PaymentStatus) string {
+	return strings.ToLower(string(status))
+}
+
+// instrumentedBank is a bank.Acquirer that records the latency and errors of the calls to another, and
+// traces each call in a span that the request to the bank continues.
+type instrumentedBank struct {
+	bank.Acquirer
+	metrics *serverMetrics
+	tracer  *tracing.Tracer
+}
+
+// start starts the span of a call to the bank, and returns when the call started.
+func (b instrumentedBank) start(ctx context.Context, name string) (context.Context, *tracing.Span, time.Time) {
+	ctx, span := b.tracer.Start(ctx, name)
+	return ctx, span, time.Now()
+}
+
+// observe records the latency of a call to operation that started at start, and whether it failed, then ends
+// its span with the status the bank responded with.
+func (b instrumentedBank) observe(span *tracing.Span, operation string, start time.Time, status string, err error) {
+	b.metrics.bankDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		b.metrics.bankErrors.Inc(operation)
+	}
+
+	outcome := strings.ToLower(status)
+	if err != nil || status == "" {
+		outcome = outcomeError
+	}
+	span.SetError(err)
+	span.SetAttributes("outcome", outcome)
+	span.End()
+}
+
+func (b instrumentedBank) MakePayment(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	ctx, span, start := b.start(ctx, "BankClient.MakePayment")
+	span.SetAttributes("currency", r.Money.Currency, "amount_bucket", amountBucket(r.Money))
+	response, err := b.Acquirer.MakePayment(ctx, r)
+	var status string
+	if response != nil {
+		status = response.Status
+	}
+	b.observe(span, "make_payment", start, status, err)
+	return response, err
+}
+
+func (b instrumentedBank) Authorize(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	ctx, span, start := b.start(ctx, "BankClient.Authorize")
+	span.SetAttributes("currency", r.Money.Currency, "amount_bucket", amountBucket(r.Money))
+	response, err := b.Acquirer.Authorize(ctx, r)
+	var status string
+	if response != nil {
+		status = response.Status
+	}
+	b.observe(span, "authorize", start, status, err)
+	return response, err
+}
+
+func (b instrumentedBank) Capture(ctx context.Context, r bank.CaptureRequest) (*bank.CaptureResponse, error) {
+	ctx, span, start := b.start(ctx, "BankClient.Capture")
+	response, err := b.Acquirer.Capture(ctx, r)
+	var status string
+	if response != nil {
+		status = response.Status
+	}
+	b.observe(span, "capture", start, status, err)
+	return response, err
+}
+
+func (b instrumentedBank) Refund(ctx context.Context, r bank.RefundRequest) (*bank.RefundResponse, error) {
+	ctx, span, start := b.start(ctx, "BankClient.Refund")
+	response, err := b.Acquirer.Refund(ctx, r)
+	var status string
+	if response != nil {
+		status = response.Status
+	}
+	b.observe(span, "refund", start, status, err)
+	return response, err
+}
+
+func (b instrumentedBank) Void(ctx context.Context, r bank.VoidRequest) (*bank.VoidResponse, error) {
+	ctx, span, start := b.start(ctx, "BankClient.Void")
+	response, err := b.Acquirer.Void(ctx, r)
+	var status string
+	if response != nil {
+		status = response.Status
+	}
+	b.observe(span, "void", start, status, err)
+	return response, err
+}
+
+// Ping pings the underlying bank if it is a Pinger, so that it is still checked by the health probes.
+func (b instrumentedBank) Ping(ctx context.Context) error {
+	if pinger, ok := b.Acquirer.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/metrics"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	s := newTestServerWithBank(t, &fakeBank{})
+	serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	payment := decodePayment(t, serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest()))
+	serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+	serve(t, s, "GET", utils.Path+"/unknown", nil)
+	serveWithKey(t, s, "", "GET", utils.Path, nil)
+	serve(t, s, "GET", "/unknown", nil)
+	serve(t, s, "GET", HealthzPath, nil)
+	serve(t, s, "PROPFIND", utils.Path, nil)
+	serve(t, s, "X-MADE-UP", utils.Path, nil)
+
+	r.Equal(float64(2), s.metrics.requests.Value(utils.Path, "POST", "200"))
+	r.Equal(float64(1), s.metrics.requests.Value(utils.Path+"/{id}", "GET", "200"))
+	r.Equal(float64(1), s.metrics.requests.Value(utils.Path+"/{id}", "GET", "404"))
+	r.Equal(float64(1), s.metrics.requests.Value(utils.Path, "GET", "401"))
+	r.Equal(float64(1), s.metrics.requests.Value(unmatchedRoute, "GET", "404"))
+	r.Equal(float64(2), s.metrics.requests.Value(unmatchedRoute, otherMethod, "405"), "non-standard methods should share a series")
+	r.Equal(uint64(2), s.metrics.requestDuration.Count(utils.Path, "POST", "200"))
+	r.Equal(float64(2), s.metrics.payments.Value("GBP", "success"))
+	r.Equal(uint64(2), s.metrics.bankDuration.Count("make_payment"))
+	r.Equal(float64(0), s.metrics.bankErrors.Value("make_payment"))
+
+	response := serveWithKey(t, s, "", "GET", MetricsPath, nil)
+	r.Equal(http.StatusOK, response.Code)
+	r.Equal(metrics.ContentType, response.Header().Get("Content-Type"))
+	body := response.Body.String()
+	for _, line := range []string{
+		`http_requests_total{route="/payments",method="POST",status="200"} 2`,
+		`http_request_duration_seconds_count{route="/payments/{id}",method="GET",status="200"} 1`,
+		`payments_total{currency="GBP",outcome="success"} 2`,
+		`bank_request_duration_seconds_count{operation="make_payment"} 2`,
+		`payment_store_payments 2`,
+	} {
+		r.Contains(body, line+"\n")
+	}
+	r.NotContains(body, HealthzPath, "probes should not be counted")
+	r.NotContains(body, MetricsPath, "scrapes should not be counted")
+
+	response = serveWithKey(t, s, "", "POST", MetricsPath, nil)
+	r.Equal(http.StatusMethodNotAllowed, response.Code)
+}
+
+func TestPaymentOutcomeMetrics(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name            string
+		bank            bank.Acquirer
+		capture         bool
+		expectedOutcome string
+		expectedErrors  float64
+	}
+
+	testCases := []testCase{
+		{"captured", &fakeBank{}, true, "success", 0},
+		{"authorized", &fakeBank{}, false, "authorized", 0},
+		{"declined", &fakeBank{failPayments: true}, true, "failed", 0},
+		{"bank error", &brokenBank{err: errors.New("connection refused")}, true, outcomeError, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, tc.bank)
+
+			request := utils.ValidProcessPaymentRequest()
+			request.Capture = &tc.capture
+			serve(t, s, "POST", utils.Path, request)
+
+			operation := "make_payment"
+			if !tc.capture {
+				operation = "authorize"
+			}
+			r.Equal(float64(1), s.metrics.payments.Value("GBP", tc.expectedOutcome))
+			r.Equal(uint64(1), s.metrics.bankDuration.Count(operation))
+			r.Equal(tc.expectedErrors, s.metrics.bankErrors.Value(operation))
+		})
+	}
+}
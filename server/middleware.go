@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// unmatchedRoute is the route of requests that match no route, so that unknown paths do not each get their
+// own series in the metrics.
+const unmatchedRoute = "unmatched"
+
+// otherMethod is the method of requests with a non-standard method in the metrics, so that clients cannot
+// create a series for each method they make up.
+const otherMethod = "other"
+
+// standardMethods are the methods that requests are counted by in the metrics.
+var standardMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+type (
+	merchantIDKey  struct{}
+	requestInfoKey struct{}
+)
+
+// requestInfo is what is learnt about a request while it is handled, for its log lines and metrics. It is
+// filled in by the middleware and handlers that learn it, so that instrument can see it once the request is
+// handled.
+type requestInfo struct {
+	// route is the path template of the matched route, like /payments/{id}
+	route      string
+	merchantID string
+	paymentID  string
+}
+
+// requestInfoFromContext returns the requestInfo of the request that ctx belongs to. It is not nil, so that
+// it can be filled in even outside of instrument, like in tests that call handlers directly.
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// RequestIDHeader is the header that a request's ID is accepted from and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client.
+const maxRequestIDLength = 128
+
+// validRequestID matches the request IDs accepted from clients, so that they are safe to log and echo.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// RequestIDFromContext returns the ID of the request that ctx belongs to, or "" if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	return bank.RequestIDFromContext(ctx)
+}
+
+/*
+withRequestID gives each request an ID, so that its log lines, bank calls and response can be tied
+together:
+  - The ID is taken from the X-Request-ID header if the client sent a valid one, of up to 128 letters,
+    digits, dots, underscores, colons and dashes, and is otherwise generated
+  - It is stored in the request's context, where the bank adapter finds it to send to the bank
+  - It is returned in the X-Request-ID header of the response, and in error responses
+*/
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if len(id) > maxRequestIDLength || !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(bank.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// maxRequestBodyBytes is the largest request body accepted, which is far more than any request needs.
+const maxRequestBodyBytes = 1 << 20
+
+// limitRequestBody stops handlers reading more than maxRequestBodyBytes of a request body, so that a client
+// cannot make the server buffer an unbounded body. Reading past the limit fails with a *http.MaxBytesError.
+func limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MerchantIDFromContext returns the ID of the merchant that authenticated the request that ctx belongs to, or
+// "" if there is none.
+func MerchantIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(merchantIDKey{}).(string)
+	return id
+}
+
+// authenticate rejects requests without a valid API key of an existing merchant in an Authorization: Bearer
+// header, and stores the ID of the merchant in the context of the others.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merchantID, ok := s.authenticateKey(w, r, s.apiKeys)
+		if !ok {
+			return
+		}
+		if _, err := s.merchants.GetMerchant(merchantID); err != nil {
+			if !errors.Is(err, ErrMerchantNotFound) {
+				s.writeError(w, r, fmt.Errorf("failed to fetch merchant %s: %w", merchantID, err))
+				return
+			}
+			s.logger.WarnContext(r.Context(), "API key belongs to unknown merchant", "merchant_id", merchantID)
+			s.writeError(w, r, newError(http.StatusUnauthorized, CodeUnauthorized, "invalid API key"))
+			return
+		}
+		requestInfoFromContext(r.Context()).merchantID = merchantID
+		ctx := context.WithValue(r.Context(), merchantIDKey{}, merchantID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateAdmin rejects requests without a valid admin key in an Authorization: Bearer header.
+func (s *Server) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.authenticateKey(w, r, s.adminKeys); ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authenticateKey returns the ID that the key in the Authorization: Bearer header of r belongs to in keys.
+// If there is no such key, it writes an error response to r and returns false.
+func (s *Server) authenticateKey(w http.ResponseWriter, r *http.Request, keys *APIKeyStore) (string, bool) {
+	scheme, key, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || key == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.writeError(w, r, newError(http.StatusUnauthorized, CodeUnauthorized, "missing API key in Authorization: Bearer header"))
+		return "", false
+	}
+	id, ok := keys.Authenticate(strings.TrimSpace(key))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		s.writeError(w, r, newError(http.StatusUnauthorized, CodeUnauthorized, "invalid API key"))
+		return "", false
+	}
+	return id, true
+}
+
+// recoverPanics turns a panic in a handler into a http 500 error response, so that one bad request does not
+// close the connection without a response.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				s.writeError(w, r, fmt.Errorf("panic: %v", recovered))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+instrument logs every request once it is handled, and records it in the metrics:
+  - The log line has the method, route, status code and latency of the request, and the merchant and
+    payment it was for if they are known
+  - Requests with a http 5xx response are logged as errors
+  - The metrics count and time requests by route, method and status code, with non-standard methods
+    counted as other
+*/
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{route: unmatchedRoute}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		latency := time.Since(start)
+
+		status, method := strconv.Itoa(recorder.status), r.Method
+		if !standardMethods[method] {
+			method = otherMethod
+		}
+		s.metrics.requests.Inc(info.route, method, status)
+		s.metrics.requestDuration.Observe(latency.Seconds(), info.route, method, status)
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		s.logger.LogAttrs(ctx, level, "Handled request",
+			slog.String("method", r.Method),
+			slog.Int("status", recorder.status),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+		)
+	})
+}
+
+// recordRoute is router middleware that records the path template of the matched route in the requestInfo,
+// and the payment ID for routes of a payment.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := requestInfoFromContext(r.Context())
+		if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			info.route = template
+			if strings.HasPrefix(template, utils.Path+"/{id}") {
+				info.paymentID = mux.Vars(r)["id"]
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder is a http.ResponseWriter that records the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/mockbank"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name              string
+		header            string
+		expectedRequestID string // "" if a new ID should be generated
+	}
+
+	testCases := []testCase{
+		{"no request ID", "", ""},
+		{"client request ID", "req-123", "req-123"},
+		{"client UUID", "6f1d5a52-8c1f-4bd5-9e0a-3c1f0a8d7e21", "6f1d5a52-8c1f-4bd5-9e0a-3c1f0a8d7e21"},
+		{"longest request ID", strings.Repeat("a", maxRequestIDLength), strings.Repeat("a", maxRequestIDLength)},
+		{"too long request ID", strings.Repeat("a", maxRequestIDLength+1), ""},
+		{"request ID with spaces", "req 123", ""},
+		{"request ID with quotes", `req"123`, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			acquirer := mockbank.NewBankClient()
+			s := newTestServerWithBank(t, acquirer)
+
+			request := httptest.NewRequest("POST", utils.Path, strings.NewReader(`{}`))
+			request.Header.Set("Authorization", "Bearer "+testAPIKey)
+			if tc.header != "" {
+				request.Header.Set(RequestIDHeader, tc.header)
+			}
+			response := httptest.NewRecorder()
+			s.Routes().ServeHTTP(response, request)
+			r.Equal(http.StatusBadRequest, response.Code)
+
+			requestID := response.Header().Get(RequestIDHeader)
+			if tc.expectedRequestID == "" {
+				_, err := uuid.Parse(requestID)
+				r.NoError(err, "a new request ID should be generated")
+			} else {
+				r.Equal(tc.expectedRequestID, requestID)
+			}
+			r.Equal(requestID, decodeError(t, response).RequestID, "the error should have the request ID")
+			r.Empty(acquirer.Requests())
+		})
+	}
+}
+
+func TestRequestIDIsPassedToTheBank(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	acquirer := mockbank.NewBankClient()
+	s := newTestServerWithBank(t, acquirer)
+	body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+	r.NoError(err)
+
+	var requestIDs []string
+	for _, header := range []string{"req-123", ""} {
+		request := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+testAPIKey)
+		if header != "" {
+			request.Header.Set(RequestIDHeader, header)
+		}
+		response := httptest.NewRecorder()
+		s.Routes().ServeHTTP(response, request)
+		r.Equal(http.StatusOK, response.Code)
+		requestIDs = append(requestIDs, response.Header().Get(RequestIDHeader))
+	}
+	r.Equal("req-123", requestIDs[0])
+
+	r.Equal([]mockbank.RecordedRequest{
+		{Operation: "make_payment", RequestID: requestIDs[0]},
+		{Operation: "make_payment", RequestID: requestIDs[1]},
+	}, acquirer.Requests())
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name string
+		path string
+	}
+
+	// The bodies are valid JSON up to the limit, so only their size is rejected
+	testCases := []testCase{
+		{"body read whole", utils.Path},
+		{"body decoded", utils.Path + "/" + uuid.New().String() + "/capture"},
+		{"body decoded after authentication", WebhooksPath},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServer(t)
+
+			body := `{"reason":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`
+			request := httptest.NewRequest("POST", tc.path, strings.NewReader(body))
+			request.Header.Set("Authorization", "Bearer "+testAPIKey)
+			response := httptest.NewRecorder()
+			s.Routes().ServeHTTP(response, request)
+
+			r.Equal(http.StatusRequestEntityTooLarge, response.Code)
+			r.Equal(CodeRequestTooLarge, decodeError(t, response).Code)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+// PaymentCursor is a position in a list of payments ordered by creation time, with ties broken by ID.
+type PaymentCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// equal reports whether c and other are the same position.
+func (c PaymentCursor) equal(other PaymentCursor) bool {
+	return c.CreatedAt.Equal(other.CreatedAt) && c.ID == other.ID
+}
+
+// before reports whether c comes before other in ascending order.
+func (c PaymentCursor) before(other PaymentCursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt)
+	}
+	return c.ID < other.ID
+}
+
+// PaymentQuery selects payments for PaymentStore.ListPayments. Zero fields do not filter.
+type PaymentQuery struct {
+	MerchantID string
+	Status     models.PaymentStatus
+	Currency   string
+	// MinAmount and MaxAmount bound the amount in minor units, inclusive.
+	MinAmount, MaxAmount int64
+	// CreatedFrom is inclusive and CreatedTo is exclusive.
+	CreatedFrom, CreatedTo time.Time
+	// LastFour matches the last four digits of the card number.
+	LastFour string
+	// Descending lists the newest payments first, and otherwise the oldest are first.
+	Descending bool
+	// After lists only the payments after the cursor, in the order of the query.
+	After *PaymentCursor
+	// Limit is the maximum number of payments to return, or all if 0.
+	Limit int
+}
+
+// matches reports whether payment satisfies the filters of q, ignoring its cursor.
+func (q PaymentQuery) matches(payment *models.MaskedPayment) bool {
+	switch {
+	case q.MerchantID != "" && payment.MerchantID != q.MerchantID:
+		return false
+	case q.Status != "" && payment.Status != q.Status:
+		return false
+	case q.Currency != "" && payment.Currency != q.Currency:
+		return false
+	case q.MinAmount != 0 && payment.Amount < q.MinAmount:
+		return false
+	case q.MaxAmount != 0 && payment.Amount > q.MaxAmount:
+		return false
+	case !q.CreatedFrom.IsZero() && payment.CreatedAt.Before(q.CreatedFrom):
+		return false
+	case !q.CreatedTo.IsZero() && !payment.CreatedAt.Before(q.CreatedTo):
+		return false
+	case q.LastFour != "" && lastFour(payment) != q.LastFour:
+		return false
+	}
+	return true
+}
+
+// lastFour returns the last four digits of the card number of payment.
+func lastFour(payment *models.MaskedPayment) string {
+	if len(payment.MaskedCardNumber) < 4 {
+		return ""
+	}
+	return payment.MaskedCardNumber[len(payment.MaskedCardNumber)-4:]
+}
+
+/*
+paymentIndex orders payments by creation time, so that pages can be found with a binary search instead of
+scanning every payment. Besides the index of all payments, there is an index for each merchant, status,
+currency and card last four, and a query walks the smallest index that applies to it.
+*/
+type paymentIndex struct {
+	all        []PaymentCursor
+	byMerchant map[string][]PaymentCursor
+	byStatus   map[models.PaymentStatus][]PaymentCursor
+	byCurrency map[string][]PaymentCursor
+	byLastFour map[string][]PaymentCursor
+}
+
+func newPaymentIndex() *paymentIndex {
+	return &paymentIndex{
+		byMerchant: make(map[string][]PaymentCursor),
+		byStatus:   make(map[models.PaymentStatus][]PaymentCursor),
+		byCurrency: make(map[string][]PaymentCursor),
+		byLastFour: make(map[string][]PaymentCursor),
+	}
+}
+
+// update moves a payment from its previous version, which is nil for a new payment, to its current one.
+func (i *paymentIndex) update(previous, current *models.MaskedPayment) {
+	if previous != nil {
+		key := PaymentCursor{previous.CreatedAt, previous.ID}
+		i.all = remove(i.all, key)
+		i.byMerchant[previous.MerchantID] = remove(i.byMerchant[previous.MerchantID], key)
+		i.byStatus[previous.Status] = remove(i.byStatus[previous.Status], key)
+		i.byCurrency[previous.Currency] = remove(i.byCurrency[previous.Currency], key)
+		i.byLastFour[lastFour(previous)] = remove(i.byLastFour[lastFour(previous)], key)
+	}
+	key := PaymentCursor{current.CreatedAt, current.ID}
+	i.all = insert(i.all, key)
+	i.byMerchant[current.MerchantID] = insert(i.byMerchant[current.MerchantID], key)
+	i.byStatus[current.Status] = insert(i.byStatus[current.Status], key)
+	i.byCurrency[current.Currency] = insert(i.byCurrency[current.Currency], key)
+	i.byLastFour[lastFour(current)] = insert(i.byLastFour[lastFour(current)], key)
+}
+
+// candidates returns the smallest index that holds every payment matching query.
+func (i *paymentIndex) candidates(query PaymentQuery) []PaymentCursor {
+	keys := i.all
+	if query.MerchantID != "" && len(i.byMerchant[query.MerchantID]) < len(keys) {
+		keys = i.byMerchant[query.MerchantID]
+	}
+	if query.Status != "" && len(i.byStatus[query.Status]) < len(keys) {
+		keys = i.byStatus[query.Status]
+	}
+	if query.Currency != "" && len(i.byCurrency[query.Currency]) < len(keys) {
+		keys = i.byCurrency[query.Currency]
+	}
+	if query.LastFour != "" && len(i.byLastFour[query.LastFour]) < len(keys) {
+		keys = i.byLastFour[query.LastFour]
+	}
+	return keys
+}
+
+/*
+walk calls visit with the keys of the candidate payments for query in its order, starting after its cursor
+and within its creation time range, until visit returns false.
+*/
+func (i *paymentIndex) walk(query PaymentQuery, visit func(PaymentCursor) bool) {
+	keys := i.candidates(query)
+
+	// Narrow keys to the creation time range, and then to the keys after the cursor
+	start, end := 0, len(keys)
+	if !query.CreatedFrom.IsZero() {
+		start = search(keys, PaymentCursor{CreatedAt: query.CreatedFrom})
+	}
+	if !query.CreatedTo.IsZero() {
+		end = search(keys, PaymentCursor{CreatedAt: query.CreatedTo})
+	}
+	if query.After != nil {
+		position := search(keys, *query.After)
+		if query.Descending {
+			end = min(end, position)
+		} else {
+			if position < len(keys) && keys[position].equal(*query.After) {
+				position++
+			}
+			start = max(start, position)
+		}
+	}
+
+	if query.Descending {
+		for j := end - 1; j >= start; j-- {
+			if !visit(keys[j]) {
+				return
+			}
+		}
+		return
+	}
+	for j := start; j < end; j++ {
+		if !visit(keys[j]) {
+			return
+		}
+	}
+}
+
+// search returns the position of the first key in keys that is not before key.
+func search(keys []PaymentCursor, key PaymentCursor) int {
+	return sort.Search(len(keys), func(j int) bool { return !keys[j].before(key) })
+}
+
+// insert adds key to the sorted keys.
+func insert(keys []PaymentCursor, key PaymentCursor) []PaymentCursor {
+	j := search(keys, key)
+	keys = append(keys, PaymentCursor{})
+	copy(keys[j+1:], keys[j:])
+	keys[j] = key
+	return keys
+}
+
+// remove removes key from the sorted keys, if it is there.
+func remove(keys []PaymentCursor, key PaymentCursor) []PaymentCursor {
+	j := search(keys, key)
+	if j == len(keys) || !keys[j].equal(key) {
+		return keys
+	}
+	return append(keys[:j], keys[j+1:]...)
+}
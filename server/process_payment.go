@@ -1,147 +1,391 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
-	"regexp"
 	"strconv"
-	"sync"
+	"strings"
+	"time"
 
-	"github.com/celestebrant/processout-payment-gateway/mockbank"
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/card"
 	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
 )
 
-// Subset of ISO 4217 currency codes
-var supportedCurrencies = map[string]bool{
-	"EUR": true, "GBP": true,
-}
-
-var (
-	paymentStore *PaymentStore
-	bankClient   *mockbank.BankClient
-	once         sync.Once
+const (
+	// maxExpiryYearsAhead is how many years ahead of now a card's expiry year may be.
+	maxExpiryYearsAhead = 20
+	// bankCallTimeout limits how long a payment waits for the bank, which is not cancelled with its request.
+	bankCallTimeout = 30 * time.Second
 )
 
-func init() {
-	once.Do(func() {
-		paymentStore = NewPaymentStore()
-		bankClient = mockbank.NewBankClient()
-	})
-}
+// errBankNotReached is returned for payments that failed before reaching the bank, which can be retried.
+var errBankNotReached = newError(http.StatusBadGateway, CodeBankError, "failed to reach the bank")
+
+/*
+ProcessPaymentHandler handles process payment requests.
+
+If the request has an Idempotency-Key header, a repeat of a previous request with the same key and body
+replays the stored payment instead of calling the bank again. Reusing a key with a different body, or
+while the original request is still in progress, returns a http 409 error response. Keys are not persisted,
+so a repeat after the server restarts is sent to the bank again.
+
+A request without a currency is in the merchant's default currency. An invalid request returns a http 400
+error response listing every invalid field. A payment in a currency that the merchant does not accept, or
+that the bank does not settle in, also returns a http 400 error response, with code currency_not_accepted
+or currency_not_settled, as does an amount outside the merchant's transaction limits, with code
+amount_outside_limits. Suspended merchants get a http 403 error response.
+
+The request is traced in a span with the currency, amount bucket and outcome of the payment, whose children
+trace the validation, the call to the bank and storing the payment.
+*/
+func (s *Server) ProcessPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := s.tracer.Start(r.Context(), "ProcessPaymentHandler")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	merchant, ok := s.fetchMerchant(w, r)
+	if !ok {
+		return
+	}
+	if merchant.Status != models.MerchantActive {
+		s.writeError(w, r, newError(http.StatusForbidden, CodeMerchantSuspended, "merchant is suspended and cannot make payments"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, r, readError(err, "failed to read the request"))
+		return
+	}
 
-// ProcessPaymentHandler handles process payment requests.
-func ProcessPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	request := models.ProcessPaymentRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "failed to unmarshal the request", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &request); err != nil {
+		s.writeError(w, r, newError(http.StatusBadRequest, CodeMalformedRequest, "failed to unmarshal the request"))
 		return
 	}
+	if request.Currency == "" {
+		request.Currency = merchant.DefaultCurrency
+	}
 
-	if err := validateProcessPaymentRequest(request); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	amount, err := s.tracedValidateProcessPaymentRequest(r.Context(), request)
+	var errs validationErrors
+	errors.As(err, &errs)
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		errs = append(errs, models.FieldError{
+			Code:    CodeInvalidIdempotencyKey,
+			Field:   IdempotencyKeyHeader,
+			Message: fmt.Sprintf("idempotency key should have up to %d characters", maxIdempotencyKeyLength),
+		})
+	}
+	if len(errs) > 0 {
+		s.writeError(w, r, errs)
+		return
+	}
+	span.SetAttributes("currency", amount.Currency, "amount_bucket", amountBucket(amount))
+	if err := s.checkCurrency(merchant, amount.Currency); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if err := checkLimits(merchant, amount); err != nil {
+		s.writeError(w, r, err)
 		return
 	}
 
-	// Generate a mock bank call request, and receive a mocked response with useful data
-	bankRequest := bankPaymentRequest(request)
-	bankResponse, err := bankClient.MakePayment(bankRequest)
+	if idempotencyKey != "" {
+		// Scope the key to the merchant, so that merchants cannot replay each other's payments
+		idempotencyKey = MerchantIDFromContext(r.Context()) + " " + idempotencyKey
+		stored, err := s.idempotency.Begin(idempotencyKey, hashRequestBody(body))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if stored != nil {
+			storedPayment := stored.(*models.MaskedPayment)
+			requestInfoFromContext(r.Context()).paymentID = storedPayment.ID
+			span.SetAttributes("outcome", paymentOutcome(storedPayment.Status), "replayed", "true")
+			s.logger.InfoContext(r.Context(), "Replayed payment")
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			json.NewEncoder(w).Encode(storedPayment)
+			return
+		}
+		// Forget the key if the request ends without completing or failing it, including by panicking, so
+		// that it is not left in progress. This does nothing once the key is completed or failed.
+		defer s.idempotency.Release(idempotencyKey)
+	}
+
+	maskedPayment, err := s.makePayment(r.Context(), request, amount)
 	if err != nil {
-		http.Error(w, "unexpected error from call to the bank", http.StatusInternalServerError)
+		if idempotencyKey != "" && !errors.Is(err, errBankNotReached) {
+			// The bank may have charged the card, so a retry with the key must not charge it again. Otherwise
+			// the key is released so that the client can retry.
+			s.idempotency.Fail(idempotencyKey)
+		}
+		s.writeError(w, r, err)
 		return
 	}
+	if idempotencyKey != "" {
+		s.idempotency.Complete(idempotencyKey, maskedPayment)
+	}
+	span.SetAttributes("outcome", paymentOutcome(maskedPayment.Status))
+	s.logger.InfoContext(r.Context(), "Processed payment", "amount", maskedPayment.Money.String(), "status", maskedPayment.Status)
+
+	json.NewEncoder(w).Encode(maskedPayment)
+}
+
+// makePayment sends request for amount to the bank, then stores and returns the resulting MaskedPayment. The
+// payment is only authorized if request.Capture is false, and otherwise it is also captured.
+func (s *Server) makePayment(ctx context.Context, request models.ProcessPaymentRequest, amount money.Money) (*models.MaskedPayment, error) {
+	// Generate a bank request, and receive a response with the payment ID and status
+	bankRequest := bankPaymentRequest(request, amount)
+	makePayment, expectedStatus := s.bank.MakePayment, models.StatusSuccess
+	if request.Capture != nil && !*request.Capture {
+		makePayment, expectedStatus = s.bank.Authorize, models.StatusAuthorized
+	}
+	// The bank call outlives the request, since a client that disconnects part way through a payment could
+	// otherwise leave it charged without being stored
+	bankCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bankCallTimeout)
+	defer cancel()
+	bankResponse, err := makePayment(bankCtx, bankRequest)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to make payment with the bank", "error", err)
+		s.metrics.payments.Inc(amount.Currency, outcomeError)
+		if errors.Is(err, bank.ErrNotSent) {
+			return nil, errBankNotReached
+		}
+		return nil, newError(http.StatusBadGateway, CodeBankError, "unexpected error from call to the bank")
+	}
 	if bankResponse == nil {
-		http.Error(w, "failed to receive a response from the bank", http.StatusInternalServerError)
-		return
+		s.metrics.payments.Inc(amount.Currency, outcomeError)
+		return nil, newError(http.StatusBadGateway, CodeBankError, "failed to receive a response from the bank")
 	}
 
-	maskedPayment := populateMaskedPayment(request, bankResponse.PaymentID, bankResponse.Status)
-	paymentStore.AddPayment(maskedPayment)
-	log.Println("Processed payment:", *maskedPayment)
+	requestInfoFromContext(ctx).paymentID = bankResponse.PaymentID
+	status := models.PaymentStatus(bankResponse.Status)
+	if status != expectedStatus && status != models.StatusFailed {
+		s.logger.ErrorContext(ctx, "Unexpected payment status from the bank", "status", bankResponse.Status)
+		s.metrics.payments.Inc(amount.Currency, outcomeError)
+		return nil, newError(http.StatusBadGateway, CodeBankError, "unexpected payment status from the bank")
+	}
+	s.metrics.payments.Inc(amount.Currency, paymentOutcome(status))
 
-	json.NewEncoder(w).Encode(maskedPayment)
+	maskedPayment := populateMaskedPayment(request, amount, bankResponse.PaymentID)
+	maskedPayment.MerchantID = MerchantIDFromContext(ctx)
+	maskedPayment.CreatedAt = s.clock.Now().UTC()
+	if status == models.StatusSuccess {
+		maskedPayment.CapturedAmount = maskedPayment.Amount
+	}
+	if err := s.transition(ctx, maskedPayment, status, bankOutcomeReason(status, bankResponse.Reason)); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to store payment", "error", err)
+		return nil, newError(http.StatusInternalServerError, CodeInternalError, "failed to store the payment")
+	}
+	return maskedPayment, nil
 }
 
-// bankPaymentRequest generates a mockbank.CallBankRequest by populating with values from p.
-func bankPaymentRequest(p models.ProcessPaymentRequest) mockbank.MakePaymentRequest {
-	return mockbank.MakePaymentRequest{
+// bankOutcomeReason describes the outcome of a new payment with status, and the bank's reason if any.
+func bankOutcomeReason(status models.PaymentStatus, bankReason string) string {
+	var reason string
+	switch status {
+	case models.StatusSuccess:
+		reason = "payment made by the bank"
+	case models.StatusAuthorized:
+		reason = "payment authorized by the bank"
+	default:
+		reason = "payment failed at the bank"
+	}
+	if bankReason != "" {
+		reason += ": " + bankReason
+	}
+	return reason
+}
+
+// bankPaymentRequest generates a bank.MakePaymentRequest by populating with values from p and amount.
+func bankPaymentRequest(p models.ProcessPaymentRequest, amount money.Money) bank.MakePaymentRequest {
+	return bank.MakePaymentRequest{
 		CardNumber:  p.CardNumber,
 		ExpiryYear:  p.ExpiryYear,
 		ExpiryMonth: p.ExpiryMonth,
 		CVV:         p.CVV,
-		Amount:      p.Amount,
-		Currency:    p.Currency,
+		Money:       amount,
+	}
+}
+
+// tracedValidateProcessPaymentRequest is validateProcessPaymentRequest in a span, which has the currency and
+// amount bucket of valid requests.
+func (s *Server) tracedValidateProcessPaymentRequest(ctx context.Context, request models.ProcessPaymentRequest) (money.Money, error) {
+	_, span := s.tracer.Start(ctx, "validateProcessPaymentRequest")
+	defer span.End()
+
+	amount, err := validateProcessPaymentRequest(request, s.decimalAmounts, s.clock.Now())
+	if err != nil {
+		span.SetAttributes("outcome", outcomeInvalid)
+		return amount, err
 	}
+	span.SetAttributes("outcome", outcomeValid, "currency", amount.Currency, "amount_bucket", amountBucket(amount))
+	return amount, nil
 }
 
 /*
-validateProcessPaymentRequest validates the data in request with the following rules:
-  - Card number must be exactly 16 digits long with numerical characters only
-  - Expiry year must be exactly 4 digits long, however no validation is performed
-    relative to current time
+validateProcessPaymentRequest validates the data in request at time now with the following rules, and
+returns the amount to be transacted:
+  - Card number must be 12 to 19 digits of a supported brand, with a length the brand issues, and must
+    pass the Luhn check
+  - Expiry year must be exactly 4 digits long
   - Expiry month must have an integer value of 1 to 12, inclusive
-  - CVV must be exactly 3 digits long with numerical characters only
-  - Amount must be a positive number with up to 2 decimal places
-  - Currency must be either GBP or EUR
+  - The card must not have expired, and must not expire more than maxExpiryYearsAhead years from now
+  - CVV must have 4 digits for Amex and 3 digits for other brands, or 3 or 4 digits if the brand is unknown
+  - Currency must be an upper case ISO 4217 currency code
+  - Amount must be a positive whole number of minor units of the currency, or if decimalAmounts is set,
+    a positive decimal with up to as many decimal places as the currency's exponent
 */
-func validateProcessPaymentRequest(request models.ProcessPaymentRequest) error {
-	cardNumberPattern := regexp.MustCompile(`^\d{16}$`)
-	if !cardNumberPattern.MatchString(request.CardNumber) {
-		return fmt.Errorf("card number should have 16 digits")
+func validateProcessPaymentRequest(request models.ProcessPaymentRequest, decimalAmounts bool, now time.Time) (money.Money, error) {
+	var errs validationErrors
+	invalid := func(code, field, format string, args ...any) {
+		errs = append(errs, models.FieldError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)})
 	}
 
-	if request.ExpiryYear < 1000 || request.ExpiryYear > 9999 {
-		return fmt.Errorf("expiry year should have 4 digits")
+	brand, err := card.ValidateNumber(request.CardNumber)
+	switch {
+	case errors.Is(err, card.ErrInvalidNumber):
+		invalid(CodeInvalidCardNumber, "card_number", "card number should have 12 to 19 digits")
+	case errors.Is(err, card.ErrUnknownBrand):
+		invalid(CodeUnsupportedCardBrand, "card_number", "card brand is not supported")
+	case errors.Is(err, card.ErrInvalidLength):
+		invalid(CodeInvalidCardNumber, "card_number", "card number should have %s digits for %s", describeLengths(brand.Lengths()), brand)
+	case errors.Is(err, card.ErrChecksum):
+		invalid(CodeInvalidCardNumber, "card_number", "card number is invalid")
 	}
 
-	if request.ExpiryMonth == 0 || request.ExpiryMonth > 12 {
-		return fmt.Errorf("expiry month should have value of 1 to 12")
+	validYear := request.ExpiryYear >= 1000 && request.ExpiryYear <= 9999
+	if !validYear {
+		invalid(CodeInvalidExpiryYear, "expiry_year", "expiry year should have 4 digits")
+	}
+	validMonth := request.ExpiryMonth >= 1 && request.ExpiryMonth <= 12
+	if !validMonth {
+		invalid(CodeInvalidExpiryMonth, "expiry_month", "expiry month should have value of 1 to 12")
+	}
+	if validYear && validMonth {
+		if err := validateExpiry(request.ExpiryYear, request.ExpiryMonth, now); err != nil {
+			errs = append(errs, *err)
+		}
 	}
 
-	cvvPattern := regexp.MustCompile(`^\d{3}$`)
-	if !cvvPattern.MatchString(request.CVV) {
-		return fmt.Errorf("cvv should have 3 digits")
+	switch cvvErr := card.ValidateCVV(brand, request.CVV); {
+	case cvvErr != nil && brand == "":
+		// The CVV length depends on the brand, so without one only the range of lengths can be checked
+		invalid(CodeInvalidCVV, "cvv", "cvv should have 3 or 4 digits")
+	case cvvErr != nil:
+		invalid(CodeInvalidCVV, "cvv", "cvv should have %d digits for %s", brand.CVVLength(), brand)
 	}
 
-	amountStr := fmt.Sprintf("%.2f", request.Amount)
-	amountParsedBack, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		return fmt.Errorf("an unexpected error occurred when parsing amount")
+	var amount money.Money
+	if _, exists := money.LookupCurrency(request.Currency); !exists {
+		invalid(CodeInvalidCurrency, "currency", "invalid currency code")
+	} else if amount, err = parseAmount(request.Amount, request.Currency, decimalAmounts); err != nil {
+		errs = append(errs, err.(models.FieldError))
+	}
+
+	if len(errs) > 0 {
+		return money.Money{}, errs
+	}
+	return amount, nil
+}
+
+/*
+validateExpiry checks the expiry date of a card at time now. A card is valid until the end of its expiry
+month in UTC, so it has expired once that month has passed. Cards are issued for a few years at most, so
+an expiry year more than maxExpiryYearsAhead years from now is rejected as a mistake.
+*/
+func validateExpiry(year, month uint, now time.Time) *models.FieldError {
+	now = now.UTC()
+	currentYear, currentMonth := uint(now.Year()), uint(now.Month())
+	switch {
+	case year < currentYear:
+		return &models.FieldError{Code: CodeCardExpired, Field: "expiry_year", Message: "card has expired"}
+	case year == currentYear && month < currentMonth:
+		return &models.FieldError{Code: CodeCardExpired, Field: "expiry_month", Message: "card has expired"}
+	case year > currentYear+maxExpiryYearsAhead:
+		return &models.FieldError{
+			Code:    CodeInvalidExpiryYear,
+			Field:   "expiry_year",
+			Message: fmt.Sprintf("expiry year should be no more than %d years from now", maxExpiryYearsAhead),
+		}
 	}
-	if amountParsedBack != request.Amount {
-		return fmt.Errorf("amount must have up to two decimal places")
+	return nil
+}
+
+// describeLengths describes card number lengths for an error message, like "15", "16 to 19" or
+// "13, 16 or 19".
+func describeLengths(lengths []int) string {
+	first, last := lengths[0], lengths[len(lengths)-1]
+	switch {
+	case len(lengths) == 1:
+		return strconv.Itoa(first)
+	case last-first == len(lengths)-1:
+		return fmt.Sprintf("%d to %d", first, last)
+	}
+	described := make([]string, len(lengths))
+	for i, length := range lengths {
+		described[i] = strconv.Itoa(length)
 	}
+	return strings.Join(described[:len(lengths)-1], ", ") + " or " + described[len(lengths)-1]
+}
 
-	amountPattern := regexp.MustCompile(`^\d+(\.\d{1,2})?$`)
-	if !amountPattern.MatchString(fmt.Sprintf("%.2f", request.Amount)) {
-		return fmt.Errorf("amount must be a positive number with up to two decimal places")
+// parseAmount parses a positive amount of currency from a request. The amount is a whole number of minor
+// units, or if decimalAmounts is set, a decimal in major units of the currency. Errors are a
+// models.FieldError.
+func parseAmount(amount json.Number, currency string, decimalAmounts bool) (money.Money, error) {
+	parse := money.ParseMinor
+	if decimalAmounts {
+		parse = money.ParseDecimal
 	}
-	if request.Amount <= 0 {
-		return fmt.Errorf("amount must be greater than zero")
+
+	parsed, err := parse(amount.String(), currency)
+	switch {
+	case errors.Is(err, money.ErrUnknownCurrency):
+		return money.Money{}, models.FieldError{Code: CodeInvalidCurrency, Field: "currency", Message: "invalid currency code"}
+	case errors.Is(err, money.ErrTooPrecise):
+		exponent, _ := money.Exponent(currency)
+		return money.Money{}, amountError(CodeInvalidAmount, fmt.Sprintf("amount must have up to %d decimal places for %s", exponent, currency))
+	case err != nil && decimalAmounts:
+		return money.Money{}, amountError(CodeInvalidAmount, "amount must be a positive decimal number")
+	case err != nil:
+		return money.Money{}, amountError(CodeInvalidAmount, "amount must be a positive whole number of minor units")
 	}
 
-	if !supportedCurrencies[request.Currency] {
-		return fmt.Errorf("invalid currency code")
+	if parsed.Amount == 0 {
+		return money.Money{}, amountError(CodeInvalidAmount, "amount must be greater than zero")
 	}
+	return parsed, nil
+}
 
-	return nil
+// amountError returns a models.FieldError of the amount field.
+func amountError(code, message string) models.FieldError {
+	return models.FieldError{Code: code, Field: "amount", Message: message}
 }
 
-// populateMaskedPayment returns a MaskedPayment with values from the provided request, id and status.
-func populateMaskedPayment(request models.ProcessPaymentRequest, id, status string) *models.MaskedPayment {
+// populateMaskedPayment returns a new MaskedPayment with values from the provided request, amount and id.
+// Its status is set afterwards by a transition.
+func populateMaskedPayment(request models.ProcessPaymentRequest, amount money.Money, id string) *models.MaskedPayment {
+	brand, _ := card.DetectBrand(request.CardNumber)
 	return &models.MaskedPayment{
 		ID:               id,
-		Status:           status,
 		MaskedCardNumber: maskCardNumber(request.CardNumber),
+		CardBrand:        brand,
 		ExpiryYear:       request.ExpiryYear,
 		ExpiryMonth:      request.ExpiryMonth,
-		Amount:           request.Amount,
-		Currency:         request.Currency,
+		Money:            amount,
 	}
 }
 
-// maskCardNumber returns the card number with * for all digits but the final 4, like ************XXXX.
+// maskCardNumber returns the card number with * for all digits but the final 4, like ************XXXX for
+// a 16 digit card number.
 func maskCardNumber(cardNumber string) string {
-	return "************" + cardNumber[len(cardNumber)-4:]
+	return strings.Repeat("*", len(cardNumber)-4) + cardNumber[len(cardNumber)-4:]
 }
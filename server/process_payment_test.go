@@ -2,16 +2,26 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/card"
 	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
 	"github.com/celestebrant/processout-payment-gateway/utils"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -33,11 +43,11 @@ func TestProcessPaymentHandler(t *testing.T) {
 			func(req *models.ProcessPaymentRequest) {},
 			http.StatusOK,
 			models.MaskedPayment{
-				MaskedCardNumber: "************1234",
-				ExpiryYear:       2099,
+				MaskedCardNumber: "************4242",
+				CardBrand:        card.Visa,
+				ExpiryYear:       utils.ValidProcessPaymentRequest().ExpiryYear,
 				ExpiryMonth:      12,
-				Amount:           10.05,
-				Currency:         "GBP",
+				Money:            money.New(1005, "GBP"),
 			},
 			"",
 		}, {
@@ -47,7 +57,7 @@ func TestProcessPaymentHandler(t *testing.T) {
 			},
 			http.StatusBadRequest,
 			models.MaskedPayment{},
-			"card number should have 16 digits",
+			"card number should have 12 to 19 digits",
 		},
 	}
 
@@ -65,7 +75,7 @@ func TestProcessPaymentHandler(t *testing.T) {
 			request.Header.Set("Content-Type", "application/json")
 			response := httptest.NewRecorder()
 
-			ProcessPaymentHandler(response, request)
+			newTestServer(t).ProcessPaymentHandler(response, asTestMerchant(request))
 			defer response.Result().Body.Close()
 
 			r.Equal(tc.expectedStatusCode, response.Result().StatusCode)
@@ -77,14 +87,15 @@ func TestProcessPaymentHandler(t *testing.T) {
 				r.NoError(err, "failed to unmarshal response")
 
 				expected := models.MaskedPayment{
+					MerchantID:       testMerchantID,
 					MaskedCardNumber: tc.expectedMaskedPayment.MaskedCardNumber,
+					CardBrand:        tc.expectedMaskedPayment.CardBrand,
 					ExpiryYear:       tc.expectedMaskedPayment.ExpiryYear,
 					ExpiryMonth:      tc.expectedMaskedPayment.ExpiryMonth,
-					Amount:           tc.expectedMaskedPayment.Amount,
-					Currency:         tc.expectedMaskedPayment.Currency,
+					Money:            tc.expectedMaskedPayment.Money,
 				}
 
-				if diff := cmp.Diff(expected, maskedPayment, cmpopts.IgnoreFields(models.MaskedPayment{}, "ID", "Status")); diff != "" {
+				if diff := cmp.Diff(expected, maskedPayment, cmpopts.IgnoreFields(models.MaskedPayment{}, "ID", "Status", "CapturedAmount", "CreatedAt")); diff != "" {
 					t.Errorf("Payment mismatch (-expected +got):\n%s", diff)
 				}
 				r.NotEmpty(maskedPayment.ID)
@@ -95,14 +106,162 @@ func TestProcessPaymentHandler(t *testing.T) {
 
 			} else {
 				// Negative response: should contain an error
-				responseBody, err := io.ReadAll(response.Body)
-				r.NoError(err, "failed to read response body")
-				r.Equal(tc.expectedErrorMessage, string(bytes.TrimSpace(responseBody)))
+				errorResponse := models.ErrorResponse{}
+				r.NoError(json.NewDecoder(response.Body).Decode(&errorResponse), "failed to unmarshal error response")
+				r.Equal(tc.expectedErrorMessage, errorResponse.Message)
 			}
 		})
 	}
 }
 
+func TestProcessPaymentHandlerIdempotency(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t)
+
+	// doRequest sends a process payment request with the given idempotency key and returns the response.
+	doRequest := func(t *testing.T, key string, request *models.ProcessPaymentRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(request)
+		require.NoError(t, err, "failed to marshal request")
+
+		httpRequest := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body))
+		httpRequest.Header.Set("Content-Type", "application/json")
+		httpRequest.Header.Set(IdempotencyKeyHeader, key)
+		response := httptest.NewRecorder()
+		s.ProcessPaymentHandler(response, asTestMerchant(httpRequest))
+		return response
+	}
+
+	t.Run("repeated request replays stored payment", func(t *testing.T) {
+		r := require.New(t)
+		key := uuid.New().String()
+
+		first := doRequest(t, key, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, first.Code)
+		r.Empty(first.Header().Get(IdempotentReplayedHeader))
+
+		second := doRequest(t, key, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, second.Code)
+		r.Equal("true", second.Header().Get(IdempotentReplayedHeader))
+		r.JSONEq(first.Body.String(), second.Body.String(), "replayed payment should match the original")
+	})
+
+	t.Run("key reused with different body returns 409 error response", func(t *testing.T) {
+		r := require.New(t)
+		key := uuid.New().String()
+
+		first := doRequest(t, key, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, first.Code)
+
+		modified := utils.ValidProcessPaymentRequest()
+		modified.Amount = "2000"
+		second := doRequest(t, key, modified)
+		r.Equal(http.StatusConflict, second.Code)
+		r.Equal(errIdempotencyKeyReused.Error(), decodeError(t, second).Message)
+	})
+
+	t.Run("key too long returns 400 error response", func(t *testing.T) {
+		r := require.New(t)
+		response := doRequest(t, strings.Repeat("k", maxIdempotencyKeyLength+1), utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusBadRequest, response.Code)
+	})
+}
+
+// contextBank is a fakeBank that records the context of the last payment it was asked to make, and whether
+// it was done when the payment was made.
+type contextBank struct {
+	fakeBank
+	ctx    context.Context
+	ctxErr error
+}
+
+func (b *contextBank) MakePayment(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	b.ctx, b.ctxErr = ctx, ctx.Err()
+	return b.fakeBank.MakePayment(ctx, r)
+}
+
+func TestProcessPaymentHandlerIdempotencyAfterBankError(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name              string
+		err               error
+		expectedRetryCode string
+	}
+
+	testCases := []testCase{
+		{"bank not reached", fmt.Errorf("failed to call the bank, %w: connection refused", bank.ErrNotSent), CodeBankError},
+		{"bank outcome unknown", errors.New("failed to call the bank: timeout"), CodeIdempotencyKeyFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, &brokenBank{err: tc.err})
+
+			doRequest := func() *httptest.ResponseRecorder {
+				body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+				r.NoError(err)
+				request := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body))
+				request.Header.Set("Authorization", "Bearer "+testAPIKey)
+				request.Header.Set(IdempotencyKeyHeader, "key")
+				response := httptest.NewRecorder()
+				s.Routes().ServeHTTP(response, request)
+				return response
+			}
+
+			first := doRequest()
+			r.Equal(http.StatusBadGateway, first.Code)
+			r.Equal(CodeBankError, decodeError(t, first).Code)
+			r.Equal(tc.expectedRetryCode, decodeError(t, doRequest()).Code)
+		})
+	}
+}
+
+func TestProcessPaymentHandlerIdempotencyAfterPanic(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	s := newTestServerWithBank(t, &brokenBank{})
+	body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+	r.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+testAPIKey)
+		request.Header.Set(IdempotencyKeyHeader, "key")
+		response := httptest.NewRecorder()
+		s.Routes().ServeHTTP(response, request)
+		r.Equal(http.StatusInternalServerError, response.Code)
+		r.Equal(CodeInternalError, decodeError(t, response).Code, "the key should not be left in progress")
+	}
+}
+
+func TestProcessPaymentBankCallOutlivesRequest(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	acquirer := &contextBank{}
+	s := newTestServerWithBank(t, acquirer)
+	body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+	r.NoError(err)
+
+	// The client has gone away by the time the bank is called
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body)).WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+testAPIKey)
+	response := httptest.NewRecorder()
+	s.Routes().ServeHTTP(response, request)
+	r.Equal(http.StatusOK, response.Code)
+
+	r.NoError(acquirer.ctxErr, "the bank call should not be cancelled with the request")
+	deadline, ok := acquirer.ctx.Deadline()
+	r.True(ok, "the bank call should have its own timeout")
+	r.WithinDuration(time.Now().Add(bankCallTimeout), deadline, time.Minute)
+	r.Equal(RequestIDFromContext(acquirer.ctx), response.Header().Get(RequestIDHeader))
+}
+
 func TestValidateProcessPaymentRequest(t *testing.T) {
 	t.Parallel()
 
@@ -120,27 +279,71 @@ func TestValidateProcessPaymentRequest(t *testing.T) {
 		}, {
 			"card number too short returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.CardNumber = "123412341234123" // 15 digits
+				req.CardNumber = "42424242424" // 11 digits
 			},
-			"card number should have 16 digits",
+			"card number should have 12 to 19 digits",
 		}, {
 			"card number too long returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.CardNumber = "12341234123412345" // 17 digits
+				req.CardNumber = "42424242424242424242" // 20 digits
 			},
-			"card number should have 16 digits",
+			"card number should have 12 to 19 digits",
 		}, {
 			"card number alphanumeric returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.CardNumber = "a234123412341234"
+				req.CardNumber = "a242424242424242"
 			},
-			"card number should have 16 digits",
+			"card number should have 12 to 19 digits",
 		}, {
 			"card number special characters returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.CardNumber = " 234123412341234"
+				req.CardNumber = " 242424242424242"
 			},
-			"card number should have 16 digits",
+			"card number should have 12 to 19 digits",
+		}, {
+			"card number failing luhn check returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "4242424242424241"
+			},
+			"card number is invalid",
+		}, {
+			"card number of unknown brand returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "9999999999999995"
+			},
+			"card brand is not supported",
+		}, {
+			"amex 15 digits with 4 digit cvv",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "378282246310005"
+				req.CVV = "1234"
+			},
+			"",
+		}, {
+			"amex 16 digits returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "3782822463100005"
+				req.CVV = "1234"
+			},
+			"card number should have 15 digits for amex",
+		}, {
+			"visa 17 digits returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "42424242424242424"
+			},
+			"card number should have 13, 16 or 19 digits for visa",
+		}, {
+			"maestro 19 digits",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "6759000000000000005"
+			},
+			"",
+		}, {
+			"unionpay 19 digits",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "6200000000000000000"
+			},
+			"",
 		}, {
 			"expiry year 3 digits returns error",
 			func(req *models.ProcessPaymentRequest) {
@@ -148,17 +351,17 @@ func TestValidateProcessPaymentRequest(t *testing.T) {
 			},
 			"expiry year should have 4 digits",
 		}, {
-			"expiry year 4 digits lower bound",
+			"expiry year 4 digits in the past returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.ExpiryYear = 1000
+				req.ExpiryYear = 1999
 			},
-			"",
+			"card has expired",
 		}, {
-			"expiry year 4 digits upper bound",
+			"expiry year 4 digits too far ahead returns error",
 			func(req *models.ProcessPaymentRequest) {
 				req.ExpiryYear = 9999
 			},
-			"",
+			"expiry year should be no more than 20 years from now",
 		}, {
 			"expiry year 5 digits returns error",
 			func(req *models.ProcessPaymentRequest) {
@@ -207,6 +410,13 @@ func TestValidateProcessPaymentRequest(t *testing.T) {
 				req.CVV = "a12"
 			},
 			"cvv should have 3 digits",
+		}, {
+			"amex CVV with 3 digits returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.CardNumber = "378282246310005"
+				req.CVV = "123"
+			},
+			"cvv should have 4 digits for amex",
 		}, {
 			"CVV special characters returns error",
 			func(req *models.ProcessPaymentRequest) {
@@ -216,38 +426,56 @@ func TestValidateProcessPaymentRequest(t *testing.T) {
 		}, {
 			"amount 0 returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.Amount = 0
+				req.Amount = "0"
 			},
 			"amount must be greater than zero",
 		}, {
-			"amount one decimal place",
+			"amount 1 minor unit",
 			func(req *models.ProcessPaymentRequest) {
-				req.Amount = 0.1
+				req.Amount = "1"
 			},
 			"",
 		}, {
-			"amount two decimal places",
+			"amount decimal returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.Amount = 0.01
+				req.Amount = "10.05"
 			},
-			"",
+			"amount must be a positive whole number of minor units",
 		}, {
-			"amount more than two decimal places returns error",
+			"amount negative returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.Amount = 0.009
+				req.Amount = "-1"
 			},
-			"amount must have up to two decimal places",
+			"amount must be a positive whole number of minor units",
 		}, {
-			"amount negative returns error",
+			"amount missing returns error",
 			func(req *models.ProcessPaymentRequest) {
-				req.Amount = -0.01
+				req.Amount = ""
 			},
-			"amount must be a positive number with up to two decimal places",
+			"amount must be a positive whole number of minor units",
 		}, {
-			"currency unsupported returns error",
+			"currency USD",
 			func(req *models.ProcessPaymentRequest) {
 				req.Currency = "USD"
 			},
+			"",
+		}, {
+			"currency JPY with no minor unit",
+			func(req *models.ProcessPaymentRequest) {
+				req.Currency = "JPY"
+			},
+			"",
+		}, {
+			"currency unknown returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.Currency = "XYZ"
+			},
+			"invalid currency code",
+		}, {
+			"currency lower case returns error",
+			func(req *models.ProcessPaymentRequest) {
+				req.Currency = "gbp"
+			},
 			"invalid currency code",
 		},
 	}
@@ -257,7 +485,7 @@ func TestValidateProcessPaymentRequest(t *testing.T) {
 			r := require.New(t)
 			req := utils.ValidProcessPaymentRequest()
 			tc.modifyRequest(req)
-			err := validateProcessPaymentRequest(*req)
+			_, err := validateProcessPaymentRequest(*req, false, time.Now())
 
 			if tc.expectedErrorMessage == "" {
 				// Positive scenarios
@@ -270,10 +498,167 @@ func TestValidateProcessPaymentRequest(t *testing.T) {
 	}
 }
 
+func TestValidateExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, time.June, 30, 23, 59, 0, 0, time.UTC)
+
+	type testCase struct {
+		name          string
+		year, month   uint
+		now           time.Time
+		expectedError *models.FieldError
+	}
+
+	expired := func(field string) *models.FieldError {
+		return &models.FieldError{Code: CodeCardExpired, Field: field, Message: "card has expired"}
+	}
+
+	testCases := []testCase{
+		{"expires this month", 2026, 6, now, nil},
+		{"expires next month", 2026, 7, now, nil},
+		{"expired last month", 2026, 5, now, expired("expiry_month")},
+		{"expired last year", 2025, 12, now, expired("expiry_year")},
+		{"valid until the month has passed in UTC", 2026, 6, time.Date(2026, time.July, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), nil},
+		{"expired at the start of the next month in UTC", 2026, 6, time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC), expired("expiry_month")},
+		{"20 years ahead", 2046, 12, now, nil},
+		{"more than 20 years ahead", 2047, 1, now, &models.FieldError{
+			Code:    CodeInvalidExpiryYear,
+			Field:   "expiry_year",
+			Message: "expiry year should be no more than 20 years from now",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedError, validateExpiry(tc.year, tc.month, tc.now))
+		})
+	}
+}
+
+func TestProcessPaymentHandlerExpiredCard(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	s := New(Config{
+		Store:     NewMemoryStore(),
+		Merchants: newTestStore(),
+		Bank:      &fakeBank{},
+		APIKeys:   newTestAPIKeys(),
+		Clock:     newFakeClock(time.Date(2031, time.March, 1, 0, 0, 0, 0, time.UTC)),
+		Logger:    slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	})
+
+	request := utils.ValidProcessPaymentRequest()
+	request.ExpiryYear, request.ExpiryMonth = 2031, 2
+	response := serve(t, s, "POST", utils.Path, request)
+	r.Equal(http.StatusBadRequest, response.Code)
+	errorResponse := decodeError(t, response)
+	r.Equal(CodeValidationFailed, errorResponse.Code)
+	r.Equal([]models.FieldError{{Code: CodeCardExpired, Message: "card has expired", Field: "expiry_month"}}, errorResponse.Errors)
+
+	request.ExpiryMonth = 3
+	response = serve(t, s, "POST", utils.Path, request)
+	r.Equal(http.StatusOK, response.Code, "card should be valid until the end of its expiry month")
+}
+
+func TestValidateProcessPaymentRequestReportsAllErrors(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	request := utils.ValidProcessPaymentRequest()
+	request.CardNumber = "4242424242424241"
+	request.ExpiryMonth = 13
+	request.CVV = "12"
+	request.Amount = "-1"
+	_, err := validateProcessPaymentRequest(*request, false, time.Now())
+
+	var errs validationErrors
+	r.ErrorAs(err, &errs)
+	r.Equal(validationErrors{
+		{Code: CodeInvalidCardNumber, Field: "card_number", Message: "card number is invalid"},
+		{Code: CodeInvalidExpiryMonth, Field: "expiry_month", Message: "expiry month should have value of 1 to 12"},
+		{Code: CodeInvalidCVV, Field: "cvv", Message: "cvv should have 3 digits for visa"},
+		{Code: CodeInvalidAmount, Field: "amount", Message: "amount must be a positive whole number of minor units"},
+	}, errs)
+}
+
+func TestValidateProcessPaymentRequestReportsCVVOfUnknownBrand(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	request := utils.ValidProcessPaymentRequest()
+	request.CardNumber = "42"
+	request.CVV = "abc"
+	_, err := validateProcessPaymentRequest(*request, false, time.Now())
+
+	var errs validationErrors
+	r.ErrorAs(err, &errs)
+	r.Equal(validationErrors{
+		{Code: CodeInvalidCardNumber, Field: "card_number", Message: "card number should have 12 to 19 digits"},
+		{Code: CodeInvalidCVV, Field: "cvv", Message: "cvv should have 3 or 4 digits"},
+	}, errs)
+}
+
+func TestValidateProcessPaymentRequestDecimalAmounts(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name                 string
+		amount               json.Number
+		expectedAmount       money.Money
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{"two decimal places", "10.05", money.New(1005, "GBP"), ""},
+		{"one decimal place", "0.1", money.New(10, "GBP"), ""},
+		{"whole number", "12", money.New(1200, "GBP"), ""},
+		{"more than two decimal places returns error", "0.009", money.Money{}, "amount must have up to 2 decimal places for GBP"},
+		{"negative returns error", "-0.01", money.Money{}, "amount must be a positive decimal number"},
+		{"0 returns error", "0.00", money.Money{}, "amount must be greater than zero"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			req := utils.ValidProcessPaymentRequest()
+			req.Amount = tc.amount
+			amount, err := validateProcessPaymentRequest(*req, true, time.Now())
+
+			if tc.expectedErrorMessage == "" {
+				r.NoError(err)
+				r.Equal(tc.expectedAmount, amount)
+			} else {
+				r.ErrorContains(err, tc.expectedErrorMessage)
+			}
+		})
+	}
+}
+
+func TestProcessPaymentHandlerDecimalAmounts(t *testing.T) {
+	r := require.New(t)
+	s := New(Config{
+		Store:          NewMemoryStore(),
+		Merchants:      newTestStore(),
+		Bank:           &fakeBank{},
+		APIKeys:        newTestAPIKeys(),
+		Logger:         newTestServer(t).logger,
+		DecimalAmounts: true,
+	})
+
+	request := utils.ValidProcessPaymentRequest()
+	request.Amount = "10.05"
+	response := serve(t, s, "POST", utils.Path, request)
+	r.Equal(http.StatusOK, response.Code)
+	r.Equal(money.New(1005, "GBP"), decodePayment(t, response).Money, "response should be in minor units")
+}
+
 func TestMaskCardNumber(t *testing.T) {
 	r := require.New(t)
-	masked := maskCardNumber("1234123412341234")
-	r.Equal("************1234", masked)
+	r.Equal("************1234", maskCardNumber("1234123412341234"))
+	r.Equal("***********0005", maskCardNumber("378282246310005"))
+	r.Equal("***************0000", maskCardNumber("6200000000000000000"))
 }
 
 func TestPopulateMaskedPayment(t *testing.T) {
@@ -281,14 +666,16 @@ func TestPopulateMaskedPayment(t *testing.T) {
 
 	request := utils.ValidProcessPaymentRequest()
 	id := "some-id"
-	status := "some-status"
 
-	maskedPayment := populateMaskedPayment(*request, id, status)
+	amount := money.New(1005, "GBP")
+
+	maskedPayment := populateMaskedPayment(*request, amount, id)
 	a.Equal(id, maskedPayment.ID)
-	a.Equal(status, maskedPayment.Status)
-	a.Equal("************1234", maskedPayment.MaskedCardNumber)
+	a.Equal(models.StatusNew, maskedPayment.Status, "status should be set by a transition")
+	a.Equal("************4242", maskedPayment.MaskedCardNumber)
+	a.Equal(card.Visa, maskedPayment.CardBrand)
 	a.Equal(request.ExpiryYear, maskedPayment.ExpiryYear)
 	a.Equal(request.ExpiryMonth, maskedPayment.ExpiryMonth)
-	a.Equal(request.Amount, maskedPayment.Amount)
-	a.Equal(request.Currency, maskedPayment.Currency)
+	a.Equal(amount, maskedPayment.Money)
+	a.Zero(maskedPayment.CapturedAmount)
 }
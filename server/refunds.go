@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/gorilla/mux"
+)
+
+const maxRefundReasonLength = 255
+
+/*
+CreateRefundHandler handles refunding some or all of a captured payment. A payment can be refunded several
+times, as long as the total refunded never exceeds the captured amount. Each successful refund moves the
+payment to status PARTIALLY_REFUNDED, or REFUNDED once the whole captured amount has been refunded.
+
+A refund that the bank does not accept is still recorded, with status FAILED, and does not count towards
+the refunded amount.
+
+If the request has an Idempotency-Key header, a repeat of a previous request for the same payment with the
+same key and body replays the stored refund instead of calling the bank again, as for payments, until the
+server restarts. The call to the bank is not cancelled with the request, and a failed call returns a http
+502 error response.
+*/
+func (s *Server) CreateRefundHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := validatePaymentID(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, r, readError(err, "failed to read the request"))
+		return
+	}
+	request := models.CreateRefundRequest{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		s.writeError(w, r, newError(http.StatusBadRequest, CodeMalformedRequest, "failed to unmarshal the request"))
+		return
+	}
+	var errs validationErrors
+	if len(request.Reason) > maxRefundReasonLength {
+		errs = append(errs, models.FieldError{
+			Code:    CodeInvalidReason,
+			Field:   "reason",
+			Message: fmt.Sprintf("reason should have up to %d characters", maxRefundReasonLength),
+		})
+	}
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		errs = append(errs, models.FieldError{
+			Code:    CodeInvalidIdempotencyKey,
+			Field:   IdempotencyKeyHeader,
+			Message: fmt.Sprintf("idempotency key should have up to %d characters", maxIdempotencyKeyLength),
+		})
+	}
+	if len(errs) > 0 {
+		s.writeError(w, r, errs)
+		return
+	}
+
+	if idempotencyKey != "" {
+		// Keys share the merchant's scope with payment keys, and the hash covers the payment ID, so that a key
+		// reused for another payment or for a payment request is rejected rather than replayed
+		idempotencyKey = MerchantIDFromContext(r.Context()) + " " + idempotencyKey
+		stored, err := s.idempotency.Begin(idempotencyKey, hashRequestBody(append([]byte(id+" "), body...)))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if stored != nil {
+			s.logger.InfoContext(r.Context(), "Replayed refund")
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(stored.(*models.Refund))
+			return
+		}
+		// Forget the key if the request ends before reaching the bank, so that it can be retried
+		defer s.idempotency.Release(idempotencyKey)
+	}
+
+	unlock := s.paymentLocks.Lock(id)
+	defer unlock()
+
+	maskedPayment, ok := s.fetchPayment(w, r, id)
+	if !ok {
+		return
+	}
+
+	if !maskedPayment.Status.CanTransitionTo(models.StatusPartiallyRefunded) {
+		s.writeError(w, r, newError(http.StatusConflict, CodeInvalidPaymentStatus, fmt.Sprintf("payment with status %s cannot be refunded", maskedPayment.Status)))
+		return
+	}
+
+	amount, err := parseAmount(request.Amount, maskedPayment.Currency, s.decimalAmounts)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	remaining := money.New(maskedPayment.CapturedAmount-maskedPayment.RefundedAmount, maskedPayment.Currency)
+	if amount.Amount > remaining.Amount {
+		s.writeError(w, r, amountError(CodeAmountTooLarge, fmt.Sprintf("refund amount must not exceed the remaining captured amount of %s", remaining)))
+		return
+	}
+
+	refund, err := s.refund(r.Context(), maskedPayment, amount, request.Reason)
+	if err != nil {
+		if idempotencyKey != "" && !errors.Is(err, errBankNotReached) {
+			// The bank may have refunded the payment, so a retry with the key must not refund it again
+			s.idempotency.Fail(idempotencyKey)
+		}
+		s.writeError(w, r, err)
+		return
+	}
+	if idempotencyKey != "" {
+		s.idempotency.Complete(idempotencyKey, refund)
+	}
+	s.logger.InfoContext(r.Context(), "Created refund", "refund_id", refund.ID, "amount", refund.Money.String(), "status", refund.Status)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(refund)
+}
+
+// refund asks the bank to refund amount with the given reason from maskedPayment, which must be locked, then
+// stores and returns the refund, and stores the payment if the refund succeeded.
+func (s *Server) refund(ctx context.Context, maskedPayment *models.MaskedPayment, amount money.Money, reason string) (*models.Refund, error) {
+	// The bank call outlives the request, since a client that disconnects part way through a refund could
+	// otherwise leave the money returned without the refund being stored
+	bankCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bankCallTimeout)
+	defer cancel()
+	bankResponse, err := s.bank.Refund(bankCtx, bank.RefundRequest{PaymentID: maskedPayment.ID, Amount: amount.Amount})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to refund payment with the bank", "error", err)
+		if errors.Is(err, bank.ErrNotSent) {
+			return nil, errBankNotReached
+		}
+		return nil, newError(http.StatusBadGateway, CodeBankError, "unexpected error from call to the bank")
+	}
+	if bankResponse == nil {
+		s.logger.ErrorContext(ctx, "Received no refund response from the bank")
+		return nil, newError(http.StatusBadGateway, CodeBankError, "failed to receive a response from the bank")
+	}
+
+	refund := &models.Refund{
+		ID:        bankResponse.RefundID,
+		PaymentID: maskedPayment.ID,
+		Status:    models.RefundFailed,
+		Money:     amount,
+		Reason:    reason,
+		CreatedAt: s.clock.Now().UTC(),
+	}
+	if models.RefundStatus(bankResponse.Status) == models.RefundSucceeded {
+		refund.Status = models.RefundSucceeded
+	}
+	if err := s.store.AddRefund(refund); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to store refund", "refund_id", refund.ID, "error", err)
+		return nil, newError(http.StatusInternalServerError, CodeInternalError, "failed to store the refund")
+	}
+	s.publish(maskedPayment.MerchantID, models.EventRefundCreated, refund)
+
+	if refund.Status == models.RefundSucceeded {
+		maskedPayment.RefundedAmount += refund.Amount
+		status := models.StatusPartiallyRefunded
+		if maskedPayment.RefundedAmount == maskedPayment.CapturedAmount {
+			status = models.StatusRefunded
+		}
+		description := fmt.Sprintf("refunded %s in refund %s", refund.Money, refund.ID)
+		if refund.Reason != "" {
+			description += ": " + refund.Reason
+		}
+		if err := s.transition(ctx, maskedPayment, status, description); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to store refunded payment", "error", err)
+			return nil, newError(http.StatusInternalServerError, CodeInternalError, "failed to store the payment")
+		}
+	}
+	return refund, nil
+}
+
+// ListRefundsHandler handles listing the refunds of a payment, oldest first.
+func (s *Server) ListRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := validatePaymentID(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if _, ok := s.fetchPayment(w, r, id); !ok {
+		return
+	}
+
+	refunds, err := s.store.ListRefunds(id)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to fetch refunds", "error", err)
+		s.writeError(w, r, newError(http.StatusInternalServerError, CodeInternalError, "failed to fetch the refunds"))
+		return
+	}
+
+	json.NewEncoder(w).Encode(refunds)
+}
+
+// fetchPayment fetches the payment with the given ID from the store. If it cannot, or the payment belongs to
+// a different merchant than the one making request r, it writes an error response to r and returns false.
+func (s *Server) fetchPayment(w http.ResponseWriter, r *http.Request, id string) (*models.MaskedPayment, bool) {
+	maskedPayment, err := s.getPayment(r.Context(), id)
+	if errors.Is(err, ErrPaymentNotFound) || err == nil && maskedPayment.MerchantID != MerchantIDFromContext(r.Context()) {
+		// Other merchants' payments are not found, rather than forbidden, so that their IDs are not revealed
+		s.writeError(w, r, newError(http.StatusNotFound, CodePaymentNotFound, "payment not found"))
+		return nil, false
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to fetch payment", "error", err)
+		s.writeError(w, r, newError(http.StatusInternalServerError, CodeInternalError, "failed to fetch the payment"))
+		return nil, false
+	}
+	return maskedPayment, true
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRefundHandler(t *testing.T) {
+	t.Parallel()
+
+	// createPayment processes a valid payment of 10.05, captured unless capture is false.
+	createPayment := func(t *testing.T, s *Server, capture bool) models.MaskedPayment {
+		request := utils.ValidProcessPaymentRequest()
+		request.Capture = &capture
+		response := serve(t, s, "POST", utils.Path, request)
+		require.Equal(t, http.StatusOK, response.Code)
+		return decodePayment(t, response)
+	}
+
+	// refund refunds amount from the payment with the given ID.
+	refund := func(t *testing.T, s *Server, id string, amount json.Number) (int, string) {
+		response := serve(t, s, "POST", utils.Path+"/"+id+"/refunds", models.CreateRefundRequest{
+			Amount: amount,
+			Reason: "returned goods",
+		})
+		if response.Code != http.StatusCreated {
+			return response.Code, decodeError(t, response).Message
+		}
+		return response.Code, strings.TrimSpace(response.Body.String())
+	}
+
+	t.Run("multiple partial refunds up to the captured amount", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		payment := createPayment(t, s, true)
+
+		code, body := refund(t, s, payment.ID, "502")
+		r.Equal(http.StatusCreated, code)
+		created := models.Refund{}
+		r.NoError(json.Unmarshal([]byte(body), &created))
+		r.NotEmpty(created.ID)
+		r.Equal(payment.ID, created.PaymentID)
+		r.Equal(models.RefundSucceeded, created.Status)
+		r.Equal(money.New(502, "GBP"), created.Money)
+		r.Equal("returned goods", created.Reason)
+
+		response := serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+		partiallyRefunded := decodePayment(t, response)
+		r.Equal(models.StatusPartiallyRefunded, partiallyRefunded.Status)
+		r.Equal(int64(502), partiallyRefunded.RefundedAmount)
+
+		code, body = refund(t, s, payment.ID, "504")
+		r.Equal(http.StatusBadRequest, code)
+		r.Equal("refund amount must not exceed the remaining captured amount of 5.03 GBP", body)
+
+		code, _ = refund(t, s, payment.ID, "503")
+		r.Equal(http.StatusCreated, code)
+
+		response = serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+		refunded := decodePayment(t, response)
+		r.Equal(models.StatusRefunded, refunded.Status)
+		r.Equal(int64(1005), refunded.RefundedAmount)
+
+		code, body = refund(t, s, payment.ID, "1")
+		r.Equal(http.StatusConflict, code)
+		r.Equal("payment with status REFUNDED cannot be refunded", body)
+
+		response = serve(t, s, "GET", utils.Path+"/"+payment.ID+"/refunds", nil)
+		r.Equal(http.StatusOK, response.Code)
+		refunds := []models.Refund{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&refunds))
+		r.Len(refunds, 2)
+		r.Equal(created, refunds[0])
+		r.Equal(int64(503), refunds[1].Amount)
+	})
+
+	t.Run("refund rejected by the bank is recorded as failed", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{failRefunds: true})
+		payment := createPayment(t, s, true)
+
+		code, body := refund(t, s, payment.ID, "500")
+		r.Equal(http.StatusCreated, code)
+		created := models.Refund{}
+		r.NoError(json.Unmarshal([]byte(body), &created))
+		r.Equal(models.RefundFailed, created.Status)
+
+		response := serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+		unchanged := decodePayment(t, response)
+		r.Equal(models.StatusSuccess, unchanged.Status)
+		r.Zero(unchanged.RefundedAmount)
+	})
+
+	t.Run("uncaptured payment cannot be refunded", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		payment := createPayment(t, s, false)
+
+		code, body := refund(t, s, payment.ID, "500")
+		r.Equal(http.StatusConflict, code)
+		r.Equal("payment with status AUTHORIZED cannot be refunded", body)
+	})
+
+	t.Run("invalid amount returns 400 error response", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		payment := createPayment(t, s, true)
+
+		code, body := refund(t, s, payment.ID, "0")
+		r.Equal(http.StatusBadRequest, code)
+		r.Equal("amount must be greater than zero", body)
+	})
+
+	t.Run("nonexistent payment returns 404 error response", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		code, _ := refund(t, s, uuid.New().String(), "500")
+		r.Equal(http.StatusNotFound, code)
+
+		response := serve(t, s, "GET", utils.Path+"/"+uuid.New().String()+"/refunds", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+	})
+
+	t.Run("payment without refunds lists none", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		payment := createPayment(t, s, true)
+
+		response := serve(t, s, "GET", utils.Path+"/"+payment.ID+"/refunds", nil)
+		r.Equal(http.StatusOK, response.Code)
+		r.JSONEq("[]", response.Body.String())
+	})
+}
+
+// refundErrorBank is a fakeBank that counts refunds, and fails them with err if it is set. It records the
+// error of the context of the last refund.
+type refundErrorBank struct {
+	fakeBank
+	err     error
+	mu      sync.Mutex
+	refunds int
+	ctxErr  error
+}
+
+func (b *refundErrorBank) Refund(ctx context.Context, r bank.RefundRequest) (*bank.RefundResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refunds++
+	b.ctxErr = ctx.Err()
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.fakeBank.Refund(ctx, r)
+}
+
+func TestCreateRefundHandlerIdempotency(t *testing.T) {
+	t.Parallel()
+
+	// createPayment processes a valid captured payment of 10.05.
+	createPayment := func(t *testing.T, s *Server) models.MaskedPayment {
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		require.Equal(t, http.StatusOK, response.Code)
+		return decodePayment(t, response)
+	}
+
+	// refund refunds 5.00 from the payment with the given ID, with the given idempotency key.
+	refund := func(t *testing.T, s *Server, id, key string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(models.CreateRefundRequest{Amount: "500"})
+		require.NoError(t, err)
+		request := httptest.NewRequest("POST", utils.Path+"/"+id+"/refunds", bytes.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+testAPIKey)
+		request.Header.Set(IdempotencyKeyHeader, key)
+		response := httptest.NewRecorder()
+		s.Routes().ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("repeated request replays the stored refund", func(t *testing.T) {
+		r := require.New(t)
+		b := &refundErrorBank{}
+		s := newTestServerWithBank(t, b)
+		payment := createPayment(t, s)
+
+		first := refund(t, s, payment.ID, "key")
+		r.Equal(http.StatusCreated, first.Code)
+		second := refund(t, s, payment.ID, "key")
+		r.Equal(http.StatusCreated, second.Code)
+		r.Equal("true", second.Header().Get(IdempotentReplayedHeader))
+		r.JSONEq(first.Body.String(), second.Body.String())
+		r.Equal(1, b.refunds)
+
+		response := serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+		r.Equal(int64(500), decodePayment(t, response).RefundedAmount)
+	})
+
+	t.Run("key reused for another payment returns 409 error response", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &refundErrorBank{})
+
+		r.Equal(http.StatusCreated, refund(t, s, createPayment(t, s).ID, "key").Code)
+		response := refund(t, s, createPayment(t, s).ID, "key")
+		r.Equal(http.StatusConflict, response.Code)
+		r.Equal(CodeIdempotencyKeyReused, decodeError(t, response).Code)
+	})
+
+	type testCase struct {
+		name              string
+		err               error
+		expectedRetryCode int
+	}
+
+	testCases := []testCase{
+		{"bank not reached", fmt.Errorf("failed to call the bank, %w: connection refused", bank.ErrNotSent), http.StatusBadGateway},
+		{"bank outcome unknown", errors.New("failed to call the bank: timeout"), http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			b := &refundErrorBank{}
+			s := newTestServerWithBank(t, b)
+			payment := createPayment(t, s)
+			b.err = tc.err
+
+			first := refund(t, s, payment.ID, "key")
+			r.Equal(http.StatusBadGateway, first.Code)
+			r.Equal(CodeBankError, decodeError(t, first).Code)
+			r.Equal(tc.expectedRetryCode, refund(t, s, payment.ID, "key").Code)
+		})
+	}
+
+	t.Run("bank call is not cancelled with the request", func(t *testing.T) {
+		r := require.New(t)
+		b := &refundErrorBank{}
+		s := newTestServerWithBank(t, b)
+		payment := createPayment(t, s)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		request := httptest.NewRequest("POST", utils.Path+"/"+payment.ID+"/refunds", strings.NewReader(`{"amount":"500"}`))
+		request.Header.Set("Authorization", "Bearer "+testAPIKey)
+		response := httptest.NewRecorder()
+		s.Routes().ServeHTTP(response, request.WithContext(ctx))
+
+		r.Equal(http.StatusCreated, response.Code)
+		r.NoError(b.ctxErr)
+	})
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/logging"
+	"github.com/celestebrant/processout-payment-gateway/metrics"
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/gorilla/mux"
+)
+
+// Clock tells the current time. It is injected so that tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is a Clock that reads the system time.
+var SystemClock Clock = systemClock{}
+
+// Config holds the dependencies and settings a Server is built from.
+type Config struct {
+	// Store is where payments are stored and fetched from. Required.
+	Store PaymentStore
+	// Merchants is where merchants are stored and fetched from. Required.
+	Merchants MerchantStore
+	// Bank is the acquiring bank that payments are sent to. Required.
+	Bank bank.Acquirer
+	// APIKeys are the keys that merchants authenticate with. Required.
+	APIKeys *APIKeyStore
+	// Webhooks is where webhook endpoints and the outbox of their deliveries are stored. If nil, there are no
+	// webhook endpoints and no events are sent.
+	Webhooks WebhookStore
+	// WebhookClient sends webhook deliveries. It defaults to a client with DefaultWebhookTimeout that does
+	// not follow redirects or connect to non-public addresses.
+	WebhookClient *http.Client
+	// WebhookAllowPrivateAddresses lets the default WebhookClient connect to loopback, link-local and private
+	// addresses, which is only meant for tests and local development.
+	WebhookAllowPrivateAddresses bool
+	// WebhookRetryBackoff defaults to DefaultWebhookRetryBackoff, and doubles with every failed attempt.
+	WebhookRetryBackoff time.Duration
+	// WebhookMaxAttempts defaults to DefaultWebhookMaxAttempts.
+	WebhookMaxAttempts int
+	// WebhookEndpointConcurrency is how many deliveries to one endpoint are sent at once. It defaults to
+	// DefaultWebhookEndpointConcurrency.
+	WebhookEndpointConcurrency int
+	// AdminKeys are the keys that administrators authenticate with to manage merchants. If nil, there are
+	// no admin endpoints.
+	AdminKeys *APIKeyStore
+	// SigningSecrets are the secrets that merchants sign requests with. If set, creating and fetching a
+	// payment require a valid signature, and otherwise signatures are not checked.
+	SigningSecrets *SigningSecrets
+	// SignatureTolerance defaults to signing.DefaultTolerance.
+	SignatureTolerance time.Duration
+	// Clock defaults to SystemClock.
+	Clock Clock
+	// Logger defaults to JSON logs on stderr. Whatever its handler, the server masks card numbers and CVVs
+	// in its logs, and adds the request ID, route, merchant ID and payment ID of the request being handled.
+	Logger *slog.Logger
+	// Metrics is where the server's metrics are registered, and defaults to a new registry. A registry can
+	// only be used by one server.
+	Metrics *metrics.Registry
+	// Tracer traces payments through validation, the bank and the store, and defaults to a tracer that
+	// discards spans. The trace context is passed on to the bank either way.
+	Tracer *tracing.Tracer
+	// IdempotencyKeyRetention defaults to DefaultIdempotencyKeyRetention.
+	IdempotencyKeyRetention time.Duration
+	// DecimalAmounts is a compatibility mode in which request amounts are decimals in major units of the
+	// currency, e.g. 10.05 GBP, instead of whole numbers of minor units, e.g. 1005. Responses always use
+	// minor units.
+	DecimalAmounts bool
+}
+
+// Server is a payment gateway. Its handlers only use the dependencies it was built with, so several
+// servers with different stores or banks can run in the same process.
+type Server struct {
+	store     PaymentStore
+	merchants MerchantStore
+	// webhooks is nil if there are no webhooks
+	webhooks  WebhookStore
+	bank      bank.Acquirer
+	apiKeys   *APIKeyStore
+	adminKeys *APIKeyStore
+	// signingSecrets is nil if signatures are not checked
+	signingSecrets     *SigningSecrets
+	signatureTolerance time.Duration
+	clock              Clock
+	logger             *slog.Logger
+	metrics            *serverMetrics
+	tracer             *tracing.Tracer
+	idempotency        *IdempotencyStore
+	// paymentLocks and merchantLocks serialise changes to each stored payment and merchant
+	paymentLocks   *keyedMutex
+	merchantLocks  *keyedMutex
+	decimalAmounts bool
+
+	webhookClient       *http.Client
+	webhookRetryBackoff time.Duration
+	webhookMaxAttempts  int
+	webhookWorkers      *webhookWorkers
+	// webhookWake wakes RunWebhooks when deliveries are added to the outbox
+	webhookWake chan struct{}
+
+	// ready is cleared when the server starts shutting down
+	ready atomic.Bool
+}
+
+// New instantiates a Server from config, filling in defaults for optional fields. It panics if a required
+// field is missing, or a field is out of range.
+func New(config Config) *Server {
+	if config.Store == nil {
+		panic("server: Config.Store is required")
+	}
+	if config.Merchants == nil {
+		panic("server: Config.Merchants is required")
+	}
+	if config.Bank == nil {
+		panic("server: Config.Bank is required")
+	}
+	if config.APIKeys == nil {
+		panic("server: Config.APIKeys is required")
+	}
+	if config.WebhookRetryBackoff < 0 {
+		panic("server: Config.WebhookRetryBackoff must not be negative")
+	}
+	if config.WebhookMaxAttempts < 0 {
+		panic("server: Config.WebhookMaxAttempts must not be negative")
+	}
+	if config.WebhookEndpointConcurrency < 0 {
+		panic("server: Config.WebhookEndpointConcurrency must not be negative")
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	if config.Logger == nil {
+		config.Logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+	if config.Metrics == nil {
+		config.Metrics = metrics.NewRegistry()
+	}
+	if config.Tracer == nil {
+		config.Tracer = tracing.NewTracer(nil)
+	}
+	if config.SignatureTolerance == 0 {
+		config.SignatureTolerance = signing.DefaultTolerance
+	}
+	if config.WebhookClient == nil {
+		config.WebhookClient = newWebhookClient(config.WebhookAllowPrivateAddresses)
+	}
+	if config.WebhookRetryBackoff == 0 {
+		config.WebhookRetryBackoff = DefaultWebhookRetryBackoff
+	}
+	if config.WebhookMaxAttempts == 0 {
+		config.WebhookMaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if config.WebhookEndpointConcurrency == 0 {
+		config.WebhookEndpointConcurrency = DefaultWebhookEndpointConcurrency
+	}
+	if config.IdempotencyKeyRetention == 0 {
+		config.IdempotencyKeyRetention = DefaultIdempotencyKeyRetention
+	}
+
+	logger := slog.New(contextHandler{logging.NewRedactingHandler(config.Logger.Handler())})
+	serverMetrics := newServerMetrics(config.Metrics, config.Store, logger)
+	s := &Server{
+		store:              config.Store,
+		merchants:          config.Merchants,
+		webhooks:           config.Webhooks,
+		bank:               instrumentedBank{Acquirer: config.Bank, metrics: serverMetrics, tracer: config.Tracer},
+		apiKeys:            config.APIKeys,
+		adminKeys:          config.AdminKeys,
+		signingSecrets:     config.SigningSecrets,
+		signatureTolerance: config.SignatureTolerance,
+		clock:              config.Clock,
+		logger:             logger,
+		metrics:            serverMetrics,
+		tracer:             config.Tracer,
+		idempotency:        NewIdempotencyStore(config.IdempotencyKeyRetention, config.Clock),
+		paymentLocks:       newKeyedMutex(),
+		merchantLocks:      newKeyedMutex(),
+		decimalAmounts:     config.DecimalAmounts,
+
+		webhookClient:       config.WebhookClient,
+		webhookRetryBackoff: config.WebhookRetryBackoff,
+		webhookMaxAttempts:  config.WebhookMaxAttempts,
+		webhookWorkers:      newWebhookWorkers(config.WebhookEndpointConcurrency),
+		webhookWake:         make(chan struct{}, 1),
+	}
+	s.ready.Store(true)
+	return s
+}
+
+// SetReady sets whether the server is ready for new traffic. A server is ready once it is built. It should
+// be set not ready when it starts shutting down, before it stops listening, so that load balancers stop
+// sending it requests while it drains the ones in flight.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// Ready reports whether the server is ready for new traffic.
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+/*
+Routes returns a handler serving the payment gateway API:
+  - The health, readiness and liveness probes and the metrics are served first, without authentication
+  - Every other request is given a request ID, and continues the caller's trace if it has a traceparent header
+  - Every other request is logged, and counted and timed by route, method and status code in the metrics
+  - Payment requests must be authenticated with a merchant's API key, and creating and fetching payments
+    must also be signed if the server has signing secrets
+  - Webhook endpoints are managed by merchants, if the server has a webhook store
+  - Admin requests must be authenticated with an admin key
+  - Errors, including for unknown paths and methods, are JSON error responses
+*/
+func (s *Server) Routes() http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(s.NotFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(s.MethodNotAllowedHandler)
+	router.Use(recordRoute)
+
+	merchant := func(handler http.HandlerFunc) http.Handler { return s.authenticate(handler) }
+	signed := func(handler http.HandlerFunc) http.Handler { return s.authenticate(s.verifySignature(handler)) }
+	router.Handle(utils.Path, signed(s.ProcessPaymentHandler)).Methods("POST")
+	router.Handle(utils.Path, merchant(s.ListPaymentsHandler)).Methods("GET")
+	router.Handle(utils.Path+"/{id}", signed(s.GetPaymentHandler)).Methods("GET")
+	router.Handle(utils.Path+"/{id}/capture", merchant(s.CapturePaymentHandler)).Methods("POST")
+	router.Handle(utils.Path+"/{id}/void", merchant(s.VoidPaymentHandler)).Methods("POST")
+	router.Handle(utils.Path+"/{id}/refunds", merchant(s.CreateRefundHandler)).Methods("POST")
+	router.Handle(utils.Path+"/{id}/refunds", merchant(s.ListRefundsHandler)).Methods("GET")
+	router.Handle(utils.Path+"/{id}/events", merchant(s.ListEventsHandler)).Methods("GET")
+
+	if s.webhooks != nil {
+		router.Handle(WebhooksPath, merchant(s.CreateWebhookEndpointHandler)).Methods("POST")
+		router.Handle(WebhooksPath, merchant(s.ListWebhookEndpointsHandler)).Methods("GET")
+		router.Handle(WebhooksPath+"/{id}", merchant(s.DeleteWebhookEndpointHandler)).Methods("DELETE")
+		router.Handle(WebhooksPath+"/{id}/deliveries", merchant(s.ListWebhookDeliveriesHandler)).Methods("GET")
+	}
+
+	if s.adminKeys != nil {
+		admin := func(handler http.HandlerFunc) http.Handler { return s.authenticateAdmin(handler) }
+		router.Handle(AdminMerchantsPath, admin(s.CreateMerchantHandler)).Methods("POST")
+		router.Handle(AdminMerchantsPath, admin(s.ListMerchantsHandler)).Methods("GET")
+		router.Handle(AdminMerchantsPath+"/{id}", admin(s.GetMerchantHandler)).Methods("GET")
+		router.Handle(AdminMerchantsPath+"/{id}", admin(s.UpdateMerchantHandler)).Methods("PUT")
+		router.Handle(AdminMerchantsPath+"/{id}", admin(s.DeleteMerchantHandler)).Methods("DELETE")
+		router.Handle(AdminMerchantsPath+"/{id}/keys", admin(s.IssueMerchantKeyHandler)).Methods("POST")
+	}
+
+	return withRequestID(s.serveProbes(withTraceContext(s.instrument(s.recoverPanics(limitRequestBody(router))))))
+}
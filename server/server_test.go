@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/mockbank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock whose time only changes when set by the test.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeBank is a bank.Acquirer with deterministic outcomes. Payments and authorizations fail if failPayments
+// is set, refunds fail if failRefunds is set, and otherwise everything succeeds. It settles in currencies,
+// or bank.DefaultSettlementCurrencies if currencies is empty, and pings fail with pingErr if it is set.
+type fakeBank struct {
+	failPayments bool
+	failRefunds  bool
+	currencies   []string
+	pingErr      error
+}
+
+func (b *fakeBank) MakePayment(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	return b.respond(models.StatusSuccess), nil
+}
+
+func (b *fakeBank) Authorize(ctx context.Context, r bank.MakePaymentRequest) (*bank.MakePaymentResponse, error) {
+	return b.respond(models.StatusAuthorized), nil
+}
+
+func (b *fakeBank) Capture(ctx context.Context, r bank.CaptureRequest) (*bank.CaptureResponse, error) {
+	return &bank.CaptureResponse{PaymentID: r.PaymentID, Status: string(models.StatusSuccess)}, nil
+}
+
+func (b *fakeBank) Refund(ctx context.Context, r bank.RefundRequest) (*bank.RefundResponse, error) {
+	status := models.RefundSucceeded
+	if b.failRefunds {
+		status = models.RefundFailed
+	}
+	return &bank.RefundResponse{RefundID: uuid.New().String(), Status: string(status)}, nil
+}
+
+func (b *fakeBank) Void(ctx context.Context, r bank.VoidRequest) (*bank.VoidResponse, error) {
+	return &bank.VoidResponse{PaymentID: r.PaymentID, Status: string(models.StatusVoided)}, nil
+}
+
+func (b *fakeBank) Ping(ctx context.Context) error {
+	return b.pingErr
+}
+
+func (b *fakeBank) SettlementCurrencies() []string {
+	if len(b.currencies) == 0 {
+		return bank.DefaultSettlementCurrencies
+	}
+	return b.currencies
+}
+
+func (b *fakeBank) respond(successStatus models.PaymentStatus) *bank.MakePaymentResponse {
+	status := successStatus
+	if b.failPayments {
+		status = models.StatusFailed
+	}
+	return &bank.MakePaymentResponse{PaymentID: uuid.New().String(), Status: string(status)}
+}
+
+const (
+	// testMerchantID is the merchant that serve authenticates as, with testAPIKey.
+	testMerchantID = "test-merchant"
+	testAPIKey     = "sk_test"
+)
+
+// newTestAPIKeys returns an APIKeyStore holding testAPIKey for testMerchantID.
+func newTestAPIKeys() *APIKeyStore {
+	keys := NewAPIKeyStore(SystemClock)
+	keys.AddHashedKey(testMerchantID, HashAPIKey(testAPIKey))
+	return keys
+}
+
+// newTestMerchant returns a merchant with the given ID that is active, has GBP as its default currency and
+// accepts every currency the bank settles in.
+func newTestMerchant(id string) *models.Merchant {
+	return &models.Merchant{ID: id, Name: "Test " + id, Status: models.MerchantActive, DefaultCurrency: "GBP"}
+}
+
+// newTestStore returns a memory store with no payments, holding only the merchant testMerchantID.
+func newTestStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.AddMerchant(newTestMerchant(testMerchantID))
+	return store
+}
+
+// newTestServer returns a Server with the mock bank and a discarding logger, whose store only holds the
+// merchant testMerchantID.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newTestServerWithBank(t, mockbank.NewBankClient())
+}
+
+// newTestServerWithBank is newTestServer with the given bank.
+func newTestServerWithBank(t *testing.T, acquirer bank.Acquirer) *Server {
+	t.Helper()
+	store := newTestStore()
+	return New(Config{
+		Store:     store,
+		Merchants: store,
+		Webhooks:  store,
+		Bank:      acquirer,
+		APIKeys:   newTestAPIKeys(),
+		Logger:    slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		// Webhook receivers in tests listen on loopback addresses
+		WebhookAllowPrivateAddresses: true,
+	})
+}
+
+// serve sends a request with the given method, path and JSON body to s as testMerchantID, and returns the
+// response.
+func serve(t *testing.T, s *Server, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveWithKey(t, s, testAPIKey, method, path, body)
+}
+
+// serveWithKey is serve for the merchant with apiKey, or with no Authorization header if apiKey is "".
+func serveWithKey(t *testing.T, s *Server, apiKey, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var requestBody io.Reader = http.NoBody
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		require.NoError(t, err, "failed to marshal request")
+		requestBody = bytes.NewReader(encoded)
+	}
+
+	request := httptest.NewRequest(method, path, requestBody)
+	request.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	response := httptest.NewRecorder()
+	s.Routes().ServeHTTP(response, request)
+	return response
+}
+
+// asTestMerchant returns request as if it had been authenticated as testMerchantID, for calling handlers
+// directly.
+func asTestMerchant(request *http.Request) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), merchantIDKey{}, testMerchantID))
+}
+
+// decodePayment decodes the MaskedPayment in the body of response.
+func decodePayment(t *testing.T, response *httptest.ResponseRecorder) models.MaskedPayment {
+	t.Helper()
+	maskedPayment := models.MaskedPayment{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&maskedPayment), "failed to unmarshal response")
+	return maskedPayment
+}
+
+// decodeError decodes the ErrorResponse in the body of response.
+func decodeError(t *testing.T, response *httptest.ResponseRecorder) models.ErrorResponse {
+	t.Helper()
+	errorResponse := models.ErrorResponse{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&errorResponse), "failed to unmarshal error response")
+	return errorResponse
+}
+
+func TestServersAreIsolated(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	first, second := newTestServer(t), newTestServer(t)
+
+	response := serve(t, first, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code)
+	maskedPayment := decodePayment(t, response)
+
+	response = serve(t, first, "GET", utils.Path+"/"+maskedPayment.ID, nil)
+	r.Equal(http.StatusOK, response.Code, "payment should be found on the server that processed it")
+
+	response = serve(t, second, "GET", utils.Path+"/"+maskedPayment.ID, nil)
+	r.Equal(http.StatusNotFound, response.Code, "payment should not be found on a different server")
+}
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	s := newTestServerWithBank(t, &fakeBank{})
+	r.True(s.Ready(), "server should be ready once built")
+
+	s.SetReady(false)
+	r.False(s.Ready())
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code, "server should keep serving while it drains")
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/celestebrant/processout-payment-gateway/signing"
+)
+
+// SigningSecrets holds the secret that each merchant signs its requests with. Unlike API keys, the secrets
+// are needed to verify signatures, so they are held as they are.
+type SigningSecrets struct {
+	mu      sync.Mutex
+	secrets map[string]string // by merchant ID
+}
+
+// NewSigningSecrets instantiates an empty SigningSecrets.
+func NewSigningSecrets() *SigningSecrets {
+	return &SigningSecrets{secrets: make(map[string]string)}
+}
+
+// Set sets the signing secret of the merchant with the given ID, replacing any previous secret.
+func (s *SigningSecrets) Set(merchantID, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[merchantID] = secret
+}
+
+// Secret returns the signing secret of the merchant with the given ID, or false if it has none.
+func (s *SigningSecrets) Secret(merchantID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, exists := s.secrets[merchantID]
+	return secret, exists
+}
+
+// Load sets the secrets read from r, which has a merchant ID and its secret on each line, separated by
+// whitespace. Blank lines and lines starting with # are ignored.
+func (s *SigningSecrets) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("signing secrets line %d: expected a merchant ID and a secret", line)
+		}
+		s.Set(fields[0], fields[1])
+	}
+	return scanner.Err()
+}
+
+/*
+verifySignature rejects requests without a valid signing.Header signature made with the secret of the
+authenticated merchant, within the server's signature tolerance. It does nothing if the server has no
+signing secrets, and must run after authenticate.
+*/
+func (s *Server) verifySignature(next http.Handler) http.Handler {
+	if s.signingSecrets == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(signing.Header)
+		if header == "" {
+			s.writeError(w, r, newError(http.StatusUnauthorized, CodeInvalidSignature, "missing "+signing.Header+" header"))
+			return
+		}
+		merchantID := MerchantIDFromContext(r.Context())
+		secret, exists := s.signingSecrets.Secret(merchantID)
+		if !exists {
+			s.logger.WarnContext(r.Context(), "Merchant has no signing secret")
+			s.writeError(w, r, newError(http.StatusUnauthorized, CodeInvalidSignature, "no signing secret is set up for the merchant"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeError(w, r, readError(err, "failed to read the request"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = signing.Verify(secret, header, s.clock.Now(), s.signatureTolerance, r.Method, r.URL.EscapedPath(), body)
+		switch {
+		case errors.Is(err, signing.ErrMalformed):
+			s.writeError(w, r, newError(http.StatusUnauthorized, CodeInvalidSignature, "malformed "+signing.Header+" header"))
+		case errors.Is(err, signing.ErrExpired):
+			s.writeError(w, r, newError(http.StatusUnauthorized, CodeInvalidSignature, "signature timestamp is too old or in the future"))
+		case err != nil:
+			s.writeError(w, r, newError(http.StatusUnauthorized, CodeInvalidSignature, "signature does not match the request"))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
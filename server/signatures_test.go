@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignature(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock(time.Date(2024, 7, 11, 22, 4, 40, 0, time.UTC))
+	s := newTestServerWithBank(t, &fakeBank{})
+	s.clock = clock
+	s.signingSecrets = NewSigningSecrets()
+	s.signingSecrets.Set(testMerchantID, "secret")
+	require.NoError(t, s.merchants.AddMerchant(newTestMerchant("unsigned-merchant")))
+	unsignedKey, err := s.apiKeys.IssueKey("unsigned-merchant")
+	require.NoError(t, err)
+
+	body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+	require.NoError(t, err, "failed to marshal request")
+
+	type testCase struct {
+		name               string
+		apiKey             string
+		signature          string
+		body               []byte
+		expectedStatusCode int
+		expectedMessage    string
+	}
+
+	testCases := []testCase{
+		{
+			"valid signature",
+			testAPIKey, signing.Sign("secret", clock.Now(), "POST", utils.Path, body), body,
+			http.StatusOK, "",
+		}, {
+			"missing signature",
+			testAPIKey, "", body,
+			http.StatusUnauthorized, "missing X-Signature header",
+		}, {
+			"body changed after signing",
+			testAPIKey, signing.Sign("secret", clock.Now(), "POST", utils.Path, body), bytes.Replace(body, []byte("1005"), []byte("9999"), 1),
+			http.StatusUnauthorized, "signature does not match the request",
+		}, {
+			"replayed outside the tolerance",
+			testAPIKey, signing.Sign("secret", clock.Now().Add(-signing.DefaultTolerance-time.Second), "POST", utils.Path, body), body,
+			http.StatusUnauthorized, "signature timestamp is too old or in the future",
+		}, {
+			"malformed signature",
+			testAPIKey, "v1=abcd", body,
+			http.StatusUnauthorized, "malformed X-Signature header",
+		}, {
+			"merchant without a signing secret",
+			unsignedKey, signing.Sign("secret", clock.Now(), "POST", utils.Path, body), body,
+			http.StatusUnauthorized, "no signing secret is set up for the merchant",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			request := httptest.NewRequest("POST", utils.Path, bytes.NewReader(tc.body))
+			request.Header.Set("Authorization", "Bearer "+tc.apiKey)
+			if tc.signature != "" {
+				request.Header.Set(signing.Header, tc.signature)
+			}
+			response := httptest.NewRecorder()
+			s.Routes().ServeHTTP(response, request)
+
+			r.Equal(tc.expectedStatusCode, response.Code, response.Body.String())
+			if tc.expectedStatusCode != http.StatusOK {
+				errorResponse := decodeError(t, response)
+				r.Equal(CodeInvalidSignature, errorResponse.Code)
+				r.Equal(tc.expectedMessage, errorResponse.Message)
+			}
+		})
+	}
+
+	t.Run("only creating and fetching payments are signed", func(t *testing.T) {
+		r := require.New(t)
+		response := serve(t, s, "GET", utils.Path, nil)
+		r.Equal(http.StatusOK, response.Code)
+
+		response = serve(t, s, "GET", utils.Path+"/some-id", nil)
+		r.Equal(http.StatusUnauthorized, response.Code)
+	})
+}
+
+func TestSigningSecretsLoad(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	secrets := NewSigningSecrets()
+	r.NoError(secrets.Load(strings.NewReader("# merchant secret\n\nfirst one\nsecond two\n")))
+	secret, exists := secrets.Secret("second")
+	r.True(exists)
+	r.Equal("two", secret)
+
+	_, exists = secrets.Secret("third")
+	r.False(exists)
+
+	r.ErrorContains(secrets.Load(strings.NewReader("first\n")), "line 1")
+}
@@ -1,31 +1,155 @@
 package server
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/celestebrant/processout-payment-gateway/models"
 )
 
-type PaymentStore struct {
-	mu       sync.Mutex
-	payments map[string]*models.MaskedPayment
+// ErrPaymentNotFound is returned by a PaymentStore when no payment has the requested ID.
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// PaymentStore stores masked payments, their refunds and their event history. Implementations must be safe
+// for concurrent use.
+type PaymentStore interface {
+	// AddPayment stores payment, replacing any existing payment with the same ID.
+	AddPayment(payment *models.MaskedPayment) error
+	// GetPayment returns the payment with the given ID, or ErrPaymentNotFound.
+	GetPayment(id string) (*models.MaskedPayment, error)
+	// ListPayments returns the payments matching query, in its order and up to its limit.
+	ListPayments(query PaymentQuery) ([]*models.MaskedPayment, error)
+	// CountPayments returns the number of stored payments.
+	CountPayments() (int, error)
+	// AddRefund stores refund.
+	AddRefund(refund *models.Refund) error
+	// ListRefunds returns the refunds of the payment with the given ID, oldest first.
+	ListRefunds(paymentID string) ([]*models.Refund, error)
+	// AddEvent stores event.
+	AddEvent(event *models.PaymentEvent) error
+	// ListEvents returns the events of the payment with the given ID, oldest first.
+	ListEvents(paymentID string) ([]*models.PaymentEvent, error)
+}
+
+// MemoryStore is a PaymentStore, MerchantStore and WebhookStore that holds everything in maps. It is lost
+// when the server stops.
+type MemoryStore struct {
+	mu                sync.Mutex
+	payments          map[string]*models.MaskedPayment
+	index             *paymentIndex
+	refunds           map[string][]*models.Refund       // by payment ID
+	events            map[string][]*models.PaymentEvent // by payment ID
+	merchants         map[string]*models.Merchant
+	apiKeys           map[string]*models.APIKey // by hash
+	webhookEndpoints  map[string]*models.WebhookEndpoint
+	webhookDeliveries map[string]*models.WebhookDelivery
+	// deliverySeqs are the order webhook deliveries were added in, by ID, and endpointDeliveries the IDs of
+	// the deliveries of each endpoint, oldest first
+	deliverySeqs       map[string]int
+	endpointDeliveries map[string][]string
+	// pendingDeliveries indexes the pending webhook deliveries, ordered by their next attempt and then
+	// oldest first
+	pendingDeliveries []pendingDelivery
 }
 
-func NewPaymentStore() *PaymentStore {
-	return &PaymentStore{
-		payments: make(map[string]*models.MaskedPayment),
+// NewMemoryStore instantiates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		payments:           make(map[string]*models.MaskedPayment),
+		index:              newPaymentIndex(),
+		refunds:            make(map[string][]*models.Refund),
+		events:             make(map[string][]*models.PaymentEvent),
+		merchants:          make(map[string]*models.Merchant),
+		apiKeys:            make(map[string]*models.APIKey),
+		webhookEndpoints:   make(map[string]*models.WebhookEndpoint),
+		webhookDeliveries:  make(map[string]*models.WebhookDelivery),
+		deliverySeqs:       make(map[string]int),
+		endpointDeliveries: make(map[string][]string),
 	}
 }
 
-func (s *PaymentStore) AddPayment(payment *models.MaskedPayment) {
+// AddPayment stores a copy of payment, so that later changes by the caller are not reflected in the store.
+func (s *MemoryStore) AddPayment(payment *models.MaskedPayment) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.payments[payment.ID] = payment
+	stored := *payment
+	s.index.update(s.payments[payment.ID], &stored)
+	s.payments[payment.ID] = &stored
+	return nil
 }
 
-func (s *PaymentStore) GetPayment(id string) (*models.MaskedPayment, bool) {
+// GetPayment returns a copy of the payment with the given ID, or ErrPaymentNotFound.
+func (s *MemoryStore) GetPayment(id string) (*models.MaskedPayment, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	payment, exists := s.payments[id]
-	return payment, exists
+	if !exists {
+		return nil, ErrPaymentNotFound
+	}
+	fetched := *payment
+	return &fetched, nil
+}
+
+// ListPayments returns copies of the payments matching query, found through the payment index.
+func (s *MemoryStore) ListPayments(query PaymentQuery) ([]*models.MaskedPayment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payments := []*models.MaskedPayment{}
+	s.index.walk(query, func(key PaymentCursor) bool {
+		if payment := s.payments[key.ID]; query.matches(payment) {
+			fetched := *payment
+			payments = append(payments, &fetched)
+		}
+		return query.Limit == 0 || len(payments) < query.Limit
+	})
+	return payments, nil
+}
+
+// CountPayments returns the number of stored payments.
+func (s *MemoryStore) CountPayments() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.payments), nil
+}
+
+// AddRefund stores a copy of refund.
+func (s *MemoryStore) AddRefund(refund *models.Refund) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *refund
+	s.refunds[refund.PaymentID] = append(s.refunds[refund.PaymentID], &stored)
+	return nil
+}
+
+// ListRefunds returns copies of the refunds of the payment with the given ID, oldest first.
+func (s *MemoryStore) ListRefunds(paymentID string) ([]*models.Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refunds := make([]*models.Refund, 0, len(s.refunds[paymentID]))
+	for _, refund := range s.refunds[paymentID] {
+		fetched := *refund
+		refunds = append(refunds, &fetched)
+	}
+	return refunds, nil
+}
+
+// AddEvent stores a copy of event.
+func (s *MemoryStore) AddEvent(event *models.PaymentEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *event
+	s.events[event.PaymentID] = append(s.events[event.PaymentID], &stored)
+	return nil
+}
+
+// ListEvents returns copies of the events of the payment with the given ID, oldest first.
+func (s *MemoryStore) ListEvents(paymentID string) ([]*models.PaymentEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]*models.PaymentEvent, 0, len(s.events[paymentID]))
+	for _, event := range s.events[paymentID] {
+		fetched := *event
+		events = append(events, &fetched)
+	}
+	return events, nil
 }
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentStores(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name     string
+		newStore func(t *testing.T) PaymentStore
+	}
+
+	testCases := []testCase{
+		{
+			"memory store",
+			func(t *testing.T) PaymentStore {
+				return NewMemoryStore()
+			},
+		}, {
+			"file store",
+			func(t *testing.T) PaymentStore {
+				store, err := OpenFileStore(filepath.Join(t.TempDir(), "payments.log"))
+				require.NoError(t, err)
+				t.Cleanup(func() { store.Close() })
+				return store
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			store := tc.newStore(t)
+
+			_, err := store.GetPayment("some-id")
+			r.ErrorIs(err, ErrPaymentNotFound)
+
+			payment := &models.MaskedPayment{ID: "some-id", Status: "SUCCESS", Money: money.New(1005, "GBP")}
+			r.NoError(store.AddPayment(payment))
+
+			fetched, err := store.GetPayment("some-id")
+			r.NoError(err)
+			r.Equal(payment, fetched)
+
+			// Changes to the caller's copy should not leak into the store
+			payment.Status = "FAILED"
+			fetched, err = store.GetPayment("some-id")
+			r.NoError(err)
+			r.Equal(models.StatusSuccess, fetched.Status)
+
+			refunds, err := store.ListRefunds("some-id")
+			r.NoError(err)
+			r.Empty(refunds)
+
+			first := &models.Refund{ID: "first", PaymentID: "some-id", Money: money.New(100, "GBP")}
+			second := &models.Refund{ID: "second", PaymentID: "some-id", Money: money.New(200, "GBP")}
+			r.NoError(store.AddRefund(first))
+			r.NoError(store.AddRefund(second))
+			r.NoError(store.AddRefund(&models.Refund{ID: "other", PaymentID: "other-id", Money: money.New(300, "GBP")}))
+
+			refunds, err = store.ListRefunds("some-id")
+			r.NoError(err)
+			r.Equal([]*models.Refund{first, second}, refunds)
+
+			event := &models.PaymentEvent{PaymentID: "some-id", To: models.StatusSuccess, Reason: "some reason"}
+			r.NoError(store.AddEvent(event))
+			events, err := store.ListEvents("some-id")
+			r.NoError(err)
+			r.Equal([]*models.PaymentEvent{event}, events)
+		})
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	t.Parallel()
+
+	t.Run("payments survive reopening the store", func(t *testing.T) {
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "payments.log")
+
+		store, err := OpenFileStore(path)
+		r.NoError(err)
+		r.NoError(store.AddPayment(&models.MaskedPayment{ID: "first", Status: "SUCCESS"}))
+		r.NoError(store.AddPayment(&models.MaskedPayment{ID: "second", Status: "FAILED"}))
+		r.NoError(store.AddPayment(&models.MaskedPayment{ID: "first", Status: "FAILED"}))
+		r.NoError(store.AddRefund(&models.Refund{ID: "refund", PaymentID: "second", Money: money.New(100, "GBP")}))
+		r.NoError(store.Close())
+
+		reopened, err := OpenFileStore(path)
+		r.NoError(err)
+		defer reopened.Close()
+
+		first, err := reopened.GetPayment("first")
+		r.NoError(err)
+		r.Equal(models.StatusFailed, first.Status, "latest record should win")
+
+		second, err := reopened.GetPayment("second")
+		r.NoError(err)
+		r.Equal(models.StatusFailed, second.Status)
+
+		refunds, err := reopened.ListRefunds("second")
+		r.NoError(err)
+		r.Equal([]*models.Refund{{ID: "refund", PaymentID: "second", Money: money.New(100, "GBP")}}, refunds)
+	})
+
+	t.Run("incomplete final line is discarded", func(t *testing.T) {
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "payments.log")
+
+		store, err := OpenFileStore(path)
+		r.NoError(err)
+		r.NoError(store.AddPayment(&models.MaskedPayment{ID: "first", Status: "SUCCESS"}))
+		r.NoError(store.Close())
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		r.NoError(err)
+		_, err = file.WriteString(`{"type":"payment","payment":{"id":"sec`)
+		r.NoError(err)
+		r.NoError(file.Close())
+
+		reopened, err := OpenFileStore(path)
+		r.NoError(err)
+		r.NoError(reopened.AddPayment(&models.MaskedPayment{ID: "third", Status: "SUCCESS"}))
+		r.NoError(reopened.Close())
+
+		// The log should still be readable after appending over the discarded line
+		reopened, err = OpenFileStore(path)
+		r.NoError(err)
+		defer reopened.Close()
+
+		_, err = reopened.GetPayment("first")
+		r.NoError(err)
+		_, err = reopened.GetPayment("second")
+		r.ErrorIs(err, ErrPaymentNotFound)
+		_, err = reopened.GetPayment("third")
+		r.NoError(err)
+	})
+
+	t.Run("failed writes are rolled back", func(t *testing.T) {
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "payments.log")
+
+		store, err := OpenFileStore(path)
+		r.NoError(err)
+		r.NoError(store.AddPayment(&models.MaskedPayment{ID: "first", Status: "SUCCESS"}))
+
+		file := &faultyFile{logFile: store.file, failWrite: true}
+		store.file = file
+		r.Error(store.AddPayment(&models.MaskedPayment{ID: "torn", Status: "SUCCESS"}))
+		file.failWrite, file.failSync = false, true
+		r.Error(store.AddPayment(&models.MaskedPayment{ID: "unsynced", Status: "SUCCESS"}))
+		r.NoError(store.AddPayment(&models.MaskedPayment{ID: "last", Status: "SUCCESS"}))
+		r.NoError(store.Close())
+
+		reopened, err := OpenFileStore(path)
+		r.NoError(err, "the log should not have a torn line in the middle")
+		defer reopened.Close()
+		for _, id := range []string{"first", "last"} {
+			_, err = reopened.GetPayment(id)
+			r.NoError(err)
+		}
+		for _, id := range []string{"torn", "unsynced"} {
+			_, err = reopened.GetPayment(id)
+			r.ErrorIs(err, ErrPaymentNotFound, "a failed write should not come back")
+		}
+	})
+
+	t.Run("store that cannot roll back refuses writes", func(t *testing.T) {
+		r := require.New(t)
+		store, err := OpenFileStore(filepath.Join(t.TempDir(), "payments.log"))
+		r.NoError(err)
+		defer store.Close()
+
+		file := &faultyFile{logFile: store.file, failWrite: true, failTruncate: true}
+		store.file = file
+		r.Error(store.AddPayment(&models.MaskedPayment{ID: "torn", Status: "SUCCESS"}))
+		file.failWrite, file.failTruncate = false, false
+		r.ErrorContains(store.AddPayment(&models.MaskedPayment{ID: "next", Status: "SUCCESS"}), "payment log is broken")
+	})
+
+	t.Run("corrupt line returns error", func(t *testing.T) {
+		r := require.New(t)
+		path := filepath.Join(t.TempDir(), "payments.log")
+		r.NoError(os.WriteFile(path, []byte("not json\n"), 0o600))
+
+		_, err := OpenFileStore(path)
+		r.ErrorContains(err, "failed to decode payment log line 1")
+	})
+}
+
+func TestListPayments(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 7, 11, 22, 0, 0, 0, time.UTC)
+	payments := []*models.MaskedPayment{
+		{ID: "a", Status: models.StatusSuccess, MaskedCardNumber: "************4242", Money: money.New(1000, "GBP"), CreatedAt: start},
+		{ID: "b", Status: models.StatusFailed, MaskedCardNumber: "************0002", Money: money.New(2000, "EUR"), CreatedAt: start.Add(time.Minute)},
+		{ID: "c", Status: models.StatusSuccess, MaskedCardNumber: "************4242", Money: money.New(3000, "EUR"), CreatedAt: start.Add(2 * time.Minute)},
+		// d has the same creation time as c, so the ID breaks the tie
+		{ID: "d", Status: models.StatusAuthorized, MaskedCardNumber: "************0002", Money: money.New(4000, "GBP"), CreatedAt: start.Add(2 * time.Minute)},
+		{ID: "e", Status: models.StatusSuccess, MaskedCardNumber: "************4242", Money: money.New(5000, "GBP"), CreatedAt: start.Add(3 * time.Minute)},
+	}
+
+	type testCase struct {
+		name        string
+		query       PaymentQuery
+		expectedIDs []string
+	}
+
+	testCases := []testCase{
+		{"all oldest first", PaymentQuery{}, []string{"a", "b", "c", "d", "e"}},
+		{"all newest first", PaymentQuery{Descending: true}, []string{"e", "d", "c", "b", "a"}},
+		{"by status", PaymentQuery{Status: models.StatusSuccess}, []string{"a", "c", "e"}},
+		{"by currency", PaymentQuery{Currency: "EUR", Descending: true}, []string{"c", "b"}},
+		{"by last four", PaymentQuery{LastFour: "0002"}, []string{"b", "d"}},
+		{"by amount range", PaymentQuery{MinAmount: 2000, MaxAmount: 4000}, []string{"b", "c", "d"}},
+		{"by creation time range", PaymentQuery{CreatedFrom: start.Add(time.Minute), CreatedTo: start.Add(3 * time.Minute)}, []string{"b", "c", "d"}},
+		{"by several filters", PaymentQuery{Status: models.StatusSuccess, Currency: "GBP", MinAmount: 2000}, []string{"e"}},
+		{"no matches", PaymentQuery{Currency: "USD"}, []string{}},
+		{"limit", PaymentQuery{Limit: 2}, []string{"a", "b"}},
+		{"after cursor", PaymentQuery{After: &PaymentCursor{start.Add(2 * time.Minute), "c"}}, []string{"d", "e"}},
+		{"after cursor newest first", PaymentQuery{Descending: true, After: &PaymentCursor{start.Add(2 * time.Minute), "d"}}, []string{"c", "b", "a"}},
+		{"after cursor with filter and limit", PaymentQuery{Status: models.StatusSuccess, After: &PaymentCursor{start, "a"}, Limit: 1}, []string{"c"}},
+	}
+
+	stores := map[string]func(t *testing.T) PaymentStore{
+		"memory store": func(t *testing.T) PaymentStore {
+			return NewMemoryStore()
+		},
+		"reopened file store": func(t *testing.T) PaymentStore {
+			path := filepath.Join(t.TempDir(), "payments.log")
+			store, err := OpenFileStore(path)
+			require.NoError(t, err)
+			for _, payment := range payments {
+				require.NoError(t, store.AddPayment(payment))
+			}
+			require.NoError(t, store.Close())
+
+			reopened, err := OpenFileStore(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { reopened.Close() })
+			return reopened
+		},
+	}
+
+	for storeName, newStore := range stores {
+		t.Run(storeName, func(t *testing.T) {
+			store := newStore(t)
+			for _, payment := range payments {
+				require.NoError(t, store.AddPayment(payment))
+			}
+
+			for _, tc := range testCases {
+				t.Run(tc.name, func(t *testing.T) {
+					r := require.New(t)
+					listed, err := store.ListPayments(tc.query)
+					r.NoError(err)
+
+					ids := []string{}
+					for _, payment := range listed {
+						ids = append(ids, payment.ID)
+					}
+					r.Equal(tc.expectedIDs, ids)
+				})
+			}
+
+			t.Run("status change moves payment between indexes", func(t *testing.T) {
+				r := require.New(t)
+				store := newStore(t)
+				for _, payment := range payments {
+					r.NoError(store.AddPayment(payment))
+				}
+
+				refunded := *payments[0]
+				refunded.Status = models.StatusRefunded
+				r.NoError(store.AddPayment(&refunded))
+
+				listed, err := store.ListPayments(PaymentQuery{Status: models.StatusSuccess})
+				r.NoError(err)
+				r.Len(listed, 2, "payment should no longer be listed under its previous status")
+
+				listed, err = store.ListPayments(PaymentQuery{Status: models.StatusRefunded})
+				r.NoError(err)
+				r.Len(listed, 1)
+				r.Equal("a", listed[0].ID)
+
+				listed, err = store.ListPayments(PaymentQuery{})
+				r.NoError(err)
+				r.Len(listed, len(payments), "payment should only be listed once")
+			})
+		})
+	}
+}
+
+// faultyFile is a logFile whose writes, next sync and truncations can be made to fail. A failed write still
+// writes half of its bytes, like a disk that fills up part way through.
+type faultyFile struct {
+	logFile
+	failWrite, failSync, failTruncate bool
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.failWrite {
+		n, _ := f.logFile.Write(p[:len(p)/2])
+		return n, errors.New("no space left on device")
+	}
+	return f.logFile.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errors.New("input/output error")
+	}
+	return f.logFile.Sync()
+}
+
+func (f *faultyFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("input/output error")
+	}
+	return f.logFile.Truncate(size)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+)
+
+// Span outcomes that are not a payment status or an error code.
+const (
+	outcomeOK       = "ok"
+	outcomeValid    = "valid"
+	outcomeInvalid  = "invalid"
+	outcomeNotFound = "not_found"
+)
+
+// withTraceContext continues the trace of the caller, if the request has a valid traceparent header, so that
+// the spans of the request and the requests to the bank are part of it.
+func withTraceContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(tracing.Extract(r.Context(), r.Header)))
+	})
+}
+
+// amountBucket describes the size of amount in major units of its currency, like "10-100", so that spans
+// can be grouped by amount without recording exact amounts.
+func amountBucket(amount money.Money) string {
+	exponent, err := money.Exponent(amount.Currency)
+	if err != nil {
+		return "unknown"
+	}
+	major := amount.Amount
+	for i := 0; i < exponent; i++ {
+		major /= 10
+	}
+	switch {
+	case major < 10:
+		return "0-10"
+	case major < 100:
+		return "10-100"
+	case major < 1000:
+		return "100-1000"
+	case major < 10000:
+		return "1000-10000"
+	}
+	return "10000+"
+}
+
+// addPayment stores payment in a span.
+func (s *Server) addPayment(ctx context.Context, payment *models.MaskedPayment) error {
+	_, span := s.tracer.Start(ctx, "PaymentStore.AddPayment")
+	defer span.End()
+	span.SetAttributes("status", paymentOutcome(payment.Status))
+
+	err := s.store.AddPayment(payment)
+	span.SetError(err)
+	span.SetAttributes("outcome", storeOutcome(err))
+	return err
+}
+
+// getPayment fetches the payment with the given ID from the store in a span.
+func (s *Server) getPayment(ctx context.Context, id string) (*models.MaskedPayment, error) {
+	_, span := s.tracer.Start(ctx, "PaymentStore.GetPayment")
+	defer span.End()
+
+	payment, err := s.store.GetPayment(id)
+	if !errors.Is(err, ErrPaymentNotFound) {
+		span.SetError(err)
+	}
+	span.SetAttributes("outcome", storeOutcome(err))
+	return payment, err
+}
+
+// storeOutcome is the outcome of a call to the store that returned err.
+func storeOutcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, ErrPaymentNotFound):
+		return outcomeNotFound
+	}
+	return outcomeError
+}
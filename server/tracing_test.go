@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/money"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// newTracedTestServer is newTestServerWithBank with a tracer that keeps its spans in the returned exporter.
+func newTracedTestServer(t *testing.T, acquirer bank.Acquirer) (*Server, *tracing.InMemoryExporter) {
+	t.Helper()
+	store := newTestStore()
+	exporter := tracing.NewInMemoryExporter()
+	return New(Config{
+		Store:     store,
+		Merchants: store,
+		Bank:      acquirer,
+		APIKeys:   newTestAPIKeys(),
+		Logger:    slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		Tracer:    tracing.NewTracer(exporter),
+	}), exporter
+}
+
+// spansByName indexes spans by name, failing if a name is repeated.
+func spansByName(t *testing.T, spans []tracing.SpanData) map[string]tracing.SpanData {
+	t.Helper()
+	byName := map[string]tracing.SpanData{}
+	for _, span := range spans {
+		require.NotContains(t, byName, span.Name, "span %s should be exported once", span.Name)
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+func TestProcessPaymentSpans(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	s, exporter := newTracedTestServer(t, &fakeBank{})
+	body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+	r.NoError(err)
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	request := httptest.NewRequest("POST", utils.Path, bytes.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+testAPIKey)
+	request.Header.Set(tracing.TraceparentHeader, traceparent)
+	response := httptest.NewRecorder()
+	s.Routes().ServeHTTP(response, request)
+	r.Equal(http.StatusOK, response.Code)
+
+	spans := spansByName(t, exporter.Spans())
+	r.Len(spans, 4)
+	handler := spans["ProcessPaymentHandler"]
+	validation := spans["validateProcessPaymentRequest"]
+	bankCall := spans["BankClient.MakePayment"]
+	store := spans["PaymentStore.AddPayment"]
+
+	inbound, err := tracing.ParseTraceparent(traceparent)
+	r.NoError(err)
+	r.Equal(inbound.TraceID, handler.Parent.TraceID, "the caller's trace should be continued")
+	r.Equal(inbound.SpanID, handler.Parent.SpanID)
+	r.True(handler.Parent.Remote)
+	for _, child := range []tracing.SpanData{validation, bankCall, store} {
+		r.Equal(handler.SpanContext, child.Parent, "%s should be a child of the handler", child.Name)
+		r.Empty(child.Error)
+	}
+
+	r.Equal(map[string]string{"currency": "GBP", "amount_bucket": "10-100", "outcome": "success"}, handler.Attributes)
+	r.Equal(map[string]string{"currency": "GBP", "amount_bucket": "10-100", "outcome": "valid"}, validation.Attributes)
+	r.Equal(map[string]string{"currency": "GBP", "amount_bucket": "10-100", "outcome": "success"}, bankCall.Attributes)
+	r.Equal(map[string]string{"status": "success", "outcome": "ok"}, store.Attributes)
+
+	exporter.Reset()
+	payment := decodePayment(t, response)
+	serve(t, s, "GET", utils.Path+"/"+payment.ID, nil)
+	spans = spansByName(t, exporter.Spans())
+	r.Len(spans, 1)
+	r.Equal(map[string]string{"outcome": "ok"}, spans["PaymentStore.GetPayment"].Attributes)
+	r.False(spans["PaymentStore.GetPayment"].Parent.IsValid(), "a request without a traceparent should start a new trace")
+
+	exporter.Reset()
+	serve(t, s, "GET", utils.Path+"/"+"9b2c0d6e-3f4a-4b5c-8d6e-7f8a9b0c1d2e", nil)
+	r.Equal(map[string]string{"outcome": "not_found"}, exporter.Spans()[0].Attributes)
+	r.Empty(exporter.Spans()[0].Error, "a payment not being found is not an error of the store")
+}
+
+func TestProcessPaymentErrorSpans(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	s, exporter := newTracedTestServer(t, &brokenBank{err: errors.New("connection refused")})
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusBadGateway, response.Code)
+
+	spans := spansByName(t, exporter.Spans())
+	r.Len(spans, 3)
+	r.Equal("bank_error", spans["ProcessPaymentHandler"].Attributes["outcome"])
+	r.Equal("unexpected error from call to the bank", spans["ProcessPaymentHandler"].Error)
+	r.Equal("error", spans["BankClient.MakePayment"].Attributes["outcome"])
+	r.Equal("connection refused", spans["BankClient.MakePayment"].Error)
+	r.NotContains(spans, "PaymentStore.AddPayment")
+
+	exporter.Reset()
+	invalid := utils.ValidProcessPaymentRequest()
+	invalid.CVV = "1"
+	response = serve(t, s, "POST", utils.Path, invalid)
+	r.Equal(http.StatusBadRequest, response.Code)
+
+	spans = spansByName(t, exporter.Spans())
+	r.Len(spans, 2)
+	r.Equal(map[string]string{"outcome": "validation_failed"}, spans["ProcessPaymentHandler"].Attributes)
+	r.Empty(spans["ProcessPaymentHandler"].Error, "client errors are not errors of the handler")
+	r.Equal(map[string]string{"outcome": "invalid"}, spans["validateProcessPaymentRequest"].Attributes)
+}
+
+func TestAmountBucket(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		amount   money.Money
+		expected string
+	}
+
+	testCases := []testCase{
+		{money.New(1, "GBP"), "0-10"},
+		{money.New(999, "GBP"), "0-10"},
+		{money.New(1000, "GBP"), "10-100"},
+		{money.New(99999, "GBP"), "100-1000"},
+		{money.New(100000, "GBP"), "1000-10000"},
+		{money.New(1000000, "GBP"), "10000+"},
+		{money.New(1000, "JPY"), "1000-10000"},
+		{money.New(1000, "BHD"), "0-10"},
+		{money.New(1000, "XXX"), "unknown"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.amount.String(), func(t *testing.T) {
+			require.Equal(t, tc.expected, amountBucket(tc.amount))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/gorilla/mux"
+)
+
+// VoidPaymentHandler handles cancelling an authorized payment before it is captured, so that the bank
+// releases the held funds. Payments that have been captured, have failed or are already voided cannot be
+// voided, and a captured payment must be refunded instead.
+func (s *Server) VoidPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := validatePaymentID(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	unlock := s.paymentLocks.Lock(id)
+	defer unlock()
+
+	maskedPayment, ok := s.fetchPayment(w, r, id)
+	if !ok {
+		return
+	}
+
+	if !maskedPayment.Status.CanTransitionTo(models.StatusVoided) {
+		s.writeError(w, r, newError(http.StatusConflict, CodeInvalidPaymentStatus, fmt.Sprintf("payment with status %s cannot be voided", maskedPayment.Status)))
+		return
+	}
+
+	bankResponse, err := s.bank.Void(r.Context(), bank.VoidRequest{PaymentID: id})
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to void payment with the bank", "error", err)
+		s.writeError(w, r, newError(http.StatusBadGateway, CodeBankError, "unexpected error from call to the bank"))
+		return
+	}
+	if bankResponse == nil {
+		s.logger.ErrorContext(r.Context(), "Received no void response from the bank")
+		s.writeError(w, r, newError(http.StatusBadGateway, CodeBankError, "failed to receive a response from the bank"))
+		return
+	}
+	if models.PaymentStatus(bankResponse.Status) != models.StatusVoided {
+		s.writeError(w, r, newError(http.StatusBadGateway, CodeBankRejected, "the bank did not void the payment"))
+		return
+	}
+
+	if err := s.transition(r.Context(), maskedPayment, models.StatusVoided, "voided, the bank released the funds"); err != nil {
+		s.logger.ErrorContext(r.Context(), "Failed to store voided payment", "error", err)
+		s.writeError(w, r, newError(http.StatusInternalServerError, CodeInternalError, "failed to store the payment"))
+		return
+	}
+	s.logger.InfoContext(r.Context(), "Voided payment")
+
+	json.NewEncoder(w).Encode(maskedPayment)
+}
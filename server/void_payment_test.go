@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoidPaymentHandler(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name                 string
+		bank                 *fakeBank
+		capture              bool
+		beforeVoid           func(t *testing.T, s *Server, id string)
+		expectedStatusCode   int
+		expectedErrorMessage string
+	}
+
+	testCases := []testCase{
+		{
+			"authorized payment is voided",
+			&fakeBank{},
+			false,
+			func(t *testing.T, s *Server, id string) {},
+			http.StatusOK,
+			"",
+		}, {
+			"captured payment returns 409 error response",
+			&fakeBank{},
+			true,
+			func(t *testing.T, s *Server, id string) {},
+			http.StatusConflict,
+			"payment with status SUCCESS cannot be voided",
+		}, {
+			"failed payment returns 409 error response",
+			&fakeBank{failPayments: true},
+			false,
+			func(t *testing.T, s *Server, id string) {},
+			http.StatusConflict,
+			"payment with status FAILED cannot be voided",
+		}, {
+			"voided payment returns 409 error response",
+			&fakeBank{},
+			false,
+			func(t *testing.T, s *Server, id string) {
+				response := serve(t, s, "POST", utils.Path+"/"+id+"/void", nil)
+				require.Equal(t, http.StatusOK, response.Code)
+			},
+			http.StatusConflict,
+			"payment with status VOIDED cannot be voided",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			s := newTestServerWithBank(t, tc.bank)
+
+			processRequest := utils.ValidProcessPaymentRequest()
+			processRequest.Capture = &tc.capture
+			response := serve(t, s, "POST", utils.Path, processRequest)
+			r.Equal(http.StatusOK, response.Code)
+			payment := decodePayment(t, response)
+
+			tc.beforeVoid(t, s, payment.ID)
+
+			response = serve(t, s, "POST", utils.Path+"/"+payment.ID+"/void", nil)
+			r.Equal(tc.expectedStatusCode, response.Code)
+
+			if tc.expectedStatusCode != http.StatusOK {
+				r.Equal(tc.expectedErrorMessage, decodeError(t, response).Message)
+				return
+			}
+
+			voided := decodePayment(t, response)
+			r.Equal(models.StatusVoided, voided.Status)
+
+			// A voided payment cannot be captured
+			response = serve(t, s, "POST", utils.Path+"/"+payment.ID+"/capture", nil)
+			r.Equal(http.StatusConflict, response.Code)
+		})
+	}
+
+	t.Run("nonexistent payment returns 404 error response", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		response := serve(t, s, "POST", utils.Path+"/"+uuid.New().String()+"/void", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+	})
+}
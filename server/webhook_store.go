@@ -0,0 +1,247 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+// ErrWebhookEndpointNotFound is returned by a WebhookStore when no webhook endpoint has the requested ID.
+var ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+
+/*
+WebhookStore stores webhook endpoints and the outbox of their deliveries. MemoryStore and FileStore are
+both WebhookStores. Implementations must be safe for concurrent use.
+
+A delivery is stored when its event happens and again after every attempt, so pending deliveries survive a
+restart of a persistent store and are resumed.
+*/
+type WebhookStore interface {
+	// AddWebhookEndpoint stores endpoint, replacing any existing endpoint with the same ID.
+	AddWebhookEndpoint(endpoint *models.WebhookEndpoint) error
+	// GetWebhookEndpoint returns the endpoint with the given ID, or ErrWebhookEndpointNotFound.
+	GetWebhookEndpoint(id string) (*models.WebhookEndpoint, error)
+	// ListWebhookEndpoints returns the endpoints of the merchant with the given ID, oldest first.
+	ListWebhookEndpoints(merchantID string) ([]*models.WebhookEndpoint, error)
+	// DeleteWebhookEndpoint deletes the endpoint with the given ID, or returns ErrWebhookEndpointNotFound.
+	// Its deliveries are kept.
+	DeleteWebhookEndpoint(id string) error
+	// AddWebhookDelivery stores delivery, replacing any existing delivery with the same ID.
+	AddWebhookDelivery(delivery *models.WebhookDelivery) error
+	// ListWebhookDeliveries returns the deliveries to the endpoint with the given ID, oldest first.
+	ListWebhookDeliveries(endpointID string) ([]*models.WebhookDelivery, error)
+	// ListPendingWebhookDeliveries returns the first limit pending deliveries, or every one if limit is 0,
+	// ordered by their next attempt and then oldest first.
+	ListPendingWebhookDeliveries(limit int) ([]*models.WebhookDelivery, error)
+}
+
+// AddWebhookEndpoint stores a copy of endpoint.
+func (s *MemoryStore) AddWebhookEndpoint(endpoint *models.WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhookEndpoints[endpoint.ID] = copyWebhookEndpoint(endpoint)
+	return nil
+}
+
+// GetWebhookEndpoint returns a copy of the endpoint with the given ID, or ErrWebhookEndpointNotFound.
+func (s *MemoryStore) GetWebhookEndpoint(id string) (*models.WebhookEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endpoint, exists := s.webhookEndpoints[id]
+	if !exists {
+		return nil, ErrWebhookEndpointNotFound
+	}
+	return copyWebhookEndpoint(endpoint), nil
+}
+
+// ListWebhookEndpoints returns copies of the endpoints of the merchant with the given ID, oldest first.
+func (s *MemoryStore) ListWebhookEndpoints(merchantID string) ([]*models.WebhookEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endpoints := []*models.WebhookEndpoint{}
+	for _, endpoint := range s.webhookEndpoints {
+		if endpoint.MerchantID == merchantID {
+			endpoints = append(endpoints, copyWebhookEndpoint(endpoint))
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if !endpoints[i].CreatedAt.Equal(endpoints[j].CreatedAt) {
+			return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+		}
+		return endpoints[i].ID < endpoints[j].ID
+	})
+	return endpoints, nil
+}
+
+// DeleteWebhookEndpoint deletes the endpoint with the given ID, or returns ErrWebhookEndpointNotFound.
+func (s *MemoryStore) DeleteWebhookEndpoint(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.webhookEndpoints[id]; !exists {
+		return ErrWebhookEndpointNotFound
+	}
+	delete(s.webhookEndpoints, id)
+	return nil
+}
+
+// AddWebhookDelivery stores a copy of delivery.
+func (s *MemoryStore) AddWebhookDelivery(delivery *models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, exists := s.webhookDeliveries[delivery.ID]; exists {
+		s.unindexPending(stored)
+	} else {
+		s.deliverySeqs[delivery.ID] = len(s.deliverySeqs)
+		s.endpointDeliveries[delivery.EndpointID] = append(s.endpointDeliveries[delivery.EndpointID], delivery.ID)
+	}
+	stored := copyWebhookDelivery(delivery)
+	s.webhookDeliveries[delivery.ID] = stored
+	s.indexPending(stored)
+	return nil
+}
+
+// ListWebhookDeliveries returns copies of the deliveries to the endpoint with the given ID, oldest first.
+func (s *MemoryStore) ListWebhookDeliveries(endpointID string) ([]*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := make([]*models.WebhookDelivery, 0, len(s.endpointDeliveries[endpointID]))
+	for _, id := range s.endpointDeliveries[endpointID] {
+		deliveries = append(deliveries, copyWebhookDelivery(s.webhookDeliveries[id]))
+	}
+	return deliveries, nil
+}
+
+// ListPendingWebhookDeliveries returns copies of the first limit pending deliveries, or every one if limit
+// is 0, ordered by their next attempt and then oldest first, so that events due at the same time are sent
+// in the order they happened.
+func (s *MemoryStore) ListPendingWebhookDeliveries(limit int) ([]*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pendingDeliveries
+	if limit > 0 && len(pending) > limit {
+		pending = pending[:limit]
+	}
+	deliveries := make([]*models.WebhookDelivery, len(pending))
+	for i, entry := range pending {
+		deliveries[i] = copyWebhookDelivery(s.webhookDeliveries[entry.id])
+	}
+	return deliveries, nil
+}
+
+// pendingDelivery is an entry in the index of pending webhook deliveries.
+type pendingDelivery struct {
+	nextAttemptAt time.Time
+	seq           int
+	id            string
+}
+
+// comparePendingDeliveries orders pending deliveries by their next attempt and then oldest first.
+func comparePendingDeliveries(a, b pendingDelivery) int {
+	if c := a.nextAttemptAt.Compare(b.nextAttemptAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.seq, b.seq)
+}
+
+// indexPending adds delivery to the index of pending deliveries, if it is pending. s.mu must be held.
+func (s *MemoryStore) indexPending(delivery *models.WebhookDelivery) {
+	if delivery.Status != models.DeliveryPending || delivery.NextAttemptAt == nil {
+		return
+	}
+	entry := pendingDelivery{nextAttemptAt: *delivery.NextAttemptAt, seq: s.deliverySeqs[delivery.ID], id: delivery.ID}
+	i, _ := slices.BinarySearchFunc(s.pendingDeliveries, entry, comparePendingDeliveries)
+	s.pendingDeliveries = slices.Insert(s.pendingDeliveries, i, entry)
+}
+
+// unindexPending removes delivery from the index of pending deliveries, if it is there. s.mu must be held.
+func (s *MemoryStore) unindexPending(delivery *models.WebhookDelivery) {
+	if delivery.Status != models.DeliveryPending || delivery.NextAttemptAt == nil {
+		return
+	}
+	entry := pendingDelivery{nextAttemptAt: *delivery.NextAttemptAt, seq: s.deliverySeqs[delivery.ID], id: delivery.ID}
+	if i, found := slices.BinarySearchFunc(s.pendingDeliveries, entry, comparePendingDeliveries); found {
+		s.pendingDeliveries = slices.Delete(s.pendingDeliveries, i, i+1)
+	}
+}
+
+// copyWebhookEndpoint returns a copy of endpoint that shares none of its slices.
+func copyWebhookEndpoint(endpoint *models.WebhookEndpoint) *models.WebhookEndpoint {
+	copied := *endpoint
+	copied.EventTypes = slices.Clone(endpoint.EventTypes)
+	return &copied
+}
+
+// copyWebhookDelivery returns a copy of delivery that shares none of its slices or pointers.
+func copyWebhookDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	copied := *delivery
+	copied.Event.Data = slices.Clone(delivery.Event.Data)
+	copied.Attempts = slices.Clone(delivery.Attempts)
+	if delivery.NextAttemptAt != nil {
+		next := *delivery.NextAttemptAt
+		copied.NextAttemptAt = &next
+	}
+	return &copied
+}
+
+// AddWebhookEndpoint appends endpoint to the log and then stores it in the index.
+func (s *FileStore) AddWebhookEndpoint(endpoint *models.WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypeWebhookEndpoint, WebhookEndpoint: endpoint}); err != nil {
+		return err
+	}
+	return s.index.AddWebhookEndpoint(endpoint)
+}
+
+// GetWebhookEndpoint returns the endpoint with the given ID, or ErrWebhookEndpointNotFound.
+func (s *FileStore) GetWebhookEndpoint(id string) (*models.WebhookEndpoint, error) {
+	return s.index.GetWebhookEndpoint(id)
+}
+
+// ListWebhookEndpoints returns the endpoints of the merchant with the given ID, oldest first.
+func (s *FileStore) ListWebhookEndpoints(merchantID string) ([]*models.WebhookEndpoint, error) {
+	return s.index.ListWebhookEndpoints(merchantID)
+}
+
+// DeleteWebhookEndpoint appends a deletion of the endpoint with the given ID to the log, and then deletes
+// it from the index.
+func (s *FileStore) DeleteWebhookEndpoint(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.index.GetWebhookEndpoint(id); err != nil {
+		return err
+	}
+	record := logRecord{Type: recordTypeWebhookEndpointDeleted, WebhookEndpoint: &models.WebhookEndpoint{ID: id}}
+	if err := s.append(record); err != nil {
+		return err
+	}
+	return s.index.DeleteWebhookEndpoint(id)
+}
+
+// AddWebhookDelivery appends delivery to the log and then stores it in the index.
+func (s *FileStore) AddWebhookDelivery(delivery *models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Type: recordTypeWebhookDelivery, WebhookDelivery: delivery}); err != nil {
+		return err
+	}
+	return s.index.AddWebhookDelivery(delivery)
+}
+
+// ListWebhookDeliveries returns the deliveries to the endpoint with the given ID, oldest first.
+func (s *FileStore) ListWebhookDeliveries(endpointID string) ([]*models.WebhookDelivery, error) {
+	return s.index.ListWebhookDeliveries(endpointID)
+}
+
+// ListPendingWebhookDeliveries returns the first limit pending deliveries, or every one if limit is 0,
+// ordered by their next attempt and then oldest first.
+func (s *FileStore) ListPendingWebhookDeliveries(limit int) ([]*models.WebhookDelivery, error) {
+	return s.index.ListPendingWebhookDeliveries(limit)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
+
+/*
+webhookWorkers send webhook deliveries in the background, so that finding due deliveries never waits for
+them to be sent:
+  - Each endpoint has a queue of deliveries, oldest first, and up to concurrency workers that send them, so
+    that a slow endpoint only holds up its own deliveries
+  - No more than maxConcurrentWebhookDeliveries are sent at once across all endpoints
+  - A delivery is in flight from when it is queued until its attempt has been stored, and is not queued
+    again while it is
+*/
+type webhookWorkers struct {
+	mu          sync.Mutex
+	concurrency int
+	queues      map[string][]*models.WebhookDelivery // by endpoint ID
+	running     map[string]int                       // number of workers by endpoint ID
+	inFlight    map[string]bool                      // by delivery ID
+	sending     chan struct{}
+	wg          sync.WaitGroup
+}
+
+// newWebhookWorkers instantiates webhookWorkers with up to concurrency workers for each endpoint.
+func newWebhookWorkers(concurrency int) *webhookWorkers {
+	return &webhookWorkers{
+		concurrency: concurrency,
+		queues:      make(map[string][]*models.WebhookDelivery),
+		running:     make(map[string]int),
+		inFlight:    make(map[string]bool),
+		sending:     make(chan struct{}, maxConcurrentWebhookDeliveries),
+	}
+}
+
+// queue adds delivery to the queue of its endpoint to be sent by attempt, unless it is already in flight,
+// and starts another worker for the endpoint if it has fewer than concurrency. Workers stop once their
+// queue is empty, or when ctx is done, dropping the deliveries left in it. w.mu must be held.
+func (w *webhookWorkers) queue(ctx context.Context, delivery *models.WebhookDelivery, attempt func(context.Context, *models.WebhookDelivery)) {
+	if w.inFlight[delivery.ID] {
+		return
+	}
+	w.inFlight[delivery.ID] = true
+	w.queues[delivery.EndpointID] = append(w.queues[delivery.EndpointID], delivery)
+	if w.running[delivery.EndpointID] < w.concurrency {
+		w.running[delivery.EndpointID]++
+		w.wg.Add(1)
+		go w.work(ctx, delivery.EndpointID, attempt)
+	}
+}
+
+// work sends the deliveries in the queue of the endpoint with the given ID until it is empty or ctx is done.
+func (w *webhookWorkers) work(ctx context.Context, endpointID string, attempt func(context.Context, *models.WebhookDelivery)) {
+	defer w.wg.Done()
+	for {
+		w.mu.Lock()
+		queue := w.queues[endpointID]
+		if len(queue) == 0 || ctx.Err() != nil {
+			for _, delivery := range queue {
+				delete(w.inFlight, delivery.ID)
+			}
+			delete(w.queues, endpointID)
+			if w.running[endpointID]--; w.running[endpointID] == 0 {
+				delete(w.running, endpointID)
+			}
+			w.mu.Unlock()
+			return
+		}
+		delivery := queue[0]
+		w.queues[endpointID] = queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case w.sending <- struct{}{}:
+			attempt(ctx, delivery)
+			<-w.sending
+		case <-ctx.Done():
+		}
+
+		w.mu.Lock()
+		delete(w.inFlight, delivery.ID)
+		w.mu.Unlock()
+	}
+}
+
+// wait waits for every worker to stop.
+func (w *webhookWorkers) wait() {
+	w.wg.Wait()
+}
@@ -0,0 +1,477 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// WebhooksPath is the path of the endpoints for managing a merchant's webhook endpoints.
+	WebhooksPath = "/webhooks"
+	// WebhookEventHeader is set on webhook deliveries to the type of their event.
+	WebhookEventHeader = "X-Webhook-Event"
+	// WebhookDeliveryHeader is set on webhook deliveries to the delivery ID, which stays the same across
+	// retries so that receivers can ignore deliveries they have already handled.
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+
+	// DefaultWebhookTimeout is how long a webhook endpoint has to respond by default.
+	DefaultWebhookTimeout = 10 * time.Second
+	// DefaultWebhookRetryBackoff is how long after a failed first attempt a delivery is retried by default.
+	DefaultWebhookRetryBackoff = 30 * time.Second
+	// DefaultWebhookMaxAttempts is how many times a delivery is attempted by default before it fails.
+	DefaultWebhookMaxAttempts = 8
+	// DefaultWebhookEndpointConcurrency is how many deliveries to one endpoint are sent at once by default.
+	DefaultWebhookEndpointConcurrency = 4
+
+	webhookSecretPrefix = "whsec_"
+	// maxWebhookRetryBackoff caps the exponential backoff between attempts
+	maxWebhookRetryBackoff = 6 * time.Hour
+	// webhookPollInterval is the longest the outbox goes unchecked, which matters when another server
+	// shares the store
+	webhookPollInterval = time.Second
+	// maxConcurrentWebhookDeliveries caps how many deliveries are sent at once, to all endpoints
+	maxConcurrentWebhookDeliveries = 32
+	// webhookBatchSize is how many pending deliveries are read from the outbox at a time
+	webhookBatchSize = 256
+)
+
+// CreateWebhookEndpointHandler handles registering a webhook endpoint for the merchant. The response is the
+// only time its signing secret is shown.
+func (s *Server) CreateWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	request := models.WebhookEndpointRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, r, readError(err, "failed to unmarshal the request"))
+		return
+	}
+	if errs := validateWebhookEndpointRequest(request); len(errs) > 0 {
+		s.writeError(w, r, errs)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to generate webhook secret: %w", err))
+		return
+	}
+	endpoint := &models.WebhookEndpoint{
+		ID:         uuid.New().String(),
+		MerchantID: MerchantIDFromContext(r.Context()),
+		URL:        request.URL,
+		EventTypes: request.EventTypes,
+		Secret:     webhookSecretPrefix + hex.EncodeToString(secret),
+		CreatedAt:  s.clock.Now().UTC(),
+	}
+	if err := s.webhooks.AddWebhookEndpoint(endpoint); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to store webhook endpoint %s: %w", endpoint.ID, err))
+		return
+	}
+	s.logger.InfoContext(r.Context(), "Created webhook endpoint", "endpoint_id", endpoint.ID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(endpoint)
+}
+
+// ListWebhookEndpointsHandler handles listing the merchant's webhook endpoints, oldest first.
+func (s *Server) ListWebhookEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := s.webhooks.ListWebhookEndpoints(MerchantIDFromContext(r.Context()))
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to list webhook endpoints: %w", err))
+		return
+	}
+	for _, endpoint := range endpoints {
+		endpoint.Secret = ""
+	}
+	json.NewEncoder(w).Encode(endpoints)
+}
+
+// DeleteWebhookEndpointHandler handles deleting one of the merchant's webhook endpoints. Its pending
+// deliveries fail at their next attempt.
+func (s *Server) DeleteWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := s.fetchWebhookEndpoint(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	if err := s.webhooks.DeleteWebhookEndpoint(endpoint.ID); err != nil && !errors.Is(err, ErrWebhookEndpointNotFound) {
+		s.writeError(w, r, fmt.Errorf("failed to delete webhook endpoint %s: %w", endpoint.ID, err))
+		return
+	}
+	s.logger.InfoContext(r.Context(), "Deleted webhook endpoint", "endpoint_id", endpoint.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveriesHandler handles listing the deliveries to one of the merchant's webhook endpoints,
+// oldest first, with every attempt to send each of them.
+func (s *Server) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := s.fetchWebhookEndpoint(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	deliveries, err := s.webhooks.ListWebhookDeliveries(endpoint.ID)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to list deliveries of webhook endpoint %s: %w", endpoint.ID, err))
+		return
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// fetchWebhookEndpoint fetches the webhook endpoint with the given ID. If it cannot, or the endpoint belongs
+// to a different merchant than the one making request r, it writes an error response to r and returns false.
+func (s *Server) fetchWebhookEndpoint(w http.ResponseWriter, r *http.Request, id string) (*models.WebhookEndpoint, bool) {
+	endpoint, err := s.webhooks.GetWebhookEndpoint(id)
+	if errors.Is(err, ErrWebhookEndpointNotFound) || err == nil && endpoint.MerchantID != MerchantIDFromContext(r.Context()) {
+		s.writeError(w, r, newError(http.StatusNotFound, CodeWebhookNotFound, "webhook endpoint not found"))
+		return nil, false
+	}
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to fetch webhook endpoint %s: %w", id, err))
+		return nil, false
+	}
+	return endpoint, true
+}
+
+/*
+validateWebhookEndpointRequest returns every invalid field of request:
+  - URL must be an absolute http or https URL
+  - EventTypes must have at least one event type, and only known ones
+*/
+func validateWebhookEndpointRequest(request models.WebhookEndpointRequest) validationErrors {
+	var errs validationErrors
+	invalid := func(code, field, format string, args ...any) {
+		errs = append(errs, models.FieldError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if u, err := url.Parse(request.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		invalid(CodeInvalidWebhookURL, "url", "url should be an absolute http or https URL")
+	}
+	if len(request.EventTypes) == 0 {
+		invalid(CodeInvalidEventType, "event_types", "event_types should have at least one event type")
+	}
+	for _, eventType := range request.EventTypes {
+		if !slices.Contains(models.WebhookEventTypes, eventType) {
+			invalid(CodeInvalidEventType, "event_types", "unknown event type %q", eventType)
+		}
+	}
+	return errs
+}
+
+// paymentEventType returns the type of the webhook event sent when a payment moves to status.
+func paymentEventType(status models.PaymentStatus) models.WebhookEventType {
+	switch status {
+	case models.StatusAuthorized:
+		return models.EventPaymentAuthorized
+	case models.StatusSuccess:
+		return models.EventPaymentSucceeded
+	case models.StatusVoided:
+		return models.EventPaymentVoided
+	case models.StatusPartiallyRefunded, models.StatusRefunded:
+		return models.EventPaymentRefunded
+	default:
+		return models.EventPaymentFailed
+	}
+}
+
+// publish adds a delivery of an event of eventType about data to the outbox, for every webhook endpoint of
+// the merchant with merchantID that subscribes to eventType. Failures are logged rather than returned, so
+// that they do not fail the request that caused the event.
+func (s *Server) publish(merchantID string, eventType models.WebhookEventType, data any) {
+	if s.webhooks == nil {
+		return
+	}
+	endpoints, err := s.webhooks.ListWebhookEndpoints(merchantID)
+	if err != nil {
+		s.logger.Error("Failed to list webhook endpoints", "merchant_id", merchantID, "event_type", eventType, "error", err)
+		return
+	}
+
+	var event *models.WebhookEvent
+	for _, endpoint := range endpoints {
+		if !slices.Contains(endpoint.EventTypes, eventType) {
+			continue
+		}
+		if event == nil {
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				s.logger.Error("Failed to marshal webhook event", "event_type", eventType, "error", err)
+				return
+			}
+			now := s.clock.Now().UTC()
+			event = &models.WebhookEvent{ID: uuid.New().String(), Type: eventType, CreatedAt: now, Data: encoded}
+		}
+
+		delivery := &models.WebhookDelivery{
+			ID:            uuid.New().String(),
+			EndpointID:    endpoint.ID,
+			MerchantID:    merchantID,
+			Event:         *event,
+			Status:        models.DeliveryPending,
+			Attempts:      []models.WebhookAttempt{},
+			NextAttemptAt: &event.CreatedAt,
+			CreatedAt:     event.CreatedAt,
+		}
+		if err := s.webhooks.AddWebhookDelivery(delivery); err != nil {
+			s.logger.Error("Failed to store webhook delivery", "event_type", eventType, "event_id", event.ID, "endpoint_id", endpoint.ID, "error", err)
+		}
+	}
+
+	if event != nil {
+		s.wakeWebhooks()
+	}
+}
+
+/*
+RunWebhooks sends the deliveries in the webhook outbox until ctx is done, and then waits for the attempts
+in progress to finish. It does nothing if the server has no webhook store.
+
+Deliveries are sent as soon as they are due, and signed with their endpoint's secret in the X-Signature
+header like signed requests to the gateway. Up to WebhookEndpointConcurrency deliveries to each endpoint are
+sent at once, started in the order they became due, so deliveries to an endpoint can arrive out of order. A
+delivery succeeds when its endpoint responds with a 2xx status. Otherwise it is retried after a backoff that
+doubles with every attempt, until it has been attempted WebhookMaxAttempts times. Pending deliveries left by
+a previous run are resumed.
+*/
+func (s *Server) RunWebhooks(ctx context.Context) {
+	if s.webhooks == nil {
+		return
+	}
+	defer s.webhookWorkers.wait()
+	for {
+		wait := webhookPollInterval
+		if next := s.deliverDueWebhooks(ctx); !next.IsZero() {
+			wait = min(wait, max(next.Sub(s.clock.Now()), 0))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.webhookWake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// wakeWebhooks wakes RunWebhooks, unless it is already due to wake.
+func (s *Server) wakeWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDueWebhooks queues the pending deliveries that are due to be sent by the webhook workers, from a
+// batch of the first in the outbox, and returns when the next pending delivery that is not in flight is
+// due, or the zero time if there are none. It does not wait for the deliveries to be sent, and the workers
+// wake RunWebhooks after every attempt.
+func (s *Server) deliverDueWebhooks(ctx context.Context) time.Time {
+	workers := s.webhookWorkers
+	// The workers' lock is held while the outbox is read, so that a delivery cannot finish between being
+	// read and being queued, and then be sent again from its stale copy
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+
+	// Deliveries in flight are skipped, so read enough to find a whole batch of others
+	limit := webhookBatchSize + len(workers.inFlight)
+	deliveries, err := s.webhooks.ListPendingWebhookDeliveries(limit)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to list pending webhook deliveries", "error", err)
+		return time.Time{}
+	}
+
+	// Deliveries are ordered by their next attempt, so the due ones come first
+	now := s.clock.Now()
+	for _, delivery := range deliveries {
+		if delivery.NextAttemptAt.After(now) {
+			return *delivery.NextAttemptAt
+		}
+		workers.queue(ctx, delivery, func(ctx context.Context, delivery *models.WebhookDelivery) {
+			s.attemptDelivery(ctx, delivery)
+			s.wakeWebhooks()
+		})
+	}
+	if len(deliveries) == limit {
+		// There may be more due deliveries beyond the batch
+		return now
+	}
+	return time.Time{}
+}
+
+// attemptDelivery sends delivery to its endpoint once, then records the attempt and schedules the next one
+// if it failed.
+func (s *Server) attemptDelivery(ctx context.Context, delivery *models.WebhookDelivery) {
+	attempt := models.WebhookAttempt{Number: len(delivery.Attempts) + 1, Timestamp: s.clock.Now().UTC()}
+	endpoint, err := s.webhooks.GetWebhookEndpoint(delivery.EndpointID)
+	switch {
+	case errors.Is(err, ErrWebhookEndpointNotFound):
+		attempt.Error = "webhook endpoint was deleted"
+	case err != nil:
+		// Leave the delivery as it is, to be attempted again
+		s.logger.ErrorContext(ctx, "Failed to fetch webhook endpoint", "endpoint_id", delivery.EndpointID, "error", err)
+		return
+	default:
+		attempt.StatusCode, err = s.sendWebhook(ctx, endpoint, delivery, attempt.Timestamp)
+		if ctx.Err() != nil {
+			// The server is shutting down, so leave the delivery to be resumed rather than count the attempt
+			return
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	switch {
+	case attempt.Error == "":
+		delivery.Status, delivery.NextAttemptAt = models.DeliverySucceeded, nil
+	case endpoint == nil || attempt.Number >= s.webhookMaxAttempts:
+		delivery.Status, delivery.NextAttemptAt = models.DeliveryFailed, nil
+	default:
+		next := attempt.Timestamp.Add(s.webhookBackoff(attempt.Number))
+		delivery.NextAttemptAt = &next
+	}
+	if err := s.webhooks.AddWebhookDelivery(delivery); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to store webhook delivery", "delivery_id", delivery.ID, "error", err)
+		return
+	}
+	s.logger.InfoContext(ctx, "Attempted webhook delivery", "delivery_id", delivery.ID, "endpoint_id", delivery.EndpointID, "merchant_id", delivery.MerchantID, "attempt", attempt.Number, "status", delivery.Status)
+}
+
+// sendWebhook posts the event of delivery to endpoint, signed at time now, and returns the response status.
+// An error is returned if there is no response or the status is not 2xx.
+func (s *Server) sendWebhook(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery, now time.Time) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(signing.Header, signing.Sign(endpoint.Secret, now, http.MethodPost, path, body))
+	request.Header.Set(WebhookEventHeader, string(delivery.Event.Type))
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+
+	response, err := s.webhookClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("endpoint responded with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// errWebhookAddressNotPublic is the error of webhook deliveries to endpoints that resolve to an address that
+// is not on the public internet.
+var errWebhookAddressNotPublic = errors.New("webhook endpoint address is not public")
+
+/*
+newWebhookClient returns the default client for webhook deliveries, which times out after
+DefaultWebhookTimeout. Merchants choose the URLs that webhooks are sent to, so unless allowPrivate is set the
+client does not let them reach the gateway's own network:
+  - It refuses to connect to addresses that are not public, like loopback, link-local, private and
+    carrier-grade NAT addresses, and those of NAT64 that can reach them. They are checked once
+    resolved, at connect time, so that a host name cannot resolve to a public address when the endpoint is
+    created and a private one later
+  - It does not follow redirects, which count as failed attempts like any other non-2xx response
+  - It ignores proxy environment variables, since a proxy would connect on its behalf
+*/
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: DefaultWebhookTimeout}
+	if !allowPrivate {
+		dialer.Control = checkWebhookAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   DefaultWebhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// nonPublicPrefixes are the address ranges that webhooks are not sent to: those that are not on the public
+// internet, and those that can be translated to them, like NAT64.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link-local, including cloud metadata services
+	netip.MustParsePrefix("172.16.0.0/12"),   // private
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.168.0.0/16"),  // private
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and broadcast
+	netip.MustParsePrefix("::/128"),          // unspecified
+	netip.MustParsePrefix("::1/128"),         // loopback
+	netip.MustParsePrefix("::ffff:0:0/96"),   // IPv4-mapped, which are unmapped before being checked
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which can embed any IPv4 address
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link-local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// checkWebhookAddress is a net.Dialer Control function that returns errWebhookAddressNotPublic for
+// connections to addresses in nonPublicPrefixes.
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse webhook endpoint address %q: %w", address, err)
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s", errWebhookAddressNotPublic, addr)
+		}
+	}
+	return nil
+}
+
+// webhookBackoff returns how long to wait after failed attempt number attempt before the next attempt.
+func (s *Server) webhookBackoff(attempt int) time.Duration {
+	backoff := s.webhookRetryBackoff
+	for i := 1; i < attempt && backoff < maxWebhookRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxWebhookRetryBackoff)
+}
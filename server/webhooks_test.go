@@ -0,0 +1,606 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/celestebrant/processout-payment-gateway/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// receivedWebhook is a request received by a webhookReceiver.
+type receivedWebhook struct {
+	header http.Header
+	path   string
+	body   []byte
+}
+
+// webhookReceiver is a webhook endpoint that records the requests it is sent. It responds with each of
+// statuses in turn, and then with 200 OK.
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	received []receivedWebhook
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.received = append(receiver.received, receivedWebhook{r.Header, r.URL.EscapedPath(), body})
+		if len(receiver.statuses) > 0 {
+			w.WriteHeader(receiver.statuses[0])
+			receiver.statuses = receiver.statuses[1:]
+		}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// requests returns the requests received so far.
+func (r *webhookReceiver) requests() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.received...)
+}
+
+// createWebhookEndpoint registers url for eventTypes as testMerchantID, and returns the endpoint.
+func createWebhookEndpoint(t *testing.T, s *Server, url string, eventTypes ...models.WebhookEventType) models.WebhookEndpoint {
+	t.Helper()
+	response := serve(t, s, "POST", WebhooksPath, models.WebhookEndpointRequest{URL: url, EventTypes: eventTypes})
+	require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+	endpoint := models.WebhookEndpoint{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&endpoint), "failed to unmarshal response")
+	return endpoint
+}
+
+// deliverWebhooks sends the due webhook deliveries of s, waits for them to be attempted, and returns when the
+// next pending delivery is due.
+func deliverWebhooks(s *Server) time.Time {
+	next := s.deliverDueWebhooks(context.Background())
+	s.webhookWorkers.wait()
+	return next
+}
+
+// listDeliveries lists the deliveries to the endpoint with the given ID as testMerchantID.
+func listDeliveries(t *testing.T, s *Server, endpointID string) []models.WebhookDelivery {
+	t.Helper()
+	response := serve(t, s, "GET", WebhooksPath+"/"+endpointID+"/deliveries", nil)
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	deliveries := []models.WebhookDelivery{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&deliveries), "failed to unmarshal response")
+	return deliveries
+}
+
+func TestWebhookEndpointHandlers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("create, list and delete", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+
+		created := createWebhookEndpoint(t, s, "https://merchant.example/hooks", models.EventPaymentSucceeded)
+		r.Equal(testMerchantID, created.MerchantID)
+		r.True(strings.HasPrefix(created.Secret, webhookSecretPrefix), "secret should be shown on creation")
+
+		response := serve(t, s, "GET", WebhooksPath, nil)
+		r.Equal(http.StatusOK, response.Code)
+		endpoints := []models.WebhookEndpoint{}
+		r.NoError(json.NewDecoder(response.Body).Decode(&endpoints))
+		created.Secret = ""
+		r.Equal([]models.WebhookEndpoint{created}, endpoints, "secret should not be listed")
+
+		response = serve(t, s, "DELETE", WebhooksPath+"/"+created.ID, nil)
+		r.Equal(http.StatusNoContent, response.Code)
+		response = serve(t, s, "DELETE", WebhooksPath+"/"+created.ID, nil)
+		r.Equal(http.StatusNotFound, response.Code)
+		r.Equal(CodeWebhookNotFound, decodeError(t, response).Code)
+	})
+
+	t.Run("other merchants' endpoints are not found", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		r.NoError(s.merchants.AddMerchant(newTestMerchant("other-merchant")))
+		otherKey, err := s.apiKeys.IssueKey("other-merchant")
+		r.NoError(err)
+
+		endpoint := createWebhookEndpoint(t, s, "https://merchant.example/hooks", models.EventPaymentSucceeded)
+		response := serveWithKey(t, s, otherKey, "GET", WebhooksPath+"/"+endpoint.ID+"/deliveries", nil)
+		r.Equal(http.StatusNotFound, response.Code)
+		response = serveWithKey(t, s, otherKey, "DELETE", WebhooksPath+"/"+endpoint.ID, nil)
+		r.Equal(http.StatusNotFound, response.Code)
+	})
+
+	t.Run("invalid endpoints are rejected", func(t *testing.T) {
+		type testCase struct {
+			name           string
+			request        models.WebhookEndpointRequest
+			expectedErrors []models.FieldError
+		}
+
+		testCases := []testCase{
+			{
+				"relative URL",
+				models.WebhookEndpointRequest{URL: "/hooks", EventTypes: []models.WebhookEventType{models.EventPaymentFailed}},
+				[]models.FieldError{{Code: CodeInvalidWebhookURL, Message: "url should be an absolute http or https URL", Field: "url"}},
+			}, {
+				"unsupported scheme",
+				models.WebhookEndpointRequest{URL: "ftp://merchant.example", EventTypes: []models.WebhookEventType{models.EventPaymentFailed}},
+				[]models.FieldError{{Code: CodeInvalidWebhookURL, Message: "url should be an absolute http or https URL", Field: "url"}},
+			}, {
+				"no event types",
+				models.WebhookEndpointRequest{URL: "https://merchant.example"},
+				[]models.FieldError{{Code: CodeInvalidEventType, Message: "event_types should have at least one event type", Field: "event_types"}},
+			}, {
+				"unknown event type",
+				models.WebhookEndpointRequest{URL: "https://merchant.example", EventTypes: []models.WebhookEventType{"payment.created"}},
+				[]models.FieldError{{Code: CodeInvalidEventType, Message: `unknown event type "payment.created"`, Field: "event_types"}},
+			},
+		}
+
+		s := newTestServerWithBank(t, &fakeBank{})
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := require.New(t)
+				response := serve(t, s, "POST", WebhooksPath, tc.request)
+				r.Equal(http.StatusBadRequest, response.Code)
+				errorResponse := decodeError(t, response)
+				r.Equal(CodeValidationFailed, errorResponse.Code)
+				r.Equal(tc.expectedErrors, errorResponse.Errors)
+			})
+		}
+	})
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestServerWithBank(t, &fakeBank{})
+	s.clock = clock
+	receiver := newWebhookReceiver(t)
+	endpoint := createWebhookEndpoint(t, s, receiver.URL+"/hooks", models.EventPaymentSucceeded, models.EventRefundCreated)
+
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code)
+	payment := decodePayment(t, response)
+	response = serve(t, s, "POST", utils.Path+"/"+payment.ID+"/refunds", models.CreateRefundRequest{Amount: "100"})
+	r.Equal(http.StatusCreated, response.Code)
+
+	deliverWebhooks(s)
+
+	// The payment.refunded event is not subscribed to, so it is not sent. Deliveries to an endpoint are sent
+	// concurrently, so they can arrive in any order.
+	requests := receiver.requests()
+	r.Len(requests, 2)
+	if requests[0].header.Get(WebhookEventHeader) != string(models.EventPaymentSucceeded) {
+		requests[0], requests[1] = requests[1], requests[0]
+	}
+	for i, expectedType := range []models.WebhookEventType{models.EventPaymentSucceeded, models.EventRefundCreated} {
+		request := requests[i]
+		r.Equal("/hooks", request.path)
+		r.Equal(string(expectedType), request.header.Get(WebhookEventHeader))
+		r.NoError(signing.Verify(endpoint.Secret, request.header.Get(signing.Header), clock.Now(), signing.DefaultTolerance, "POST", request.path, request.body))
+
+		event := models.WebhookEvent{}
+		r.NoError(json.Unmarshal(request.body, &event))
+		r.Equal(expectedType, event.Type)
+		data := struct {
+			ID        string `json:"id"`
+			PaymentID string `json:"payment_id"`
+		}{}
+		r.NoError(json.Unmarshal(event.Data, &data))
+		r.Contains([]string{data.ID, data.PaymentID}, payment.ID)
+	}
+
+	deliveries := listDeliveries(t, s, endpoint.ID)
+	r.Len(deliveries, 2)
+	r.Equal(models.DeliverySucceeded, deliveries[0].Status)
+	r.Equal([]models.WebhookAttempt{{Number: 1, Timestamp: clock.Now(), StatusCode: http.StatusOK}}, deliveries[0].Attempts)
+	r.Nil(deliveries[0].NextAttemptAt)
+	r.Equal(deliveries[0].ID, requests[0].header.Get(WebhookDeliveryHeader))
+
+	deliverWebhooks(s)
+	r.Len(receiver.requests(), 2, "succeeded deliveries should not be sent again")
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+
+	// newRetryServer returns a server that attempts deliveries 3 times, a minute apart and then 2 minutes
+	// apart, with an endpoint at receiver subscribed to payment.succeeded, and a payment to deliver.
+	newRetryServer := func(t *testing.T, receiver *webhookReceiver) (*Server, *fakeClock, models.WebhookEndpoint) {
+		t.Helper()
+		clock := newFakeClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+		s := newTestServerWithBank(t, &fakeBank{})
+		s.clock = clock
+		s.webhookRetryBackoff = time.Minute
+		s.webhookMaxAttempts = 3
+		endpoint := createWebhookEndpoint(t, s, receiver.URL, models.EventPaymentSucceeded)
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		require.Equal(t, http.StatusOK, response.Code)
+		return s, clock, endpoint
+	}
+
+	t.Run("failed attempts are retried with backoff", func(t *testing.T) {
+		r := require.New(t)
+		receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+		s, clock, endpoint := newRetryServer(t, receiver)
+		start := clock.Now()
+
+		deliverWebhooks(s)
+		deliverWebhooks(s)
+		r.Len(receiver.requests(), 1, "delivery should not be retried before its backoff")
+
+		clock.Advance(time.Minute)
+		deliverWebhooks(s)
+		clock.Advance(time.Minute)
+		deliverWebhooks(s)
+		r.Len(receiver.requests(), 2, "backoff should double after the second attempt")
+
+		clock.Advance(time.Minute)
+		next := deliverWebhooks(s)
+		r.True(next.IsZero(), "there should be no pending deliveries")
+		requests := receiver.requests()
+		r.Len(requests, 3)
+		r.Equal(requests[0].header.Get(WebhookDeliveryHeader), requests[2].header.Get(WebhookDeliveryHeader))
+
+		deliveries := listDeliveries(t, s, endpoint.ID)
+		r.Len(deliveries, 1)
+		r.Equal(models.DeliverySucceeded, deliveries[0].Status)
+		r.Equal([]models.WebhookAttempt{
+			{Number: 1, Timestamp: start, StatusCode: 500, Error: "endpoint responded with status 500"},
+			{Number: 2, Timestamp: start.Add(time.Minute), StatusCode: 503, Error: "endpoint responded with status 503"},
+			{Number: 3, Timestamp: start.Add(3 * time.Minute), StatusCode: 200},
+		}, deliveries[0].Attempts)
+	})
+
+	t.Run("delivery fails after the last attempt", func(t *testing.T) {
+		r := require.New(t)
+		receiver := newWebhookReceiver(t, 500, 500, 500, 500)
+		s, clock, endpoint := newRetryServer(t, receiver)
+
+		for i := 0; i < 5; i++ {
+			deliverWebhooks(s)
+			clock.Advance(time.Hour)
+		}
+
+		r.Len(receiver.requests(), 3)
+		deliveries := listDeliveries(t, s, endpoint.ID)
+		r.Equal(models.DeliveryFailed, deliveries[0].Status)
+		r.Len(deliveries[0].Attempts, 3)
+		r.Nil(deliveries[0].NextAttemptAt)
+	})
+
+	t.Run("deliveries to deleted endpoints fail", func(t *testing.T) {
+		r := require.New(t)
+		receiver := newWebhookReceiver(t)
+		s, _, endpoint := newRetryServer(t, receiver)
+
+		response := serve(t, s, "DELETE", WebhooksPath+"/"+endpoint.ID, nil)
+		r.Equal(http.StatusNoContent, response.Code)
+		deliverWebhooks(s)
+
+		r.Empty(receiver.requests())
+		deliveries, err := s.webhooks.ListWebhookDeliveries(endpoint.ID)
+		r.NoError(err)
+		r.Equal(models.DeliveryFailed, deliveries[0].Status)
+		r.Equal("webhook endpoint was deleted", deliveries[0].Attempts[0].Error)
+	})
+}
+
+func TestWebhookClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("non-public addresses are refused", func(t *testing.T) {
+		type testCase struct {
+			address string
+			allowed bool
+		}
+
+		testCases := []testCase{
+			{"127.0.0.1:80", false},
+			{"[::1]:443", false},
+			{"[::ffff:127.0.0.1]:80", false},
+			{"10.0.0.1:80", false},
+			{"172.16.0.1:80", false},
+			{"192.168.1.1:80", false},
+			{"169.254.169.254:80", false},
+			{"[fe80::1]:80", false},
+			{"[fd00::1]:80", false},
+			{"0.0.0.0:80", false},
+			{"0.1.2.3:80", false},
+			{"100.64.0.1:80", false},
+			{"198.18.0.1:80", false},
+			{"224.0.0.1:80", false},
+			{"255.255.255.255:80", false},
+			{"[::ffff:10.0.0.1]:80", false},
+			{"[::ffff:169.254.169.254]:80", false},
+			{"[64:ff9b::a9fe:a9fe]:80", false},
+			{"[ff02::1]:80", false},
+			{"[::]:80", false},
+			{"93.184.216.34:443", true},
+			{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.address, func(t *testing.T) {
+				err := checkWebhookAddress("tcp", tc.address, nil)
+				if tc.allowed {
+					require.NoError(t, err)
+				} else {
+					require.ErrorIs(t, err, errWebhookAddressNotPublic)
+				}
+			})
+		}
+	})
+
+	t.Run("deliveries to loopback receivers fail", func(t *testing.T) {
+		r := require.New(t)
+		s := newTestServerWithBank(t, &fakeBank{})
+		s.webhookClient = newWebhookClient(false)
+		receiver := newWebhookReceiver(t)
+		endpoint := createWebhookEndpoint(t, s, receiver.URL, models.EventPaymentSucceeded)
+
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, response.Code)
+		deliverWebhooks(s)
+
+		r.Empty(receiver.requests())
+		deliveries := listDeliveries(t, s, endpoint.ID)
+		r.Equal(models.DeliveryPending, deliveries[0].Status)
+		r.Contains(deliveries[0].Attempts[0].Error, errWebhookAddressNotPublic.Error())
+	})
+
+	t.Run("redirects are not followed", func(t *testing.T) {
+		r := require.New(t)
+		target := newWebhookReceiver(t)
+		redirector := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+		defer redirector.Close()
+
+		s := newTestServerWithBank(t, &fakeBank{})
+		endpoint := createWebhookEndpoint(t, s, redirector.URL, models.EventPaymentSucceeded)
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, response.Code)
+		deliverWebhooks(s)
+
+		r.Empty(target.requests())
+		deliveries := listDeliveries(t, s, endpoint.ID)
+		r.Equal(http.StatusTemporaryRedirect, deliveries[0].Attempts[0].StatusCode)
+		r.Equal(models.DeliveryPending, deliveries[0].Status, "redirected delivery should be retried")
+	})
+}
+
+func TestConcurrentWebhookDelivery(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	// The slow receiver holds every request until it is released, recording how many it holds at once
+	var (
+		mu       sync.Mutex
+		held     int
+		maxHeld  int
+		received int
+	)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		mu.Lock()
+		held++
+		received++
+		maxHeld = max(maxHeld, held)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		held--
+		mu.Unlock()
+	}))
+	defer slow.Close()
+	holding := func(count int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return held == count
+		}
+	}
+
+	s := newTestServerWithBank(t, &fakeBank{})
+	s.webhookWorkers = newWebhookWorkers(2)
+	slowEndpoint := createWebhookEndpoint(t, s, slow.URL, models.EventPaymentSucceeded)
+	for i := 0; i < 5; i++ {
+		response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+		r.Equal(http.StatusOK, response.Code)
+	}
+	s.deliverDueWebhooks(context.Background())
+	r.Eventually(holding(2), 5*time.Second, 10*time.Millisecond, "deliveries to an endpoint should be capped")
+
+	// While the slow endpoint holds its deliveries, a later pass still sends other endpoints' deliveries
+	fast := newWebhookReceiver(t)
+	createWebhookEndpoint(t, s, fast.URL, models.EventPaymentSucceeded)
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code)
+	s.deliverDueWebhooks(context.Background())
+	r.Eventually(func() bool { return len(fast.requests()) == 1 }, 5*time.Second, 10*time.Millisecond, "a slow endpoint should not hold up others")
+
+	close(release)
+	s.webhookWorkers.wait()
+	r.Equal(2, maxHeld)
+	r.Equal(6, received, "deliveries in flight should not be sent again by a later pass")
+	for _, delivery := range listDeliveries(t, s, slowEndpoint.ID) {
+		r.Equal(models.DeliverySucceeded, delivery.Status)
+	}
+}
+
+func TestNewRejectsNegativeWebhookConfig(t *testing.T) {
+	t.Parallel()
+	store := newTestStore()
+	config := Config{Store: store, Merchants: store, Webhooks: store, Bank: &fakeBank{}, APIKeys: newTestAPIKeys()}
+
+	type testCase struct {
+		name      string
+		configure func(*Config)
+	}
+
+	testCases := []testCase{
+		{"retry backoff", func(c *Config) { c.WebhookRetryBackoff = -time.Second }},
+		{"max attempts", func(c *Config) { c.WebhookMaxAttempts = -1 }},
+		{"endpoint concurrency", func(c *Config) { c.WebhookEndpointConcurrency = -1 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			invalid := config
+			tc.configure(&invalid)
+			require.Panics(t, func() { New(invalid) })
+		})
+	}
+}
+
+func TestPendingWebhookDeliveries(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	store := NewMemoryStore()
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	add := func(id string, status models.WebhookDeliveryStatus, nextAttemptAt time.Time) {
+		t.Helper()
+		delivery := &models.WebhookDelivery{ID: id, EndpointID: "endpoint", Status: status, NextAttemptAt: &nextAttemptAt, CreatedAt: now}
+		if status != models.DeliveryPending {
+			delivery.NextAttemptAt = nil
+		}
+		r.NoError(store.AddWebhookDelivery(delivery))
+	}
+	ids := func(deliveries []*models.WebhookDelivery) []string {
+		ids := make([]string, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		return ids
+	}
+
+	add("later", models.DeliveryPending, now.Add(time.Minute))
+	add("first", models.DeliveryPending, now)
+	add("second", models.DeliveryPending, now)
+	add("succeeded", models.DeliveryPending, now)
+	add("succeeded", models.DeliverySucceeded, now)
+	add("retried", models.DeliveryPending, now)
+	add("retried", models.DeliveryPending, now.Add(2*time.Minute))
+
+	deliveries, err := store.ListPendingWebhookDeliveries(0)
+	r.NoError(err)
+	r.Equal([]string{"first", "second", "later", "retried"}, ids(deliveries), "deliveries due at the same time should be oldest first")
+
+	deliveries, err = store.ListPendingWebhookDeliveries(2)
+	r.NoError(err)
+	r.Equal([]string{"first", "second"}, ids(deliveries))
+}
+
+func TestRunWebhooks(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	receiver := newWebhookReceiver(t)
+	s := newTestServerWithBank(t, &fakeBank{})
+	createWebhookEndpoint(t, s, receiver.URL, models.EventPaymentSucceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.RunWebhooks(ctx)
+		close(done)
+	}()
+
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code)
+	r.Eventually(func() bool { return len(receiver.requests()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestWebhookDeliveryInterruptedByShutdown(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	started := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reading the body lets the server notice when the client goes away
+		io.ReadAll(r.Body)
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer receiver.Close()
+
+	s := newTestServerWithBank(t, &fakeBank{})
+	endpoint := createWebhookEndpoint(t, s, receiver.URL, models.EventPaymentSucceeded)
+	response := serve(t, s, "POST", utils.Path, utils.ValidProcessPaymentRequest())
+	r.Equal(http.StatusOK, response.Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	s.deliverDueWebhooks(ctx)
+	s.webhookWorkers.wait()
+
+	deliveries := listDeliveries(t, s, endpoint.ID)
+	r.Equal(models.DeliveryPending, deliveries[0].Status, "interrupted delivery should be resumed after a restart")
+	r.Empty(deliveries[0].Attempts, "interrupted attempt should not count")
+}
+
+func TestWebhookOutboxIsPersisted(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "payments.log")
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := OpenFileStore(path)
+	r.NoError(err)
+	endpoint := &models.WebhookEndpoint{ID: "endpoint", MerchantID: testMerchantID, URL: "https://merchant.example", CreatedAt: now}
+	r.NoError(store.AddWebhookEndpoint(endpoint))
+	r.NoError(store.AddWebhookEndpoint(&models.WebhookEndpoint{ID: "deleted", MerchantID: testMerchantID, CreatedAt: now}))
+	r.NoError(store.DeleteWebhookEndpoint("deleted"))
+
+	pending := &models.WebhookDelivery{
+		ID:            "pending",
+		EndpointID:    "endpoint",
+		Event:         models.WebhookEvent{ID: "event", Type: models.EventPaymentFailed, CreatedAt: now, Data: json.RawMessage(`{}`)},
+		Status:        models.DeliveryPending,
+		Attempts:      []models.WebhookAttempt{{Number: 1, Timestamp: now, StatusCode: 500, Error: "endpoint responded with status 500"}},
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+	}
+	r.NoError(store.AddWebhookDelivery(pending))
+	succeeded := *pending
+	succeeded.ID, succeeded.Status, succeeded.NextAttemptAt = "succeeded", models.DeliverySucceeded, nil
+	r.NoError(store.AddWebhookDelivery(&succeeded))
+	r.NoError(store.Close())
+
+	reopened, err := OpenFileStore(path)
+	r.NoError(err)
+	defer reopened.Close()
+
+	endpoints, err := reopened.ListWebhookEndpoints(testMerchantID)
+	r.NoError(err)
+	r.Equal([]*models.WebhookEndpoint{endpoint}, endpoints, "deleted endpoint should stay deleted after reopening")
+
+	deliveries, err := reopened.ListPendingWebhookDeliveries(0)
+	r.NoError(err)
+	r.Equal([]*models.WebhookDelivery{pending}, deliveries, "pending delivery should be resumed after reopening")
+	deliveries, err = reopened.ListWebhookDeliveries("endpoint")
+	r.NoError(err)
+	r.Len(deliveries, 2)
+}
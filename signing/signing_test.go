@@ -0,0 +1,66 @@
+package signing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	signedAt := time.Date(2024, 7, 11, 22, 4, 40, 0, time.UTC)
+	body := []byte(`{"amount":"1005"}`)
+	signature := Sign("secret", signedAt, "POST", "/payments", body)
+
+	type testCase struct {
+		name          string
+		secret        string
+		header        string
+		now           time.Time
+		method        string
+		path          string
+		body          []byte
+		expectedError error
+	}
+
+	testCases := []testCase{
+		{"valid signature", "secret", signature, signedAt, "POST", "/payments", body, nil},
+		{"valid at the edge of the tolerance", "secret", signature, signedAt.Add(DefaultTolerance), "POST", "/payments", body, nil},
+		{"valid with clock skew", "secret", signature, signedAt.Add(-DefaultTolerance), "POST", "/payments", body, nil},
+		{"too old", "secret", signature, signedAt.Add(DefaultTolerance + time.Second), "POST", "/payments", body, ErrExpired},
+		{"from the future", "secret", signature, signedAt.Add(-DefaultTolerance - time.Second), "POST", "/payments", body, ErrExpired},
+		{"wrong secret", "other", signature, signedAt, "POST", "/payments", body, ErrMismatch},
+		{"changed method", "secret", signature, signedAt, "PUT", "/payments", body, ErrMismatch},
+		{"changed path", "secret", signature, signedAt, "POST", "/payments/other", body, ErrMismatch},
+		{"changed body", "secret", signature, signedAt, "POST", "/payments", []byte(`{"amount":"9999"}`), ErrMismatch},
+		{
+			"one of several signatures matches",
+			"secret",
+			signature + ",v1=" + Sign("old", signedAt, "POST", "/payments", body)[len("t=1720735480,v1="):],
+			signedAt, "POST", "/payments", body, nil,
+		},
+		{"empty", "secret", "", signedAt, "POST", "/payments", body, ErrMalformed},
+		{"no timestamp", "secret", "v1=abcd", signedAt, "POST", "/payments", body, ErrMalformed},
+		{"no signature", "secret", "t=1720735480", signedAt, "POST", "/payments", body, ErrMalformed},
+		{"signature not hex", "secret", "t=1720735480,v1=xyz", signedAt, "POST", "/payments", body, ErrMalformed},
+		{"timestamp not a number", "secret", "t=yesterday,v1=abcd", signedAt, "POST", "/payments", body, ErrMalformed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Verify(tc.secret, tc.header, tc.now, DefaultTolerance, tc.method, tc.path, tc.body)
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	signature := Sign("secret", time.Unix(1720735480, 0), "GET", "/payments/some-id", nil)
+	r.Regexp(`^t=1720735480,v1=[0-9a-f]{64}$`, signature)
+	r.Equal(signature, Sign("secret", time.Unix(1720735480, 0), "GET", "/payments/some-id", []byte{}), "no body should sign as an empty body")
+}
@@ -2,31 +2,68 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/celestebrant/processout-payment-gateway/bank"
+	"github.com/celestebrant/processout-payment-gateway/mockbank"
 	"github.com/celestebrant/processout-payment-gateway/models"
 	"github.com/celestebrant/processout-payment-gateway/server"
+	"github.com/celestebrant/processout-payment-gateway/signing"
+	"github.com/celestebrant/processout-payment-gateway/tracing"
 	"github.com/celestebrant/processout-payment-gateway/utils"
 	"github.com/google/uuid"
-	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// apiKey is the key that the e2e tests authenticate with.
+const apiKey = "sk_e2e"
+
+// newStore returns a memory store holding the merchant that apiKey belongs to.
+func newStore() *server.MemoryStore {
+	store := server.NewMemoryStore()
+	store.AddMerchant(&models.Merchant{ID: "e2e-merchant", Name: "E2E", Status: models.MerchantActive, DefaultCurrency: "GBP"})
+	return store
+}
+
+// newAPIKeys returns an APIKeyStore holding apiKey.
+func newAPIKeys() *server.APIKeyStore {
+	keys := server.NewAPIKeyStore(server.SystemClock)
+	keys.AddHashedKey("e2e-merchant", server.HashAPIKey(apiKey))
+	return keys
+}
+
+// newRequest creates a request authenticated with apiKey.
+func newRequest(t *testing.T, method, url string, body []byte) *http.Request {
+	t.Helper()
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err, "failed to create request")
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+apiKey)
+	return request
+}
+
 func TestEndToEndPaymentFlow(t *testing.T) {
 	t.Parallel()
 
 	// Setup
-	router := mux.NewRouter()
-	router.HandleFunc(utils.Path, server.ProcessPaymentHandler).Methods("POST")
-	router.HandleFunc(utils.Path+"/{id}", server.GetPaymentHandler).Methods("GET")
+	store := newStore()
+	gateway := server.New(server.Config{
+		Store:     store,
+		Merchants: store,
+		Bank:      mockbank.NewBankClient(),
+		APIKeys:   newAPIKeys(),
+	})
 
-	server := httptest.NewServer(router)
+	server := httptest.NewServer(gateway.Routes())
 	defer server.Close()
 
 	t.Run("process then fetch payment", func(t *testing.T) {
@@ -36,9 +73,7 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 		body, err := json.Marshal(utils.ValidProcessPaymentRequest())
 		r.NoError(err, "failed to marshal request")
 
-		ppRequest, err := http.NewRequest("POST", server.URL+utils.Path, bytes.NewReader(body))
-		r.NoError(err, "failed to create request")
-		ppRequest.Header.Set("Content-Type", "application/json")
+		ppRequest := newRequest(t, "POST", server.URL+utils.Path, body)
 
 		ppResponse, err := http.DefaultClient.Do(ppRequest)
 		r.NoError(err, "failed to process payment request")
@@ -51,8 +86,7 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 		r.NoError(err, "failed to unmarshal process payment response")
 
 		// Get the payment (gp)
-		gpRequest, err := http.NewRequest("GET", fmt.Sprintf("%s%s/%s", server.URL, utils.Path, maskedPayment.ID), nil)
-		r.NoError(err, "failed to create retrieve request")
+		gpRequest := newRequest(t, "GET", fmt.Sprintf("%s%s/%s", server.URL, utils.Path, maskedPayment.ID), nil)
 
 		gpResponse, err := http.DefaultClient.Do(gpRequest)
 		r.NoError(err, "failed to retrieve payment")
@@ -75,9 +109,7 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 		body, err := json.Marshal(data)
 		r.NoError(err, "failed to marshal request")
 
-		request, err := http.NewRequest("POST", server.URL+utils.Path, bytes.NewReader(body))
-		r.NoError(err, "failed to create request")
-		request.Header.Set("Content-Type", "application/json")
+		request := newRequest(t, "POST", server.URL+utils.Path, body)
 
 		response, err := http.DefaultClient.Do(request)
 		r.NoError(err, "failed to process payment request")
@@ -85,20 +117,27 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 
 		r.Equal(http.StatusBadRequest, response.StatusCode)
 
-		responseBody, err := io.ReadAll(response.Body)
-		r.NoError(err, "failed to read response body")
-		r.Contains(
-			string(bytes.TrimSpace(responseBody)),
-			"cvv",
-			"response error should contain some error message related to cvv",
-		)
+		errorResponse := decodeError(t, response)
+		r.Equal("validation_failed", errorResponse.Code)
+		r.Equal("cvv", errorResponse.Field, "response error should be about the cvv")
+		r.NotEmpty(errorResponse.RequestID)
+	})
+
+	t.Run("unauthenticated request is rejected", func(t *testing.T) {
+		r := require.New(t)
+
+		response, err := http.Get(server.URL + utils.Path)
+		r.NoError(err, "failed to list payments")
+		defer response.Body.Close()
+
+		r.Equal(http.StatusUnauthorized, response.StatusCode)
+		r.Equal("unauthorized", decodeError(t, response).Code)
 	})
 
 	t.Run("get nonexistent payment returns error", func(t *testing.T) {
 		r, a := require.New(t), assert.New(t)
 
-		request, err := http.NewRequest("GET", fmt.Sprintf("%s%s/%s", server.URL, utils.Path, uuid.New().String()), nil)
-		r.NoError(err, "failed to create retrieve request")
+		request := newRequest(t, "GET", fmt.Sprintf("%s%s/%s", server.URL, utils.Path, uuid.New().String()), nil)
 
 		response, err := http.DefaultClient.Do(request)
 		r.NoError(err, "failed to retrieve payment")
@@ -106,16 +145,15 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 
 		a.Equal(http.StatusNotFound, response.StatusCode)
 
-		responseBody, err := io.ReadAll(response.Body)
-		r.NoError(err, "failed to read response body")
-		r.Equal("payment not found", string(bytes.TrimSpace(responseBody)))
+		errorResponse := decodeError(t, response)
+		r.Equal("payment_not_found", errorResponse.Code)
+		r.Equal("payment not found", errorResponse.Message)
 	})
 
 	t.Run("get payment ID too long returns error", func(t *testing.T) {
 		r, a := require.New(t), assert.New(t)
 
-		request, err := http.NewRequest("GET", fmt.Sprintf("%s%s/%sa", server.URL, utils.Path, uuid.New().String()), nil)
-		r.NoError(err, "failed to create retrieve request")
+		request := newRequest(t, "GET", fmt.Sprintf("%s%s/%sa", server.URL, utils.Path, uuid.New().String()), nil)
 
 		response, err := http.DefaultClient.Do(request)
 		r.NoError(err, "failed to retrieve payment")
@@ -123,9 +161,270 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 
 		a.Equal(http.StatusBadRequest, response.StatusCode)
 
-		responseBody, err := io.ReadAll(response.Body)
-		r.NoError(err, "failed to read response body")
-		r.Equal("payment ID should have up to 36 characters", string(bytes.TrimSpace(responseBody)))
+		errorResponse := decodeError(t, response)
+		r.Equal("payment ID should have up to 36 characters", errorResponse.Message)
+		r.Equal("id", errorResponse.Field)
+	})
+
+}
+
+func TestEndToEndWithMockBankServer(t *testing.T) {
+	t.Parallel()
+
+	// Setup: the gateway calls the mock bank server over HTTP
+	bankServer := httptest.NewServer(mockbank.NewServer(mockbank.ServerOptions{}))
+	defer bankServer.Close()
+
+	store := newStore()
+	gateway := server.New(server.Config{
+		Store:     store,
+		Merchants: store,
+		Bank:      bank.NewHTTPClient(bankServer.URL, nil, nil),
+		APIKeys:   newAPIKeys(),
+	})
+
+	server := httptest.NewServer(gateway.Routes())
+	defer server.Close()
+
+	type testCase struct {
+		name           string
+		cardNumber     string
+		expectedStatus models.PaymentStatus
+	}
+
+	testCases := []testCase{
+		{"unscripted card succeeds", "4242424242424242", models.StatusSuccess},
+		{"declined card fails", "4000000000000002", models.StatusFailed},
+		{"insufficient funds card fails", "4000000000070005", models.StatusFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			data := utils.ValidProcessPaymentRequest()
+			data.CardNumber = tc.cardNumber
+			body, err := json.Marshal(data)
+			r.NoError(err, "failed to marshal request")
+
+			response, err := http.DefaultClient.Do(newRequest(t, "POST", server.URL+utils.Path, body))
+			r.NoError(err, "failed to process payment request")
+			defer response.Body.Close()
+			r.Equal(http.StatusOK, response.StatusCode)
+
+			var maskedPayment models.MaskedPayment
+			r.NoError(json.NewDecoder(response.Body).Decode(&maskedPayment), "failed to unmarshal response")
+			r.Equal(tc.expectedStatus, maskedPayment.Status)
+		})
+	}
+}
+
+func TestEndToEndRequestID(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	// Setup: the gateway calls the mock bank server over HTTP, which records the request IDs it receives
+	var (
+		mu                 sync.Mutex
+		receivedRequestIDs []string
+	)
+	mockBank := mockbank.NewServer(mockbank.ServerOptions{})
+	bankServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		mu.Lock()
+		receivedRequestIDs = append(receivedRequestIDs, request.Header.Get(bank.RequestIDHeader))
+		mu.Unlock()
+		mockBank.ServeHTTP(w, request)
+	}))
+	defer bankServer.Close()
+
+	store := newStore()
+	gateway := server.New(server.Config{
+		Store:     store,
+		Merchants: store,
+		Bank:      bank.NewHTTPClient(bankServer.URL, nil, nil),
+		APIKeys:   newAPIKeys(),
+	})
+	server := httptest.NewServer(gateway.Routes())
+	defer server.Close()
+
+	// A payment that fails at the bank can be traced by the merchant's request ID
+	data := utils.ValidProcessPaymentRequest()
+	data.CardNumber = "4000000000070500"
+	body, err := json.Marshal(data)
+	r.NoError(err, "failed to marshal request")
+	request := newRequest(t, "POST", server.URL+utils.Path, body)
+	request.Header.Set("X-Request-ID", "merchant-req-42")
+
+	response, err := http.DefaultClient.Do(request)
+	r.NoError(err, "failed to process payment request")
+	defer response.Body.Close()
+	r.Equal(http.StatusBadGateway, response.StatusCode)
+	r.Equal("merchant-req-42", response.Header.Get("X-Request-ID"))
+	r.Equal("merchant-req-42", decodeError(t, response).RequestID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	r.Equal([]string{"merchant-req-42"}, receivedRequestIDs)
+}
+
+func TestEndToEndTracing(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	// Setup: the gateway calls the mock bank server over HTTP, which records the traceparents it receives
+	var (
+		mu                   sync.Mutex
+		receivedTraceparents []string
+	)
+	mockBank := mockbank.NewServer(mockbank.ServerOptions{})
+	bankServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		mu.Lock()
+		receivedTraceparents = append(receivedTraceparents, request.Header.Get(tracing.TraceparentHeader))
+		mu.Unlock()
+		mockBank.ServeHTTP(w, request)
+	}))
+	defer bankServer.Close()
+
+	store := newStore()
+	exporter := tracing.NewInMemoryExporter()
+	gateway := server.New(server.Config{
+		Store:     store,
+		Merchants: store,
+		Bank:      bank.NewHTTPClient(bankServer.URL, nil, nil),
+		APIKeys:   newAPIKeys(),
+		Tracer:    tracing.NewTracer(exporter),
 	})
+	server := httptest.NewServer(gateway.Routes())
+	defer server.Close()
+
+	// A payment continues the merchant's trace, and the bank continues it from the span of the call to it
+	body, err := json.Marshal(utils.ValidProcessPaymentRequest())
+	r.NoError(err, "failed to marshal request")
+	request := newRequest(t, "POST", server.URL+utils.Path, body)
+	request.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	response, err := http.DefaultClient.Do(request)
+	r.NoError(err, "failed to process payment request")
+	defer response.Body.Close()
+	r.Equal(http.StatusOK, response.StatusCode)
+
+	var bankSpan tracing.SpanData
+	for _, span := range exporter.Spans() {
+		r.Equal("0af7651916cd43dd8448eb211c80319c", span.SpanContext.TraceID.String())
+		if span.Name == "BankClient.MakePayment" {
+			bankSpan = span
+		}
+	}
+	r.Equal("success", bankSpan.Attributes["outcome"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	r.Equal([]string{tracing.FormatTraceparent(bankSpan.SpanContext)}, receivedTraceparents)
+}
+
+func TestEndToEndWebhooks(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	// Setup: a receiver that fails its first request, so that the first delivery is retried
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received, bodies = append(received, request), append(bodies, body)
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	bankServer := httptest.NewServer(mockbank.NewServer(mockbank.ServerOptions{}))
+	defer bankServer.Close()
+
+	store := newStore()
+	gateway := server.New(server.Config{
+		Store:               store,
+		Merchants:           store,
+		Webhooks:            store,
+		Bank:                bank.NewHTTPClient(bankServer.URL, nil, nil),
+		APIKeys:             newAPIKeys(),
+		WebhookRetryBackoff: 10 * time.Millisecond,
+		// The receiver listens on a loopback address
+		WebhookAllowPrivateAddresses: true,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gateway.RunWebhooks(ctx)
+
+	server := httptest.NewServer(gateway.Routes())
+	defer server.Close()
+
+	// Register the receiver for failed payments
+	body, err := json.Marshal(models.WebhookEndpointRequest{
+		URL:        receiver.URL + "/hooks",
+		EventTypes: []models.WebhookEventType{models.EventPaymentFailed},
+	})
+	r.NoError(err, "failed to marshal request")
+	response, err := http.DefaultClient.Do(newRequest(t, "POST", server.URL+"/webhooks", body))
+	r.NoError(err, "failed to create webhook endpoint")
+	defer response.Body.Close()
+	r.Equal(http.StatusCreated, response.StatusCode)
+	var endpoint models.WebhookEndpoint
+	r.NoError(json.NewDecoder(response.Body).Decode(&endpoint), "failed to unmarshal response")
+
+	// Make a payment with a card that the bank declines
+	data := utils.ValidProcessPaymentRequest()
+	data.CardNumber = "4000000000000002"
+	body, err = json.Marshal(data)
+	r.NoError(err, "failed to marshal request")
+	response, err = http.DefaultClient.Do(newRequest(t, "POST", server.URL+utils.Path, body))
+	r.NoError(err, "failed to process payment request")
+	defer response.Body.Close()
+	var maskedPayment models.MaskedPayment
+	r.NoError(json.NewDecoder(response.Body).Decode(&maskedPayment), "failed to unmarshal response")
+	r.Equal(models.StatusFailed, maskedPayment.Status)
+
+	r.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, 5*time.Second, 10*time.Millisecond, "failed delivery should be retried")
+
+	mu.Lock()
+	request, requestBody := received[1], bodies[1]
+	mu.Unlock()
+	r.NoError(signing.Verify(endpoint.Secret, request.Header.Get(signing.Header), time.Now(), signing.DefaultTolerance, "POST", "/hooks", requestBody))
+	r.Equal("payment.failed", request.Header.Get("X-Webhook-Event"))
+	var event models.WebhookEvent
+	r.NoError(json.Unmarshal(requestBody, &event), "failed to unmarshal event")
+	var eventPayment models.MaskedPayment
+	r.NoError(json.Unmarshal(event.Data, &eventPayment), "failed to unmarshal event data")
+	r.Equal(maskedPayment, eventPayment)
+
+	// The deliveries API lists both attempts, once the second one has been recorded
+	var deliveries []models.WebhookDelivery
+	r.Eventually(func() bool {
+		response, err := http.DefaultClient.Do(newRequest(t, "GET", server.URL+"/webhooks/"+endpoint.ID+"/deliveries", nil))
+		if err != nil {
+			return false
+		}
+		defer response.Body.Close()
+		deliveries = nil
+		return json.NewDecoder(response.Body).Decode(&deliveries) == nil && len(deliveries) == 1 &&
+			deliveries[0].Status == models.DeliverySucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+	r.Len(deliveries[0].Attempts, 2)
+	r.Equal(http.StatusServiceUnavailable, deliveries[0].Attempts[0].StatusCode)
+	r.Equal(http.StatusOK, deliveries[0].Attempts[1].StatusCode)
+}
 
+// decodeError decodes the ErrorResponse in the body of response.
+func decodeError(t *testing.T, response *http.Response) models.ErrorResponse {
+	t.Helper()
+	errorResponse := models.ErrorResponse{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&errorResponse), "failed to unmarshal error response")
+	return errorResponse
 }
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C Trace Context header that carries the trace ID and parent span ID of a
+// request between services.
+const TraceparentHeader = "traceparent"
+
+// sampledFlag is the trace flag of a sampled trace.
+const sampledFlag = 0x01
+
+// ParseTraceparent parses a traceparent header value, like
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01. Values of future versions are parsed as far as
+// version 00 defines them, as the specification requires.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("traceparent should have 4 parts separated by dashes")
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	switch {
+	case len(version) != 2 || !isLowerHex(version) || version == "ff":
+		return SpanContext{}, fmt.Errorf("invalid traceparent version %q", version)
+	case version == "00" && len(parts) != 4:
+		return SpanContext{}, fmt.Errorf("traceparent version 00 should have 4 parts")
+	case len(traceID) != 32 || !isLowerHex(traceID):
+		return SpanContext{}, fmt.Errorf("traceparent trace ID should be 32 lower case hex digits")
+	case len(spanID) != 16 || !isLowerHex(spanID):
+		return SpanContext{}, fmt.Errorf("traceparent parent ID should be 16 lower case hex digits")
+	case len(flags) != 2 || !isLowerHex(flags):
+		return SpanContext{}, fmt.Errorf("traceparent flags should be 2 lower case hex digits")
+	}
+
+	var spanContext SpanContext
+	hex.Decode(spanContext.TraceID[:], []byte(traceID))
+	hex.Decode(spanContext.SpanID[:], []byte(spanID))
+	var decodedFlags [1]byte
+	hex.Decode(decodedFlags[:], []byte(flags))
+	spanContext.Sampled = decodedFlags[0]&sampledFlag != 0
+	if !spanContext.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent trace ID and parent ID should not be all zeros")
+	}
+	return spanContext, nil
+}
+
+// FormatTraceparent formats spanContext as a version 00 traceparent header value.
+func FormatTraceparent(spanContext SpanContext) string {
+	flags := "00"
+	if spanContext.Sampled {
+		flags = "01"
+	}
+	return "00-" + spanContext.TraceID.String() + "-" + spanContext.SpanID.String() + "-" + flags
+}
+
+// Extract returns a copy of ctx with the span context in the traceparent header of a request, so that
+// spans started with it continue the caller's trace. An invalid or missing traceparent is ignored, and
+// spans start a new trace.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	spanContext, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, spanContext)
+}
+
+// Inject sets the traceparent header of a request to the span context of ctx, so that the service it is
+// sent to continues the trace, if ctx has a span context.
+func Inject(ctx context.Context, header http.Header) {
+	if spanContext := SpanContextFromContext(ctx); spanContext.IsValid() {
+		header.Set(TraceparentHeader, FormatTraceparent(spanContext))
+	}
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name        string
+		traceparent string
+		expected    string // "" if the traceparent is invalid
+		sampled     bool
+	}
+
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	testCases := []testCase{
+		{"sampled", valid, valid, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"other flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03", valid, true},
+		{"future version with more parts", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid, true},
+		{"empty", "", "", false},
+		{"version 00 with more parts", valid + "-extra", "", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", "", false},
+		{"short trace ID", "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", "", false},
+		{"short parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01", "", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", false},
+		{"zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", false},
+		{"invalid flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			spanContext, err := ParseTraceparent(tc.traceparent)
+			if tc.expected == "" {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(tc.sampled, spanContext.Sampled)
+			r.Equal(tc.expected, FormatTraceparent(spanContext))
+		})
+	}
+}
+
+func TestExtractAndInject(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	inbound := http.Header{}
+	inbound.Set(TraceparentHeader, traceparent)
+	ctx := Extract(context.Background(), inbound)
+
+	outbound := http.Header{}
+	Inject(ctx, outbound)
+	r.Equal(traceparent, outbound.Get(TraceparentHeader), "a remote span context should be passed on as is")
+
+	ctx, span := NewTracer(nil).Start(ctx, "span")
+	defer span.End()
+	Inject(ctx, outbound)
+	r.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext().SpanID.String()+"-01", outbound.Get(TraceparentHeader))
+
+	invalid := http.Header{}
+	invalid.Set(TraceparentHeader, "invalid")
+	outbound = http.Header{}
+	Inject(Extract(context.Background(), invalid), outbound)
+	r.Empty(outbound, "an invalid traceparent should be ignored")
+}
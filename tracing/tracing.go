@@ -0,0 +1,231 @@
+/*
+Package tracing records spans of work in the style of OpenTelemetry, without depending on it:
+  - A Tracer starts spans, which are children of the span in the context they are started with, and
+    passes each ended span to its Exporter
+  - Spans have string attributes, and an error if the work failed
+  - The trace context is propagated between services in the W3C traceparent header, by Extract and Inject
+  - InMemoryExporter keeps spans for tests to inspect, and WriterExporter writes them as JSON lines, e.g.
+    to stdout, so no collector is needed
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, which is every span of one operation across services.
+type TraceID [16]byte
+
+// String returns the trace ID as 32 lower case hex digits.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText marshals the trace ID as its String.
+func (id TraceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// IsValid reports whether the trace ID is not all zeros, which W3C reserves as invalid.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the span ID as 16 lower case hex digits.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText marshals the span ID as its String.
+func (id SpanID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// IsValid reports whether the span ID is not all zeros, which W3C reserves as invalid.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is what identifies a span to its children, including children in other services.
+type SpanContext struct {
+	TraceID TraceID `json:"trace_id"`
+	SpanID  SpanID  `json:"span_id"`
+	// Sampled is whether the caller records the trace, which is passed on but does not change what is
+	// recorded here.
+	Sampled bool `json:"sampled"`
+	// Remote is set for a span context extracted from a request from another service.
+	Remote bool `json:"remote,omitempty"`
+}
+
+// IsValid reports whether the span context has valid IDs.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// SpanData is the record of an ended span that is exported.
+type SpanData struct {
+	Name        string      `json:"name"`
+	SpanContext SpanContext `json:"span_context"`
+	// Parent is the span context of the parent span, and is invalid for the root span of a trace.
+	Parent     SpanContext       `json:"parent"`
+	StartTime  time.Time         `json:"start_time"`
+	EndTime    time.Time         `json:"end_time"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Error describes why the work of the span failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Exporter receives every span once it has ended. Implementations must be safe for concurrent use.
+type Exporter interface {
+	ExportSpan(span SpanData)
+}
+
+// Tracer starts spans and exports them to its Exporter.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer instantiates a Tracer that exports spans to exporter. If exporter is nil, spans are discarded,
+// but the trace context is still propagated.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type spanContextKey struct{}
+
+// Start starts a span with the given name, which is a child of the span in ctx, or of the remote span
+// context extracted into ctx, or otherwise the root of a new trace. It returns a copy of ctx holding the
+// new span, and the span, which must be ended.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	spanContext := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		parent = SpanContext{}
+		spanContext.TraceID, spanContext.Sampled = newTraceID(), true
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			SpanContext: spanContext,
+			Parent:      parent,
+			StartTime:   time.Now(),
+			Attributes:  map[string]string{},
+		},
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SpanFromContext returns the span in ctx, or nil if ctx has none. A nil span can still be used, and
+// records nothing.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the span context of the span in ctx, or the remote span context extracted
+// into ctx, or an invalid span context if there is neither.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	switch value := ctx.Value(spanContextKey{}).(type) {
+	case *Span:
+		return value.data.SpanContext
+	case SpanContext:
+		return value
+	}
+	return SpanContext{}
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx with the span context of a span in another service,
+// which spans started with it are children of.
+func ContextWithRemoteSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	spanContext.Remote = true
+	return context.WithValue(ctx, spanContextKey{}, spanContext)
+}
+
+// Span is a span of work that has started. Its methods are safe for concurrent use, and do nothing on a
+// nil Span or once it has ended.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the span context of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttributes sets attributes of the span from pairs of keys and values.
+func (s *Span) SetAttributes(keysAndValues ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		s.data.Attributes[keysAndValues[i]] = keysAndValues[i+1]
+	}
+}
+
+// SetError records that the work of the span failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+// End ends the span and exports it. Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpanTree(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	childCtx, child := tracer.Start(ctx, "child")
+	_, grandchild := tracer.Start(childCtx, "grandchild")
+	grandchild.SetAttributes("outcome", "ok", "ignored")
+	grandchild.End()
+	child.SetError(errors.New("failed"))
+	child.End()
+	_, sibling := tracer.Start(ctx, "sibling")
+	sibling.End()
+	root.End()
+
+	spans := exporter.Spans()
+	r.Len(spans, 4)
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+		r.Equal(root.SpanContext().TraceID, span.SpanContext.TraceID, "every span should be in the same trace")
+		r.False(span.EndTime.Before(span.StartTime))
+	}
+	r.Equal([]string{"grandchild", "child", "sibling", "root"}, names)
+
+	r.False(spans[3].Parent.IsValid(), "the root span should have no parent")
+	r.Equal(root.SpanContext(), spans[1].Parent)
+	r.Equal(root.SpanContext(), spans[2].Parent)
+	r.Equal(child.SpanContext(), spans[0].Parent)
+	r.NotEqual(spans[1].SpanContext.SpanID, spans[2].SpanContext.SpanID)
+
+	r.Equal(map[string]string{"outcome": "ok"}, spans[0].Attributes)
+	r.Equal("failed", spans[1].Error)
+	r.Empty(spans[3].Error)
+
+	exporter.Reset()
+	r.Empty(exporter.Spans())
+}
+
+func TestSpanEndsOnce(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	exporter := NewInMemoryExporter()
+	_, span := NewTracer(exporter).Start(context.Background(), "span")
+	span.End()
+	span.SetAttributes("late", "attribute")
+	span.SetError(errors.New("late error"))
+	span.End()
+
+	spans := exporter.Spans()
+	r.Len(spans, 1)
+	r.Empty(spans[0].Attributes)
+	r.Empty(spans[0].Error)
+}
+
+func TestNilSpan(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	span := SpanFromContext(context.Background())
+	r.Nil(span)
+	span.SetAttributes("key", "value")
+	span.SetError(errors.New("error"))
+	span.End()
+	r.False(span.SpanContext().IsValid())
+}
+
+func TestRemoteParent(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	exporter := NewInMemoryExporter()
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+	r.Nil(SpanFromContext(ctx), "a remote span context is not a span")
+	_, span := NewTracer(exporter).Start(ctx, "span")
+	span.End()
+
+	spans := exporter.Spans()
+	r.Len(spans, 1)
+	r.Equal(remote.TraceID, spans[0].SpanContext.TraceID)
+	r.Equal(remote.SpanID, spans[0].Parent.SpanID)
+	r.True(spans[0].Parent.Remote)
+	r.False(spans[0].SpanContext.Sampled, "the caller's sampling decision should be passed on")
+}
+
+func TestWriterExporter(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+
+	output := &bytes.Buffer{}
+	ctx, parent := NewTracer(NewWriterExporter(output)).Start(context.Background(), "parent")
+	_, child := NewTracer(NewWriterExporter(output)).Start(ctx, "child")
+	child.SetAttributes("currency", "GBP")
+	child.End()
+
+	var written map[string]any
+	r.NoError(json.Unmarshal(output.Bytes(), &written))
+	r.Equal("child", written["name"])
+	r.Equal(map[string]any{"currency": "GBP"}, written["attributes"])
+	spanContext := written["span_context"].(map[string]any)
+	r.Equal(parent.SpanContext().TraceID.String(), spanContext["trace_id"])
+	r.Equal(child.SpanContext().SpanID.String(), spanContext["span_id"])
+	r.Equal(parent.SpanContext().SpanID.String(), written["parent"].(map[string]any)["span_id"])
+}
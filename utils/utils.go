@@ -1,17 +1,22 @@
 package utils
 
-import "github.com/celestebrant/processout-payment-gateway/models"
+import (
+	"time"
+
+	"github.com/celestebrant/processout-payment-gateway/models"
+)
 
 const Path = "/payments"
 
-// ValidProcessPaymentRequest generates a valid ProcessPaymentRequest which is useful for testing.
+// ValidProcessPaymentRequest generates a valid ProcessPaymentRequest which is useful for testing. Its card
+// expires in December, two years from now.
 func ValidProcessPaymentRequest() *models.ProcessPaymentRequest {
 	return &models.ProcessPaymentRequest{
-		CardNumber:  "1234123412341234",
-		ExpiryYear:  2099,
+		CardNumber:  "4242424242424242",
+		ExpiryYear:  uint(time.Now().Year() + 2),
 		ExpiryMonth: 12,
 		CVV:         "987",
-		Amount:      10.05,
+		Amount:      "1005",
 		Currency:    "GBP",
 	}
 }